@@ -2,6 +2,7 @@
 #define _sky_h
 
 #include "sky/sky_string.h"
+#include "sky/sky_factor_list.h"
 #include "sky/sky_cursor.h"
 
 #endif
@@ -26,6 +26,8 @@ typedef struct sky_cursor sky_cursor;
 
 typedef int (*sky_cursor_next_object_func)(void *cursor);
 
+typedef int (*sky_cursor_next_chunk_func)(void *cursor);
+
 typedef void (*sky_property_descriptor_set_func)(void *target, void *value, size_t *sz);
 typedef void (*sky_property_descriptor_clear_func)(void *target);
 
@@ -60,6 +62,7 @@ struct sky_cursor {
 
     void *context;
     sky_cursor_next_object_func next_object_func;
+    sky_cursor_next_chunk_func next_chunk_func;
 };
 
 
@@ -112,6 +115,8 @@ bool sky_cursor_next_object(sky_cursor *cursor);
 
 void sky_cursor_set_ptr(sky_cursor *cursor, void *ptr, size_t sz);
 
+void sky_cursor_set_chunk_ptr(sky_cursor *cursor, void *ptr, size_t sz);
+
 void sky_cursor_next_event(sky_cursor *cursor);
 
 bool sky_lua_cursor_next_event(sky_cursor *cursor);
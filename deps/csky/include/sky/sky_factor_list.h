@@ -0,0 +1,28 @@
+#ifndef _sky_factor_list_h
+#define _sky_factor_list_h
+
+#include <inttypes.h>
+
+//==============================================================================
+//
+// Typedefs
+//
+//==============================================================================
+
+// A list of factors stored as a msgpack array. The data points at the first
+// element in the raw event data.
+typedef struct {
+  int32_t count;
+  void *data;
+} sky_factor_list;
+
+
+//==============================================================================
+//
+// Functions
+//
+//==============================================================================
+
+int64_t sky_factor_list_at(sky_factor_list *list, uint32_t index);
+
+#endif
@@ -5,6 +5,7 @@
 #include "sky/timestamp.h"
 #include "sky/minipack.h"
 #include "sky/sky_string.h"
+#include "sky/sky_factor_list.h"
 #include "sky/dbg.h"
 
 //==============================================================================
@@ -50,6 +51,8 @@ void sky_set_double(void *target, void *value, size_t *sz);
 
 void sky_set_boolean(void *target, void *value, size_t *sz);
 
+void sky_set_factor_list(void *target, void *value, size_t *sz);
+
 
 //--------------------------------------
 // Clear Functions
@@ -63,6 +66,8 @@ void sky_clear_double(void *target);
 
 void sky_clear_boolean(void *target);
 
+void sky_clear_factor_list(void *target);
+
 
 //==============================================================================
 //
@@ -167,7 +172,7 @@ void sky_cursor_set_property(sky_cursor *cursor, int64_t property_id,
         property_descriptor->set_func = sky_set_string;
         property_descriptor->clear_func = sky_clear_string;
     }
-    else if(strcmp(data_type, "factor") == 0 || strcmp(data_type, "integer") == 0) {
+    else if(strcmp(data_type, "factor") == 0 || strcmp(data_type, "integer") == 0 || strcmp(data_type, "timestamp") == 0) {
         property_descriptor->set_func = sky_set_int;
         property_descriptor->clear_func = sky_clear_int;
     }
@@ -179,6 +184,10 @@ void sky_cursor_set_property(sky_cursor *cursor, int64_t property_id,
         property_descriptor->set_func = sky_set_boolean;
         property_descriptor->clear_func = sky_clear_boolean;
     }
+    else if(strcmp(data_type, "factor[]") == 0) {
+        property_descriptor->set_func = sky_set_factor_list;
+        property_descriptor->clear_func = sky_clear_factor_list;
+    }
     else {
         property_descriptor->set_func = sky_set_boolean;
         property_descriptor->clear_func = sky_clear_boolean;
@@ -229,6 +238,15 @@ void sky_cursor_set_ptr(sky_cursor *cursor, void *ptr, size_t sz)
     }
 }
 
+// Continues the current object's event stream in another block of memory.
+// The cursor state and session are left untouched.
+void sky_cursor_set_chunk_ptr(sky_cursor *cursor, void *ptr, size_t sz)
+{
+    cursor->startptr = ptr;
+    cursor->nextptr  = ptr;
+    cursor->endptr   = ptr + sz;
+}
+
 void sky_cursor_next_event(sky_cursor *cursor)
 {
     // Ignore any calls when the cursor is out of session or EOF.
@@ -239,6 +257,14 @@ void sky_cursor_next_event(sky_cursor *cursor)
     // Move the pointer to the next position.
     void *prevptr = cursor->ptr;
     cursor->ptr = cursor->nextptr;
+
+    // If the current chunk is exhausted then move to the object's next chunk.
+    while(cursor->ptr >= cursor->endptr && cursor->next_chunk_func != NULL) {
+        if(!cursor->next_chunk_func(cursor)) {
+            break;
+        }
+        cursor->ptr = cursor->nextptr;
+    }
     void *ptr = cursor->ptr;
 
     // If pointer is beyond the last event then set eof.
@@ -388,7 +414,7 @@ void sky_set_string(void *target, void *value, size_t *sz)
 
 void sky_set_int(void *target, void *value, size_t *sz)
 {
-    *((int32_t*)target) = (int32_t)minipack_unpack_int(value, sz);
+    *((int64_t*)target) = minipack_unpack_int(value, sz);
 }
 
 void sky_set_double(void *target, void *value, size_t *sz)
@@ -401,6 +427,36 @@ void sky_set_boolean(void *target, void *value, size_t *sz)
     *((bool*)target) = minipack_unpack_bool(value, sz);
 }
 
+// Points the list at the elements of a msgpack array of factors. The size
+// covers the array header and every element.
+void sky_set_factor_list(void *target, void *value, size_t *sz)
+{
+    size_t _sz;
+    sky_factor_list *list = (sky_factor_list*)target;
+    uint32_t count = minipack_unpack_array(value, &_sz);
+    if(_sz == 0) {
+        *sz = 0;
+        return;
+    }
+    void *data = value + _sz;
+
+    // Skip over each element.
+    void *ptr = data;
+    uint32_t i;
+    for(i=0; i<count; i++) {
+        minipack_unpack_int(ptr, &_sz);
+        if(_sz == 0) {
+            *sz = 0;
+            return;
+        }
+        ptr += _sz;
+    }
+
+    list->count = (int32_t)count;
+    list->data = data;
+    *sz = ptr - value;
+}
+
 
 //--------------------------------------
 // Clear Functions
@@ -415,7 +471,7 @@ void sky_clear_string(void *target)
 
 void sky_clear_int(void *target)
 {
-    *((int32_t*)target) = 0;
+    *((int64_t*)target) = 0;
 }
 
 void sky_clear_double(void *target)
@@ -428,3 +484,10 @@ void sky_clear_boolean(void *target)
     *((bool*)target) = false;
 }
 
+void sky_clear_factor_list(void *target)
+{
+    sky_factor_list *list = (sky_factor_list*)target;
+    list->count = 0;
+    list->data = NULL;
+}
+
@@ -235,7 +235,20 @@ size_t minipack_sizeof_elem_and_data(void *ptr)
     uint32_t length = minipack_unpack_raw(ptr, &sz);
     if(sz > 0) return sz + length;
     
-    // Map, Array and other data returns 0.
+    // Array
+    uint32_t count = minipack_unpack_array(ptr, &sz);
+    if(sz > 0) {
+        void *elemptr = ptr + sz;
+        uint32_t i;
+        for(i=0; i<count; i++) {
+            size_t elem_sz = minipack_sizeof_elem_and_data(elemptr);
+            if(elem_sz == 0) return 0;
+            elemptr += elem_sz;
+        }
+        return elemptr - ptr;
+    }
+
+    // Map and other data returns 0.
     return 0;
 }
 
@@ -0,0 +1,31 @@
+#include <stdlib.h>
+#include "sky/sky_factor_list.h"
+#include "sky/minipack.h"
+
+//==============================================================================
+//
+// Functions
+//
+//==============================================================================
+
+// Retrieves the factor at a given index in the list. Returns zero if the
+// index is out of range or the list data is invalid.
+int64_t sky_factor_list_at(sky_factor_list *list, uint32_t index)
+{
+    if(list->data == NULL || index >= (uint32_t)list->count) {
+        return 0;
+    }
+
+    // Skip over the preceding elements.
+    size_t sz;
+    void *ptr = list->data;
+    uint32_t i;
+    for(i=0; i<index; i++) {
+        minipack_unpack_int(ptr, &sz);
+        if(sz == 0) return 0;
+        ptr += sz;
+    }
+
+    int64_t value = minipack_unpack_int(ptr, &sz);
+    return (sz > 0 ? value : 0);
+}
@@ -5,6 +5,7 @@
 
 #include <sky/cursor.h>
 #include <sky/sky_string.h>
+#include <sky/sky_factor_list.h>
 #include <sky/timestamp.h>
 #include <sky/mem.h>
 
@@ -26,6 +27,10 @@ char BOOLEAN_TRUE_DATA[] = "\xC3";
 
 char STRING_DATA[] = "\xa3\x66\x6f\x6f";
 
+char INT64_DATA[] = "\xD3\x00\x00\x01\x00\x00\x00\x00\x00";
+
+char FACTOR_LIST_DATA[] = "\x93\x01\xCC\xC8\xCD\x01\x2C";
+
 
 int DATA0_LENGTH = 129;
 char *DATA0 = "\xA0"
@@ -75,6 +80,23 @@ char *DATA5 = "\xA0"
   "\x92" "\xD3\x00\x00\x00\x00\x00\xA0\x00\x00" "\x81" "\x01\x14"
 ;
 
+int DATA6_LENGTH = 1;
+char *DATA6 = "\xA0";
+
+int CHUNK0_LENGTH = 13;
+char *CHUNK0 =
+  // 1970-01-01T00:00:00Z, {1:2}
+  "\x92" "\xD3\x00\x00\x00\x00\x00\x00\x00\x00" "\x81" "\x01\x02"
+;
+
+int CHUNK1_LENGTH = 26;
+char *CHUNK1 =
+  // 1970-01-01T00:00:01Z, {1:3}
+  "\x92" "\xD3\x00\x00\x00\x00\x00\x10\x00\x00" "\x81" "\x01\x03"
+  // 1970-01-01T00:00:02Z, {1:4}
+  "\x92" "\xD3\x00\x00\x00\x00\x00\x20\x00\x00" "\x81" "\x01\x04"
+;
+
 
 //==============================================================================
 //
@@ -110,11 +132,11 @@ char *DATA5 = "\xA0"
 typedef struct {
     sky_string action;
     sky_string action_string;
-    int32_t    action_int;
+    int64_t    action_int;
     double     action_double;
     bool       action_boolean;
     sky_string object_string;
-    int32_t    object_int;
+    int64_t    object_int;
     double     object_double;
     bool       object_boolean;
     uint32_t timestamp;
@@ -123,10 +145,11 @@ typedef struct {
 
 typedef struct {
     int64_t dummy;
-    int32_t int_value;
+    int64_t int_value;
     double double_value;
     bool boolean_value;
     sky_string string_value;
+    sky_factor_list factor_list_value;
     uint32_t timestamp;
     int64_t ts;
 } test2_t;
@@ -148,11 +171,11 @@ int test_sky_cursor_set_data() {
     sky_cursor_set_ts_offset(cursor, offsetof(test_t, ts));
     sky_cursor_set_property(cursor, -5, offsetof(test_t, action_boolean), sizeof(bool), "boolean");
     sky_cursor_set_property(cursor, -4, offsetof(test_t, action_double), sizeof(double), "float");
-    sky_cursor_set_property(cursor, -3, offsetof(test_t, action_int), sizeof(int32_t), "integer");
+    sky_cursor_set_property(cursor, -3, offsetof(test_t, action_int), sizeof(int64_t), "integer");
     sky_cursor_set_property(cursor, -2, offsetof(test_t, action_string), sizeof(sky_string), "string");
     sky_cursor_set_property(cursor, -1, offsetof(test_t, action), sizeof(sky_string), "string");
     sky_cursor_set_property(cursor, 1, offsetof(test_t, object_string), sizeof(sky_string), "string");
-    sky_cursor_set_property(cursor, 2, offsetof(test_t, object_int), sizeof(int32_t), "integer");
+    sky_cursor_set_property(cursor, 2, offsetof(test_t, object_int), sizeof(int64_t), "integer");
     sky_cursor_set_property(cursor, 3, offsetof(test_t, object_double), sizeof(double), "float");
     sky_cursor_set_property(cursor, 4, offsetof(test_t, object_boolean), sizeof(bool), "boolean");
     sky_cursor_set_data_sz(cursor, sizeof(test_t));
@@ -193,9 +216,9 @@ int test_sky_cursor_sessionize() {
     sky_cursor *cursor = sky_cursor_new(-2, 1);
     sky_cursor_set_timestamp_offset(cursor, offsetof(test_t, timestamp));
     sky_cursor_set_ts_offset(cursor, offsetof(test_t, ts));
-    sky_cursor_set_property(cursor, -2, offsetof(test_t, action_int), sizeof(int32_t), "integer");
+    sky_cursor_set_property(cursor, -2, offsetof(test_t, action_int), sizeof(int64_t), "integer");
     sky_cursor_set_property(cursor, -1, offsetof(test_t, action), sizeof(sky_string), "string");
-    sky_cursor_set_property(cursor, 1, offsetof(test_t, object_int), sizeof(int32_t), "integer");
+    sky_cursor_set_property(cursor, 1, offsetof(test_t, object_int), sizeof(int64_t), "integer");
     sky_cursor_set_data_sz(cursor, sizeof(test_t));
 
     // Initialize data and set a 10 second idle time.
@@ -309,7 +332,7 @@ int test_sky_cursor_object_iteration() {
     cursor->next_object_func = next_obj;
     sky_cursor_set_ts_offset(cursor, offsetof(test2_t, ts));
     sky_cursor_set_timestamp_offset(cursor, offsetof(test2_t, timestamp));
-    sky_cursor_set_property(cursor, 1, offsetof(test2_t, int_value), sizeof(int32_t), "integer");
+    sky_cursor_set_property(cursor, 1, offsetof(test2_t, int_value), sizeof(int64_t), "integer");
     sky_cursor_set_data_sz(cursor, sizeof(test2_t));
     test2_t *obj = (test2_t*)cursor->data;
 
@@ -336,6 +359,60 @@ int test_sky_cursor_object_iteration() {
 }
 
 
+//--------------------------------------
+// Chunk Iteration
+//--------------------------------------
+
+int next_chunk(void *_cursor) {
+  size_t sz;
+  void *ptr = NULL;
+
+  sky_cursor *cursor = (sky_cursor*)_cursor;
+  if(cursor->context == NULL) {
+      ptr = CHUNK0; sz = CHUNK0_LENGTH;
+  } else if(cursor->context == CHUNK0) {
+      ptr = CHUNK1; sz = CHUNK1_LENGTH;
+  }
+
+  if(ptr != NULL) {
+      cursor->context = ptr;
+      sky_cursor_set_chunk_ptr(cursor, ptr, sz);
+      return 1;
+  }
+  else {
+      return 0;
+  }
+}
+
+int test_sky_cursor_chunk_iteration() {
+    // Setup cursor.
+    sky_cursor *cursor = sky_cursor_new(0, 1);
+    cursor->next_chunk_func = next_chunk;
+    sky_cursor_set_ts_offset(cursor, offsetof(test2_t, ts));
+    sky_cursor_set_timestamp_offset(cursor, offsetof(test2_t, timestamp));
+    sky_cursor_set_property(cursor, 1, offsetof(test2_t, int_value), sizeof(int64_t), "integer");
+    sky_cursor_set_data_sz(cursor, sizeof(test2_t));
+    test2_t *obj = (test2_t*)cursor->data;
+
+    // Start with a state-only value and stream events from each chunk.
+    sky_cursor_set_ptr(cursor, DATA6, DATA6_LENGTH);
+    mu_assert_bool(sky_lua_cursor_next_event(cursor));
+    mu_assert_int64_equals(obj->int_value, 2LL);
+    mu_assert_int_equals(obj->timestamp, 0);
+    mu_assert_bool(sky_lua_cursor_next_event(cursor));
+    mu_assert_int64_equals(obj->int_value, 3LL);
+    mu_assert_int_equals(obj->timestamp, 1);
+    mu_assert_bool(sky_lua_cursor_next_event(cursor));
+    mu_assert_int64_equals(obj->int_value, 4LL);
+    mu_assert_int_equals(obj->timestamp, 2);
+    mu_assert_bool(!sky_lua_cursor_next_event(cursor));
+    mu_assert_bool(cursor->eof == true);
+
+    sky_cursor_free(cursor);
+    return 0;
+}
+
+
 //--------------------------------------
 // Property Management
 //--------------------------------------
@@ -343,12 +420,15 @@ int test_sky_cursor_object_iteration() {
 int test_sky_cursor_set_integer() {
     size_t sz;
     sky_cursor *cursor = sky_cursor_new(0, 1);
-    sky_cursor_set_property(cursor, 1, offsetof(test2_t, int_value), sizeof(int32_t), "integer");
+    sky_cursor_set_property(cursor, 1, offsetof(test2_t, int_value), sizeof(int64_t), "integer");
     sky_cursor_set_data_sz(cursor, sizeof(test2_t));
     mu_assert_int_equals(cursor->property_zero_descriptor[1].offset, 8);
     sky_cursor_set_value(cursor, cursor->data, 1, INT_DATA, &sz);
     mu_assert_long_equals(sz, 3L);
     mu_assert_int64_equals(((test2_t*)cursor->data)->int_value, 1000LL);
+    sky_cursor_set_value(cursor, cursor->data, 1, INT64_DATA, &sz);
+    mu_assert_long_equals(sz, 9L);
+    mu_assert_int64_equals(((test2_t*)cursor->data)->int_value, 1099511627776LL);
     sky_cursor_free(cursor);
     return 0;
 }
@@ -396,6 +476,33 @@ int test_sky_cursor_set_string() {
     return 0;
 }
 
+int test_sky_cursor_set_factor_list() {
+    size_t sz;
+    sky_cursor *cursor = sky_cursor_new(0, 1);
+    sky_cursor_set_data_sz(cursor, sizeof(test2_t));
+    sky_cursor_set_property(cursor, 1, offsetof(test2_t, factor_list_value), sizeof(sky_factor_list), "factor[]");
+    sky_cursor_set_value(cursor, cursor->data, 1, FACTOR_LIST_DATA, &sz);
+    mu_assert_long_equals(sz, 7L);
+    sky_factor_list *list = &((test2_t*)cursor->data)->factor_list_value;
+    mu_assert_int_equals(list->count, 3);
+    mu_assert_int64_equals(sky_factor_list_at(list, 0), 1LL);
+    mu_assert_int64_equals(sky_factor_list_at(list, 1), 200LL);
+    mu_assert_int64_equals(sky_factor_list_at(list, 2), 300LL);
+    mu_assert_int64_equals(sky_factor_list_at(list, 3), 0LL);
+    sky_cursor_free(cursor);
+    return 0;
+}
+
+int test_sky_cursor_skip_factor_list() {
+    size_t sz;
+    sky_cursor *cursor = sky_cursor_new(0, 1);
+    sky_cursor_set_data_sz(cursor, sizeof(test2_t));
+    sky_cursor_set_value(cursor, cursor->data, 1, FACTOR_LIST_DATA, &sz);
+    mu_assert_long_equals(sz, 7L);
+    sky_cursor_free(cursor);
+    return 0;
+}
+
 
 
 //==============================================================================
@@ -408,11 +515,14 @@ int all_tests() {
     mu_run_test(test_sky_cursor_set_data);
     mu_run_test(test_sky_cursor_sessionize);
     mu_run_test(test_sky_cursor_object_iteration);
+    mu_run_test(test_sky_cursor_chunk_iteration);
     
     mu_run_test(test_sky_cursor_set_integer);
     mu_run_test(test_sky_cursor_set_double);
     mu_run_test(test_sky_cursor_set_boolean);
     mu_run_test(test_sky_cursor_set_string);
+    mu_run_test(test_sky_cursor_set_factor_list);
+    mu_run_test(test_sky_cursor_skip_factor_list);
     return 0;
 }
 
@@ -24,6 +24,13 @@ const (
 const (
 	portUsage = "the port to listen on"
 	dataDirUsage = "the data directory"
+	backupDirUsage = "the directory that backups are written to"
+	cacheSizeUsage = "the size of the shared LevelDB block cache in megabytes"
+	bloomFilterBitsUsage = "the number of LevelDB bloom filter bits per key"
+	writeBufferSizeUsage = "the size of each LevelDB write buffer in megabytes"
+	maxOpenFilesUsage = "the maximum number of open files per LevelDB database"
+	compressionUsage = "compress LevelDB blocks with snappy"
+	paranoidChecksUsage = "enable LevelDB paranoid checks"
 )
 
 const (
@@ -38,6 +45,13 @@ const (
 
 var port uint
 var dataDir string
+var backupDir string
+var cacheSize int
+var bloomFilterBits int
+var writeBufferSize int
+var maxOpenFiles int
+var compression bool
+var paranoidChecks bool
 
 //------------------------------------------------------------------------------
 //
@@ -54,6 +68,13 @@ func init() {
 	flag.UintVar(&port, "p", defaultPort, portUsage+"(shorthand)")
 	flag.StringVar(&dataDir, "data-dir", defaultDataDir, dataDirUsage)
 	flag.StringVar(&dataDir, "d", defaultDataDir, dataDirUsage+"(shorthand)")
+	flag.StringVar(&backupDir, "backup-dir", "", backupDirUsage)
+	flag.IntVar(&cacheSize, "cache-size", skyd.DefaultCacheSize/(1024*1024), cacheSizeUsage)
+	flag.IntVar(&bloomFilterBits, "bloom-filter-bits", skyd.DefaultBloomFilterBits, bloomFilterBitsUsage)
+	flag.IntVar(&writeBufferSize, "write-buffer-size", skyd.DefaultWriteBufferSize/(1024*1024), writeBufferSizeUsage)
+	flag.IntVar(&maxOpenFiles, "max-open-files", skyd.DefaultMaxOpenFiles, maxOpenFilesUsage)
+	flag.BoolVar(&compression, "compression", true, compressionUsage)
+	flag.BoolVar(&paranoidChecks, "paranoid-checks", false, paranoidChecksUsage)
 }
 
 //--------------------------------------
@@ -64,11 +85,29 @@ func main() {
 	// Parse the command line arguments.
 	flag.Parse()
 	
+	// Run a command instead of the server if one is specified.
+	switch flag.Arg(0) {
+	case "restore":
+		restore(flag.Arg(1))
+		return
+	case "reshard":
+		reshard(flag.Args()[1:])
+		return
+	case "check":
+		check(flag.Args()[1:])
+		return
+	case "migrate":
+		migrate()
+		return
+	}
+	
 	// Hardcore parallelism right here.
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	
 	// Initialize
 	server := skyd.NewServer(port, dataDir)
+	server.SetDatabaseOptions(databaseOptions())
+	server.SetBackupPath(backupDir)
 	writePidFile()
 	//setupSignalHandlers(server)
 	
@@ -84,6 +123,79 @@ func main() {
 	cleanup(server)
 }
 
+//--------------------------------------
+// Commands
+//--------------------------------------
+
+// Rebuilds the data directory from a backup directory.
+func restore(backupDir string) {
+	if backupDir == "" {
+		fmt.Fprintln(os.Stderr, "usage: skyd [-d data-dir] restore BACKUP_DIR")
+		os.Exit(1)
+	}
+	if err := skyd.Restore(backupDir, dataDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to restore: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Restored %s to %s\n", backupDir, dataDir)
+}
+
+// Rehashes every object in the data directory into a new number of servlets.
+func reshard(args []string) {
+	flags := flag.NewFlagSet("reshard", flag.ExitOnError)
+	servletCount := flags.Int("servlets", 0, "the number of servlets")
+	flags.Parse(args)
+	if *servletCount <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: skyd [-d data-dir] reshard --servlets N")
+		os.Exit(1)
+	}
+	if err := skyd.Reshard(dataDir, *servletCount); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to reshard: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Resharded %s into %d servlets. The previous layout is in %s/data.old\n", dataDir, *servletCount, dataDir)
+}
+
+// Splits each servlet's shared database into one store per table.
+func migrate() {
+	if err := skyd.Migrate(dataDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to migrate: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Migrated %s to one store per table. The previous layout is in %s/data.old\n", dataDir, dataDir)
+}
+
+// Verifies the integrity of every object in the data directory.
+func check(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair damaged objects and quarantine the rest")
+	quarantine := flags.Bool("quarantine", false, "move damaged objects to a quarantine database")
+	flags.Parse(args)
+	mode := skyd.CheckReport
+	if *repair {
+		mode = skyd.CheckRepair
+	} else if *quarantine {
+		mode = skyd.CheckQuarantine
+	}
+	
+	result, err := skyd.Check(dataDir, mode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to check: %v\n", err)
+		os.Exit(1)
+	}
+	for _, problem := range result.Problems {
+		fmt.Printf("servlet %d: %s/%s (%s): %s", problem.Servlet, problem.Table, problem.ObjectId, problem.Key, problem.Message)
+		if problem.Action != "" {
+			fmt.Printf(" [%s]", problem.Action)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Checked %d objects, found %d problems\n", result.ObjectCount, len(result.Problems))
+	if mode == skyd.CheckReport && len(result.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
 //--------------------------------------
 // Signals
 //--------------------------------------
@@ -106,6 +218,18 @@ func setupSignalHandlers(server *skyd.Server) {
 // Utility
 //--------------------------------------
 
+// Builds the LevelDB options from the command line arguments.
+func databaseOptions() *skyd.DatabaseOptions {
+	options := skyd.NewDatabaseOptions()
+	options.CacheSize = cacheSize * 1024 * 1024
+	options.BloomFilterBits = bloomFilterBits
+	options.WriteBufferSize = writeBufferSize * 1024 * 1024
+	options.MaxOpenFiles = maxOpenFiles
+	options.Compression = compression
+	options.ParanoidChecks = paranoidChecks
+	return options
+}
+
 // Shuts down the server socket and closes the database.
 func cleanup(server *skyd.Server) {
 	if server != nil {
@@ -0,0 +1,386 @@
+package skyd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmhodges/levigo"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The version of the backup format. Version 1 backups hold one database per
+// servlet and are restored into the layout that must be migrated.
+const BackupVersion = 2
+
+// The number of keys written to a backup database in a single batch.
+const backupBatchSize = 1000
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A BackupManifest describes the contents of a backup directory. Servlets
+// lists the store for each table on each servlet.
+type BackupManifest struct {
+	Version      int               `json:"version"`
+	Timestamp    string            `json:"timestamp"`
+	ServletCount int               `json:"servletCount,omitempty"`
+	Servlets     []*BackupDatabase `json:"servlets"`
+	Factors      *BackupDatabase   `json:"factors"`
+	Tables       []string          `json:"tables"`
+}
+
+// A BackupDatabase describes a single LevelDB database within a backup. The
+// checksum is calculated over every key and value in order.
+type BackupDatabase struct {
+	Path     string `json:"path"`
+	KeyCount int    `json:"keyCount"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// A backupSnapshot is a snapshot of a database along with the path it is
+// copied to within a backup. Snapshots of table stores hold a reference to
+// the store until they're released.
+type backupSnapshot struct {
+	path     string
+	snapshot StorageSnapshot
+	store    *tableStore
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Validates the manifest of a backup directory against the databases in it.
+func ValidateBackup(path string) (*BackupManifest, error) {
+	file, err := os.Open(filepath.Join(path, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("skyd: Unable to open backup manifest: %v", err)
+	}
+	defer file.Close()
+
+	manifest := &BackupManifest{}
+	if err := json.NewDecoder(file).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("skyd: Invalid backup manifest: %v", err)
+	}
+	if manifest.Version != 1 && manifest.Version != BackupVersion {
+		return nil, fmt.Errorf("skyd: Unsupported backup version: %v", manifest.Version)
+	}
+	if manifest.Version == 1 {
+		manifest.ServletCount = len(manifest.Servlets)
+	}
+	if manifest.ServletCount <= 0 || manifest.Factors == nil {
+		return nil, errors.New("skyd: Backup manifest is incomplete.")
+	}
+
+	// Verify each database.
+	databases := append([]*BackupDatabase{manifest.Factors}, manifest.Servlets...)
+	for _, database := range databases {
+		if err := validateBackupDatabase(path, database); err != nil {
+			return nil, err
+		}
+	}
+
+	// Verify each table.
+	for _, name := range manifest.Tables {
+		if _, err := os.Stat(filepath.Join(path, "tables", name)); err != nil {
+			return nil, fmt.Errorf("skyd: Backup table missing: %v", name)
+		}
+	}
+
+	return manifest, nil
+}
+
+// Rebuilds a data directory from a backup. The data directory must not exist
+// or must be empty. Data restored from a version 1 backup must be migrated
+// before the server is started.
+func Restore(backupPath string, path string) error {
+	manifest, err := ValidateBackup(backupPath)
+	if err != nil {
+		return err
+	}
+
+	// Make sure we don't overwrite existing data.
+	if infos, err := ioutil.ReadDir(path); err == nil && len(infos) > 0 {
+		return fmt.Errorf("skyd: Data directory is not empty: %v", path)
+	}
+
+	// Copy databases and tables into place.
+	databases := append([]*BackupDatabase{manifest.Factors}, manifest.Servlets...)
+	for _, database := range databases {
+		if err := copyDir(filepath.Join(backupPath, database.Path), filepath.Join(path, database.Path)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < manifest.ServletCount; i++ {
+		if err := os.MkdirAll(filepath.Join(path, "data", fmt.Sprintf("%d", i)), 0700); err != nil {
+			return err
+		}
+	}
+	if err := writeServletCount(filepath.Join(path, "data"), manifest.ServletCount); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(path, "tables"), 0700); err != nil {
+		return err
+	}
+	for _, name := range manifest.Tables {
+		if err := copyDir(filepath.Join(backupPath, "tables", name), filepath.Join(path, "tables", name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks that a database in a backup matches its key count and checksum.
+func validateBackupDatabase(path string, database *BackupDatabase) error {
+	opts := levigo.NewOptions()
+	defer opts.Close()
+	db, err := levigo.Open(filepath.Join(path, database.Path), opts)
+	if err != nil {
+		return fmt.Errorf("skyd: Unable to open backup database: %v: %v", database.Path, err)
+	}
+	defer db.Close()
+
+	ro := levigo.NewReadOptions()
+	defer ro.Close()
+	iterator := db.NewIterator(ro)
+	defer iterator.Close()
+
+	count, hash := 0, crc32.NewIEEE()
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		hash.Write(iterator.Key())
+		hash.Write(iterator.Value())
+		count++
+	}
+	if count != database.KeyCount || hash.Sum32() != database.Checksum {
+		return fmt.Errorf("skyd: Backup database is corrupt: %v", database.Path)
+	}
+
+	return nil
+}
+
+// Copies the contents of a snapshot into a new LevelDB database.
+func copySnapshot(snapshot StorageSnapshot, path string) (*BackupDatabase, error) {
+	opts := levigo.NewOptions()
+	defer opts.Close()
+	opts.SetCreateIfMissing(true)
+	opts.SetErrorIfExists(true)
+	dst, err := levigo.Open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	wo := levigo.NewWriteOptions()
+	defer wo.Close()
+	iterator := snapshot.NewIterator()
+	defer iterator.Close()
+
+	// Copy keys over in batches.
+	database := &BackupDatabase{}
+	hash := crc32.NewIEEE()
+	batch := levigo.NewWriteBatch()
+	defer batch.Close()
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		key, value := iterator.Key(), iterator.Value()
+		hash.Write(key)
+		hash.Write(value)
+		batch.Put(key, value)
+		database.KeyCount++
+
+		if database.KeyCount%backupBatchSize == 0 {
+			if err := dst.Write(wo, batch); err != nil {
+				return nil, err
+			}
+			batch.Clear()
+		}
+	}
+	if err := dst.Write(wo, batch); err != nil {
+		return nil, err
+	}
+	database.Checksum = hash.Sum32()
+
+	return database, nil
+}
+
+// Recursively copies a directory.
+func copyDir(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// Copies a single file.
+func copyFile(src string, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Writes a consistent copy of every servlet, the factors database and the
+// table schemas to a new directory. Writes are paused only while snapshots
+// are taken and the table files are copied.
+func (s *Server) Backup(path string) (*BackupManifest, error) {
+	if s.factors == nil {
+		return nil, errors.New("skyd.Server: Server is not open.")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Backup path already exists: %v", path)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "data"), 0700); err != nil {
+		return nil, err
+	}
+
+	manifest, err := s.backup(path)
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Writes the databases, tables and manifest into a backup directory.
+func (s *Server) backup(path string) (*BackupManifest, error) {
+	manifest := &BackupManifest{Version: BackupVersion, ServletCount: len(s.servlets), Servlets: []*BackupDatabase{}, Tables: []string{}}
+
+	// Take snapshots and copy tables at a single point in time.
+	snapshots, err := s.snapshot(filepath.Join(path, "tables"), manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, snapshot := range snapshots {
+			snapshot.release()
+		}
+	}()
+
+	// Copy each snapshot into the backup. The factors database is last.
+	for i, snapshot := range snapshots {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(path, snapshot.path)), 0700); err != nil {
+			return nil, err
+		}
+		database, err := copySnapshot(snapshot.snapshot, filepath.Join(path, snapshot.path))
+		if err != nil {
+			return nil, err
+		}
+		database.Path = snapshot.path
+		if i < len(snapshots)-1 {
+			manifest.Servlets = append(manifest.Servlets, database)
+		} else {
+			manifest.Factors = database
+		}
+	}
+
+	// Write the manifest last so that incomplete backups are never valid.
+	file, err := os.Create(filepath.Join(path, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err = json.NewEncoder(file).Encode(manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Pauses writes to every servlet, open table schemas and the factors database
+// while snapshots are taken and table files are copied. Locks are acquired in
+// the same order as event writes to avoid deadlocks. Returns a snapshot for
+// each table's store on each servlet followed by one for the factors database.
+func (s *Server) snapshot(tablesPath string, manifest *BackupManifest) ([]*backupSnapshot, error) {
+	for _, servlet := range s.servlets {
+		servlet.Lock()
+		defer servlet.Unlock()
+	}
+	for _, table := range s.tables {
+		table.mutex.RLock()
+		defer table.mutex.RUnlock()
+	}
+	s.factors.mutex.Lock()
+	defer s.factors.mutex.Unlock()
+
+	// Copy table schemas.
+	tables, err := s.GetAllTables()
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if err := copyDir(table.Path(), filepath.Join(tablesPath, table.Name)); err != nil {
+			return nil, err
+		}
+		manifest.Tables = append(manifest.Tables, table.Name)
+	}
+	manifest.Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	snapshots := make([]*backupSnapshot, 0)
+	for i, servlet := range s.servlets {
+		names, dbs, err := servlet.tableDBs()
+		if err != nil {
+			for _, snapshot := range snapshots {
+				snapshot.release()
+			}
+			return nil, err
+		}
+		for _, name := range names {
+			path := filepath.Join("data", fmt.Sprintf("%d", i), "tables", name)
+			snapshots = append(snapshots, &backupSnapshot{path: path, snapshot: dbs[name].NewSnapshot(), store: dbs[name]})
+		}
+	}
+	snapshots = append(snapshots, &backupSnapshot{path: "factors", snapshot: s.factors.db.NewSnapshot()})
+
+	return snapshots, nil
+}
+
+// Releases a snapshot and the reference to its table store.
+func (s *backupSnapshot) release() {
+	s.snapshot.Release()
+	if s.store != nil {
+		s.store.release()
+	}
+}
@@ -1,12 +1,25 @@
 package skyd
 
 import (
+	"encoding/json"
 	"reflect"
 )
 
 // Normalizes a value. Int and Uint types are combined into int64 and Float types
-// are combined into float64. All other types are left alone.
+// are combined into float64. JSON numbers become an int64 if they are whole and
+// fit, otherwise a float64. The elements of lists are normalized as well. All
+// other types are left alone.
 func normalize(value interface{}) interface{} {
+	if n, ok := value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+		return value
+	}
+
 	v := reflect.ValueOf(value)
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -16,5 +29,44 @@ func normalize(value interface{}) interface{} {
 	case reflect.Float32, reflect.Float64:
 		return v.Float()
 	}
+	if list, ok := value.([]interface{}); ok {
+		normalized := make([]interface{}, len(list))
+		for i, elem := range list {
+			normalized[i] = normalize(elem)
+		}
+		return normalized
+	}
 	return value
 }
+
+// Converts a decoded number to an int. Fractions are truncated. Returns false
+// if the value isn't a number.
+func toInt(value interface{}) (int, bool) {
+	switch v := normalize(value).(type) {
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// Determines if two values are equal after normalization. Lists are equal if
+// their elements are equal and in the same order.
+func equalValues(a interface{}, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	x, xok := a.([]interface{})
+	y, yok := b.([]interface{})
+	if xok || yok {
+		if !xok || !yok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
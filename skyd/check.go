@@ -0,0 +1,493 @@
+package skyd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// What the integrity checker does with the problems it finds.
+const (
+	// Only reports problems.
+	CheckReport = "report"
+
+	// Moves every object with a problem into a quarantine database.
+	CheckQuarantine = "quarantine"
+
+	// Rewrites objects whose problems can be fixed and quarantines the rest.
+	CheckRepair = "repair"
+)
+
+// Actions taken on an object with problems.
+const (
+	checkRepaired    = "repaired"
+	checkQuarantined = "quarantined"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A CheckResult summarizes an integrity check of a data directory.
+type CheckResult struct {
+	ObjectCount int             `json:"objectCount"`
+	Problems    []*CheckProblem `json:"problems"`
+}
+
+// A CheckProblem describes a single problem found with an object.
+type CheckProblem struct {
+	Servlet  int    `json:"servlet"`
+	Table    string `json:"table"`
+	ObjectId string `json:"objectId"`
+	Key      string `json:"key"`
+	Message  string `json:"message"`
+	Action   string `json:"action,omitempty"`
+}
+
+// A checker holds the state of an integrity check.
+type checker struct {
+	path        string
+	mode        string
+	tables      map[string]*Table
+	factors     *Factors
+	quarantines map[int]Storage
+	result      *CheckResult
+}
+
+// The problems found with a single object.
+type checkObject struct {
+	servlet    *Servlet
+	db         Storage
+	index      int
+	key        []byte
+	table      *Table
+	tableName  string
+	objectId   string
+	events     []*Event
+	problems   []*CheckProblem
+	repairable bool
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Verifies every object in an offline data directory. Each value must decode,
+// events must be sorted, the stored state must match the state recomputed
+// from the events, every property must exist in the table's schema and every
+// factor must resolve. Depending on the mode, objects with problems are also
+// repaired or moved to a quarantine database under the data directory.
+func Check(path string, mode string) (*CheckResult, error) {
+	if mode != CheckReport && mode != CheckQuarantine && mode != CheckRepair {
+		return nil, fmt.Errorf("skyd: Invalid check mode: %v", mode)
+	}
+	if _, err := os.Stat(filepath.Join(path, "data")); err != nil {
+		return nil, fmt.Errorf("skyd: Data directory not found: %v", path)
+	}
+
+	c := &checker{
+		path:        path,
+		mode:        mode,
+		tables:      make(map[string]*Table),
+		quarantines: make(map[int]Storage),
+		result:      &CheckResult{Problems: []*CheckProblem{}},
+	}
+	defer c.close()
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	// Check each servlet in turn.
+	count, err := readServletCount(filepath.Join(path, "data"))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		servlet := NewServlet(filepath.Join(path, "data", fmt.Sprintf("%d", i)), c.factors)
+		if err := servlet.Open(); err != nil {
+			return nil, err
+		}
+		err := c.checkServlet(i, servlet)
+		servlet.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.result, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Lifecycle
+//--------------------------------------
+
+// Opens the factors database and every table schema.
+func (c *checker) open() error {
+	c.factors = NewFactors(filepath.Join(c.path, "factors"))
+	if err := c.factors.Open(); err != nil {
+		return err
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(c.path, "tables"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		table := NewTable(info.Name(), filepath.Join(c.path, "tables", info.Name()))
+		if err := table.Open(); err != nil {
+			return fmt.Errorf("skyd: Unable to open table: %v: %v", info.Name(), err)
+		}
+		c.tables[table.Name] = table
+	}
+
+	return nil
+}
+
+// Closes the factors database, tables and quarantine databases.
+func (c *checker) close() {
+	for _, table := range c.tables {
+		table.Close()
+	}
+	if c.factors != nil {
+		c.factors.Close()
+	}
+	for _, db := range c.quarantines {
+		db.Close()
+	}
+}
+
+//--------------------------------------
+// Servlets
+//--------------------------------------
+
+// Checks every object in each table's store on a servlet.
+func (c *checker) checkServlet(index int, servlet *Servlet) error {
+	names, dbs, err := servlet.tableDBs()
+	if err != nil {
+		return err
+	}
+	defer releaseTableDBs(dbs)
+	for _, name := range names {
+		if err := c.checkStore(index, servlet, name, dbs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks every object in a table's store.
+func (c *checker) checkStore(index int, servlet *Servlet, name string, db Storage) error {
+	// Find every object key first so that repairs don't affect iteration.
+	objectKeys, invalidKeys := make([][]byte, 0), make([][]byte, 0)
+	iterator := db.NewIterator()
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		sz, err := objectKeyLength(key)
+		if err != nil || (sz < len(key) && len(key) != sz+8) {
+			invalidKeys = append(invalidKeys, key)
+			continue
+		}
+		if tableName, _, _ := decodeObjectKey(key); tableName != name {
+			invalidKeys = append(invalidKeys, key)
+			continue
+		}
+		if n := len(objectKeys); n == 0 || string(objectKeys[n-1]) != string(key[:sz]) {
+			objectKeys = append(objectKeys, key[:sz])
+		}
+	}
+	err := iterator.GetError()
+	iterator.Close()
+	if err != nil {
+		return err
+	}
+
+	// Keys that can't be parsed don't belong to any object.
+	for _, key := range invalidKeys {
+		o := &checkObject{servlet: servlet, db: db, index: index, key: key, tableName: name}
+		o.report(false, "Invalid key")
+		if err := c.resolve(o, [][]byte{key}); err != nil {
+			return err
+		}
+	}
+
+	for _, objectKey := range objectKeys {
+		c.result.ObjectCount++
+		if err := c.checkObject(index, servlet, db, objectKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//--------------------------------------
+// Objects
+//--------------------------------------
+
+// Checks a single object and repairs or quarantines it if needed.
+func (c *checker) checkObject(index int, servlet *Servlet, db Storage, objectKey []byte) error {
+	o := &checkObject{servlet: servlet, db: db, index: index, key: objectKey, repairable: true}
+	o.tableName, o.objectId, _ = decodeObjectKey(objectKey)
+	o.table = c.tables[o.tableName]
+	if o.table == nil {
+		o.report(false, "Unknown table: %v", o.tableName)
+	}
+
+	// Decode the state and every chunk separately so problems can be
+	// attributed to a key.
+	state, data, err := servlet.getState(db, objectKey)
+	if err != nil {
+		o.report(false, "Unable to decode state: %v", err)
+	}
+	events, err := decodeEvents(data)
+	if err != nil {
+		o.report(false, "Unable to decode events stored with state: %v", err)
+	}
+	keys, values, err := c.rawChunks(db, objectKey)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		value, err := decodeValue(values[i])
+		if err != nil {
+			o.report(false, "Unable to decompress chunk %x: %v", key, err)
+			continue
+		}
+		chunk, err := decodeEvents(value)
+		if err != nil {
+			o.report(false, "Unable to decode chunk %x: %v", key, err)
+			continue
+		}
+		for _, event := range chunk {
+			if string(objectChunkKey(objectKey, event.Timestamp)) != string(key) {
+				o.report(true, "Event at %v is stored in chunk %x", event.Timestamp, key)
+			}
+		}
+		events = append(events, chunk...)
+	}
+	o.events = events
+
+	if o.repairable && o.table != nil {
+		c.checkEvents(o, state)
+	}
+
+	allKeys := append([][]byte{objectKey}, keys...)
+	return c.resolve(o, allKeys)
+}
+
+// Retrieves the keys and stored values of every chunk for an object without
+// decompressing them so that each chunk can be checked separately.
+func (c *checker) rawChunks(db Storage, objectKey []byte) ([][]byte, [][]byte, error) {
+	keys, values := make([][]byte, 0), make([][]byte, 0)
+	iterator := db.NewIterator()
+	defer iterator.Close()
+	for iterator.Seek(objectKey); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if !bytes.HasPrefix(key, objectKey) {
+			break
+		}
+		if len(key) > len(objectKey) {
+			keys = append(keys, key)
+			values = append(values, iterator.Value())
+		}
+	}
+	return keys, values, iterator.GetError()
+}
+
+// Checks the ordering, properties, factors and state of a decoded object.
+func (c *checker) checkEvents(o *checkObject, state *Event) {
+	if len(o.events) == 0 {
+		o.report(true, "Object has no events")
+		return
+	}
+
+	expected := &Event{Timestamp: o.events[len(o.events)-1].Timestamp, Data: map[int64]interface{}{}}
+	for i, event := range o.events {
+		if i > 0 && !event.Timestamp.After(o.events[i-1].Timestamp) {
+			o.report(true, "Events are not in increasing order at %v", event.Timestamp)
+		}
+		for _, id := range c.invalidValues(o, event) {
+			o.report(true, "Invalid value for property %v at %v: %v", id, event.Timestamp, event.Data[id])
+		}
+		expected.MergePermanent(event)
+	}
+
+	if state == nil {
+		o.report(true, "Missing state")
+	} else if !state.Equal(expected) {
+		o.report(true, "State does not match events")
+	}
+}
+
+// Determines which property identifiers in an event don't exist in the
+// table's schema or refer to factors that don't resolve.
+func (c *checker) invalidValues(o *checkObject, event *Event) []int64 {
+	ids := make([]int, 0)
+	for id, value := range event.Data {
+		property, err := o.table.GetProperty(id)
+		if err != nil || property == nil {
+			ids = append(ids, int(id))
+			continue
+		}
+		// Factors of placeholders are resolved by name once they're migrated.
+		if property.Name == "" {
+			continue
+		}
+		if property.DataType == FactorDataType {
+			if !c.resolves(o.table, property, value) {
+				ids = append(ids, int(id))
+			}
+		} else if property.DataType == FactorListDataType {
+			list, ok := normalize(value).([]interface{})
+			for i := 0; ok && i < len(list); i++ {
+				ok = c.resolves(o.table, property, list[i])
+			}
+			if !ok {
+				ids = append(ids, int(id))
+			}
+		}
+	}
+	sort.Ints(ids)
+
+	ret := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, int64(id))
+	}
+	return ret
+}
+
+// Determines if a stored factor can be defactorized.
+func (c *checker) resolves(table *Table, property *Property, value interface{}) bool {
+	sequence, ok := normalize(value).(int64)
+	if !ok {
+		return false
+	}
+	_, err := c.factors.Defactorize(table.Name, property.Name, uint64(sequence))
+	return err == nil
+}
+
+// Records the problems for an object and repairs or quarantines it depending
+// on the mode.
+func (c *checker) resolve(o *checkObject, keys [][]byte) error {
+	if len(o.problems) == 0 {
+		return nil
+	}
+
+	action := ""
+	switch {
+	case c.mode == CheckRepair && o.repairable:
+		if err := c.repair(o); err != nil {
+			return err
+		}
+		action = checkRepaired
+	case c.mode == CheckRepair, c.mode == CheckQuarantine:
+		if err := c.quarantine(o, keys); err != nil {
+			return err
+		}
+		action = checkQuarantined
+	}
+
+	for _, problem := range o.problems {
+		problem.Action = action
+	}
+	c.result.Problems = append(c.result.Problems, o.problems...)
+	return nil
+}
+
+// Rewrites an object without invalid values, with its events sorted and
+// events that share a timestamp merged, and with a recomputed state.
+func (c *checker) repair(o *checkObject) error {
+	sort.Stable(EventList(o.events))
+	events := make([]*Event, 0)
+	state := &Event{Data: map[int64]interface{}{}}
+	for _, event := range o.events {
+		for _, id := range c.invalidValues(o, event) {
+			delete(event.Data, id)
+		}
+		if n := len(events); n > 0 && events[n-1].Timestamp.Equal(event.Timestamp) {
+			events[n-1].Merge(event)
+		} else {
+			events = append(events, event)
+		}
+		state.MergePermanent(event)
+	}
+
+	o.servlet.Lock()
+	defer o.servlet.Unlock()
+	batch := o.db.NewBatch()
+	defer batch.Close()
+	if err := o.servlet.writeObject(o.db, batch, o.key, events, state, o.table.IsCompressed()); err != nil {
+		return err
+	}
+	return o.servlet.write(o.db, batch)
+}
+
+// Moves the keys for an object into the servlet's quarantine database.
+func (c *checker) quarantine(o *checkObject, keys [][]byte) error {
+	db := c.quarantines[o.index]
+	if db == nil {
+		path := filepath.Join(c.path, "quarantine", fmt.Sprintf("%d", o.index))
+		if err := os.MkdirAll(path, 0700); err != nil {
+			return err
+		}
+		var err error
+		if db, err = NewLevelDBEngine(nil).Open(path); err != nil {
+			return err
+		}
+		c.quarantines[o.index] = db
+	}
+
+	// Copy the keys before removing them from the servlet.
+	copied, removed := db.NewBatch(), o.db.NewBatch()
+	defer copied.Close()
+	defer removed.Close()
+	for _, key := range keys {
+		value, err := o.db.Get(key)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			copied.Put(key, value)
+			removed.Delete(key)
+		}
+	}
+	if err := db.Write(copied, true); err != nil {
+		return err
+	}
+	return o.db.Write(removed, true)
+}
+
+// Adds a problem to an object. Unrepairable problems prevent the object from
+// being repaired.
+func (o *checkObject) report(repairable bool, format string, a ...interface{}) {
+	o.repairable = o.repairable && repairable
+	o.problems = append(o.problems, &CheckProblem{
+		Servlet:  o.index,
+		Table:    o.tableName,
+		ObjectId: o.objectId,
+		Key:      fmt.Sprintf("%x", o.key),
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
@@ -0,0 +1,74 @@
+package skyd
+
+import (
+	"fmt"
+	"github.com/ugorji/go-msgpack"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Ensure that the checker finds, repairs and quarantines damaged objects.
+func TestCheck(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+
+	// Write a valid object.
+	server := NewServer(8586, path)
+	server.Silence()
+	server.ListenAndServe(nil)
+	setupTestTable("foo")
+	setupTestProperty("foo", "bar", false, "string")
+	setupTestData(t, "foo", [][]string{
+		[]string{"a", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+	})
+	server.Shutdown()
+
+	// Damage a few objects directly.
+	table := NewTable("foo", fmt.Sprintf("%v/tables/foo", path))
+	servlet := NewServlet(fmt.Sprintf("%v/data/0", path), nil)
+	servlet.Open()
+	event := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x", 99: "y"})
+	servlet.SetEvents(table, "unknown", []*Event{event}, NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x", 99: "y"}))
+	event = NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x"})
+	servlet.SetEvents(table, "stale", []*Event{event}, NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "z"}))
+	key, _ := table.EncodeObjectId("bad")
+	value, _ := encodeRawEvents(nil, NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{}))
+	garbage, _ := msgpack.Marshal([]interface{}{"x", nil})
+	db, _ := servlet.tableDB(table.Name)
+	db.Put(key, value)
+	db.Put(objectChunkKey(key, time.Unix(0, 0)), garbage)
+	db.release()
+	servlet.Close()
+
+	result, err := Check(path, CheckReport)
+	if err != nil {
+		t.Fatalf("Unable to check: %v", err)
+	}
+	if result.ObjectCount != 4 || len(result.Problems) != 3 {
+		t.Fatalf("Unexpected result: %v objects, %v problems", result.ObjectCount, len(result.Problems))
+	}
+	for _, problem := range result.Problems {
+		if problem.Action != "" {
+			t.Fatalf("Unexpected action in report mode: %v", problem.Action)
+		}
+	}
+
+	// Repair and check again.
+	result, err = Check(path, CheckRepair)
+	actions := map[string]string{}
+	for _, problem := range result.Problems {
+		actions[problem.ObjectId] = problem.Action
+	}
+	if err != nil || actions["unknown"] != "repaired" || actions["stale"] != "repaired" || actions["bad"] != "quarantined" {
+		t.Fatalf("Unexpected repair: %v (%v)", actions, err)
+	}
+	result, err = Check(path, CheckReport)
+	if err != nil || result.ObjectCount != 3 || len(result.Problems) != 0 {
+		t.Fatalf("Unexpected result after repair: %v (%v)", result, err)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%v/quarantine/0", path)); err != nil {
+		t.Fatalf("Expected quarantine database: %v", err)
+	}
+}
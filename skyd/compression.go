@@ -0,0 +1,232 @@
+package skyd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// Compressed values begin with a marker byte followed by a codec byte. The
+// marker is never used by MsgPack so it can't be confused with the first
+// byte of an uncompressed state or chunk.
+const (
+	compressionMarker = 0xc1
+	snappyCodec       = 0x01
+)
+
+// Values smaller than this are never compressed.
+const minCompressionSize = 64
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01
+	snappyTagCopy2   = 0x02
+	snappyTagCopy4   = 0x03
+
+	snappyMaxOffset = 1 << 16
+	snappyHashBits  = 14
+
+	// The most a block can expand when decoded. The densest element is a
+	// three byte copy of 64 bytes.
+	snappyMaxExpansion = 32
+)
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Values
+//--------------------------------------
+
+// Prepares a state or chunk value to be stored. If compress is set then the
+// value is compressed unless that would make it larger.
+func encodeValue(value []byte, compress bool) []byte {
+	if !compress || len(value) < minCompressionSize {
+		return value
+	}
+	compressed := snappyEncode(make([]byte, 2, len(value)), value)
+	if len(compressed) >= len(value) {
+		return value
+	}
+	compressed[0], compressed[1] = compressionMarker, snappyCodec
+	return compressed
+}
+
+// Restores a stored state or chunk value. Uncompressed values are returned
+// as is.
+func decodeValue(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != compressionMarker {
+		return value, nil
+	}
+	if len(value) < 2 || value[1] != snappyCodec {
+		return nil, errors.New("skyd: Unknown compression codec.")
+	}
+	return snappyDecode(value[2:])
+}
+
+//--------------------------------------
+// Snappy
+//--------------------------------------
+
+// Appends the Snappy block encoding of src to dst.
+func snappyEncode(dst []byte, src []byte) []byte {
+	var header [binary.MaxVarintLen64]byte
+	dst = append(dst, header[:binary.PutUvarint(header[:], uint64(len(src)))]...)
+
+	// Find repeated four byte sequences using a hash of their last position.
+	var table [1 << snappyHashBits]int
+	for i := range table {
+		table[i] = -1
+	}
+	literal := 0
+	for i := 0; i+4 <= len(src); {
+		h := snappyHash(src[i:])
+		candidate := table[h]
+		table[h] = i
+		if candidate < 0 || i-candidate >= snappyMaxOffset || !snappyMatch(src[candidate:], src[i:]) {
+			i++
+			continue
+		}
+
+		// Extend the match and emit it along with any pending literal.
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyEmitLiteral(dst, src[literal:i])
+		dst = snappyEmitCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+	return snappyEmitLiteral(dst, src[literal:])
+}
+
+// Decodes a Snappy block.
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errors.New("skyd: Invalid compressed value.")
+	}
+	if length > uint64(len(src))*snappyMaxExpansion {
+		return nil, fmt.Errorf("skyd: Invalid compressed value length: %v", length)
+	}
+	dst := make([]byte, 0, length)
+	for i := n; i < len(src); {
+		tag := src[i]
+		var offset, sz int
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			sz = int(tag>>2) + 1
+			i++
+			if sz > 60 {
+				bytes := sz - 60
+				if i+bytes > len(src) {
+					return nil, errors.New("skyd: Invalid compressed value.")
+				}
+				sz = 0
+				for j := bytes - 1; j >= 0; j-- {
+					sz = sz<<8 | int(src[i+j])
+				}
+				sz++
+				i += bytes
+			}
+			if sz <= 0 || i+sz > len(src) || uint64(len(dst)+sz) > length {
+				return nil, errors.New("skyd: Invalid compressed value.")
+			}
+			dst = append(dst, src[i:i+sz]...)
+			i += sz
+			continue
+		case snappyTagCopy1:
+			if i+2 > len(src) {
+				return nil, errors.New("skyd: Invalid compressed value.")
+			}
+			sz = int(tag>>2&0x07) + 4
+			offset = int(tag&0xe0)<<3 | int(src[i+1])
+			i += 2
+		case snappyTagCopy2:
+			if i+3 > len(src) {
+				return nil, errors.New("skyd: Invalid compressed value.")
+			}
+			sz = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[i+1:]))
+			i += 3
+		case snappyTagCopy4:
+			if i+5 > len(src) {
+				return nil, errors.New("skyd: Invalid compressed value.")
+			}
+			sz = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[i+1:]))
+			i += 5
+		}
+
+		// Copy byte by byte since the source may overlap the destination.
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+sz) > length {
+			return nil, errors.New("skyd: Invalid compressed value.")
+		}
+		start := len(dst) - offset
+		for j := 0; j < sz; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("skyd: Invalid compressed value length: %v, expected %v", len(dst), length)
+	}
+	return dst, nil
+}
+
+// Hashes the four bytes at the beginning of a slice.
+func snappyHash(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 0x1e35a7bd) >> (32 - snappyHashBits)
+}
+
+// Checks if the first four bytes of two slices are the same.
+func snappyMatch(a []byte, b []byte) bool {
+	return a[0] == b[0] && a[1] == b[1] && a[2] == b[2] && a[3] == b[3]
+}
+
+// Appends a literal element.
+func snappyEmitLiteral(dst []byte, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := len(literal) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n<<2)|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+// Appends copy elements for a match. Copies are limited to 64 bytes each.
+func snappyEmitCopy(dst []byte, offset int, length int) []byte {
+	for length > 0 {
+		sz := length
+		if sz > 64 {
+			sz = 64
+		}
+		// Don't leave a remainder that is too short to encode efficiently.
+		if length-sz > 0 && length-sz < 4 {
+			sz = length - 4
+		}
+		dst = append(dst, byte(sz-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= sz
+	}
+	return dst
+}
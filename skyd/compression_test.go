@@ -0,0 +1,66 @@
+package skyd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Ensure that values can be compressed and decompressed.
+func TestCompressionRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		[]byte{},
+		[]byte("abc"),
+		[]byte(strings.Repeat("abcdefgh", 1000)),
+		[]byte(strings.Repeat("x", 100000)),
+	}
+	for i := 0; i < 300; i++ {
+		inputs = append(inputs, []byte(strings.Repeat(string(rune('a'+i%26)), i)+strings.Repeat("0123456789", i%7)))
+	}
+	for _, input := range inputs {
+		output, err := decodeValue(encodeValue(input, true))
+		if err != nil {
+			t.Fatalf("Unable to decode value of %v bytes: %v", len(input), err)
+		}
+		if !bytes.Equal(input, output) {
+			t.Fatalf("Value of %v bytes did not round trip.", len(input))
+		}
+	}
+}
+
+// Ensure that compressible values are stored with a marker and that other
+// values are stored as is.
+func TestCompressionMarker(t *testing.T) {
+	input := []byte(strings.Repeat("abcdefgh", 100))
+	if value := encodeValue(input, true); len(value) >= len(input) || value[0] != compressionMarker || value[1] != snappyCodec {
+		t.Fatalf("Expected compressed value: %x", value)
+	}
+	if value := encodeValue(input, false); !bytes.Equal(value, input) {
+		t.Fatalf("Expected uncompressed value: %x", value)
+	}
+	if value := encodeValue([]byte{0x92, 0x01}, true); !bytes.Equal(value, []byte{0x92, 0x01}) {
+		t.Fatalf("Expected small value to be uncompressed: %x", value)
+	}
+	if _, err := decodeValue([]byte{compressionMarker, 0x7f, 0x00}); err == nil {
+		t.Fatalf("Expected unknown codec error.")
+	}
+}
+
+// Ensure that Snappy blocks are decoded.
+func TestSnappyDecode(t *testing.T) {
+	output, err := snappyDecode([]byte{0x0c, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04})
+	if err != nil || string(output) != "abcdabcdabcd" {
+		t.Fatalf("Unexpected output: %q (%v)", output, err)
+	}
+	if _, err = snappyDecode([]byte{0x0c, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x08}); err == nil {
+		t.Fatalf("Expected invalid offset error.")
+	}
+
+	// Lengths that can't be produced by the block are rejected before allocating.
+	if _, err = snappyDecode([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0x00, 'a'}); err == nil {
+		t.Fatalf("Expected invalid length error.")
+	}
+	if _, err = snappyDecode([]byte{0x02, 0x0c, 'a', 'b', 'c', 'd'}); err == nil {
+		t.Fatalf("Expected overflow error.")
+	}
+}
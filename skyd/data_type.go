@@ -1,9 +1,16 @@
 package skyd
 
 const (
-	FactorDataType  = "factor"
-	StringDataType  = "string"
-	IntegerDataType = "integer"
-	FloatDataType   = "float"
-	BooleanDataType = "boolean"
+	FactorDataType     = "factor"
+	FactorListDataType = "factor[]"
+	StringDataType     = "string"
+	IntegerDataType    = "integer"
+	FloatDataType      = "float"
+	BooleanDataType    = "boolean"
+	TimestampDataType  = "timestamp"
 )
+
+// The largest magnitude of an integer value. Queries see integers as Lua
+// numbers, which are doubles, so larger values couldn't be represented
+// exactly.
+const maxIntegerValue = 1 << 53
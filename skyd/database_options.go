@@ -0,0 +1,130 @@
+package skyd
+
+import (
+	"github.com/jmhodges/levigo"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	// The default size of the LRU block cache shared by all databases.
+	DefaultCacheSize = 64 * 1024 * 1024
+
+	// The default number of bits per key used by bloom filters.
+	DefaultBloomFilterBits = 10
+
+	// The default size of each database's in-memory write buffer.
+	DefaultWriteBufferSize = 4 * 1024 * 1024
+
+	// The default number of files each database can hold open.
+	DefaultMaxOpenFiles = 1000
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// DatabaseOptions holds the LevelDB tuning applied to every servlet and to the
+// factors database. The block cache and the bloom filter are shared between
+// all databases opened with the same options.
+type DatabaseOptions struct {
+	CacheSize       int
+	BloomFilterBits int
+	WriteBufferSize int
+	MaxOpenFiles    int
+	Compression     bool
+	ParanoidChecks  bool
+	cache           *levigo.Cache
+	filter          *levigo.FilterPolicy
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructors
+//
+//------------------------------------------------------------------------------
+
+// NewDatabaseOptions returns a new set of database options with the defaults.
+func NewDatabaseOptions() *DatabaseOptions {
+	return &DatabaseOptions{
+		CacheSize:       DefaultCacheSize,
+		BloomFilterBits: DefaultBloomFilterBits,
+		WriteBufferSize: DefaultWriteBufferSize,
+		MaxOpenFiles:    DefaultMaxOpenFiles,
+		Compression:     true,
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Allocates the shared block cache and bloom filter. A cache size or number
+// of bloom filter bits of zero disables that feature.
+func (o *DatabaseOptions) open() {
+	if o == nil {
+		return
+	}
+	o.close()
+	if o.CacheSize > 0 {
+		o.cache = levigo.NewLRUCache(o.CacheSize)
+	}
+	if o.BloomFilterBits > 0 {
+		o.filter = levigo.NewBloomFilter(o.BloomFilterBits)
+	}
+}
+
+// Releases the shared block cache and bloom filter. This must only be called
+// after every database using them has been closed.
+func (o *DatabaseOptions) close() {
+	if o == nil {
+		return
+	}
+	if o.cache != nil {
+		o.cache.Close()
+		o.cache = nil
+	}
+	if o.filter != nil {
+		o.filter.Close()
+		o.filter = nil
+	}
+}
+
+// Creates the LevelDB options used to open a database. Nil options use the
+// LevelDB defaults. The caller is responsible for closing the options.
+func (o *DatabaseOptions) levigoOptions() *levigo.Options {
+	opts := levigo.NewOptions()
+	opts.SetCreateIfMissing(true)
+	if o == nil {
+		return opts
+	}
+
+	if o.cache != nil {
+		opts.SetCache(o.cache)
+	}
+	if o.filter != nil {
+		opts.SetFilterPolicy(o.filter)
+	}
+	if o.WriteBufferSize > 0 {
+		opts.SetWriteBufferSize(o.WriteBufferSize)
+	}
+	if o.MaxOpenFiles > 0 {
+		opts.SetMaxOpenFiles(o.MaxOpenFiles)
+	}
+	if o.Compression {
+		opts.SetCompression(levigo.SnappyCompression)
+	} else {
+		opts.SetCompression(levigo.NoCompression)
+	}
+	opts.SetParanoidChecks(o.ParanoidChecks)
+
+	return opts
+}
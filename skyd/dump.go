@@ -0,0 +1,361 @@
+package skyd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ugorji/go-msgpack"
+	"io"
+	"net/http"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The version of the dump format.
+const DumpVersion = 1
+
+// Dumps are written as newline delimited JSON or as a stream of MsgPack
+// values. Both formats contain the same records.
+const (
+	JSONDumpFormat    = "json"
+	MsgPackDumpFormat = "msgpack"
+)
+
+// The number of objects retrieved at a time while exporting.
+const dumpBatchSize = 1000
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A DumpResult summarizes an import.
+type DumpResult struct {
+	ObjectCount int `json:"objectCount"`
+	EventCount  int `json:"eventCount"`
+}
+
+// A dumpEncoder writes dump records in either format.
+type dumpEncoder interface {
+	Encode(v interface{}) error
+}
+
+// A dumpDecoder reads dump records in either format.
+type dumpDecoder interface {
+	Decode(v interface{}) error
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Creates an encoder for a dump format.
+func newDumpEncoder(w io.Writer, format string) (dumpEncoder, error) {
+	switch format {
+	case JSONDumpFormat:
+		return json.NewEncoder(w), nil
+	case MsgPackDumpFormat:
+		return msgpack.NewEncoder(w), nil
+	}
+	return nil, fmt.Errorf("Invalid dump format: %v", format)
+}
+
+// Creates a decoder for a dump format.
+func newDumpDecoder(r io.Reader, format string) (dumpDecoder, error) {
+	switch format {
+	case JSONDumpFormat:
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+		return decoder, nil
+	case MsgPackDumpFormat:
+		return msgpack.NewDecoder(bufio.NewReader(r), nil), nil
+	}
+	return nil, fmt.Errorf("Invalid dump format: %v", format)
+}
+
+// Reads the next record of a dump. Returns nil at the end of the dump.
+func decodeDumpRecord(decoder dumpDecoder) (map[string]interface{}, error) {
+	var raw interface{}
+	if err := decoder.Decode(&raw); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	record, ok := ConvertToStringKeys(raw).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Dump record must be a map.")
+	}
+	return record, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Export
+//--------------------------------------
+
+// Writes a table's settings and schema followed by every object's events.
+// Event data uses property names and factor values instead of identifiers so
+// that the dump can be imported into a server with a different schema.
+func (s *Server) ExportTable(table *Table, w io.Writer, format string) error {
+	encoder, err := newDumpEncoder(w, format)
+	if err != nil {
+		return err
+	}
+
+	// Write the schema.
+	properties, err := table.GetProperties()
+	if err != nil {
+		return err
+	}
+	settings := table.getSettings()
+	header := map[string]interface{}{
+		"version": DumpVersion,
+		"table":   table.Name,
+		"settings": map[string]interface{}{
+			"retentionDays": settings.RetentionDays,
+			"validation":    settings.Validation,
+			"autoCreate":    settings.AutoCreate,
+			"autoFactor":    settings.AutoFactor,
+			"autoPermanent": settings.AutoPermanent,
+			"compression":   settings.Compression,
+		},
+		"properties": serializeDumpProperties(properties),
+	}
+	if err = encoder.Encode(header); err != nil {
+		return err
+	}
+
+	// Write each object a page at a time.
+	token := ""
+	for {
+		infos, next, err := s.ScanObjects(table, token, "", dumpBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err = s.exportObject(encoder, table, info.Id); err != nil {
+				return err
+			}
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	return nil
+}
+
+// Writes a single object's events to a dump.
+func (s *Server) exportObject(encoder dumpEncoder, table *Table, objectId string) error {
+	_, servlet, err := s.GetObjectContext(table.Name, objectId)
+	if err != nil {
+		return err
+	}
+	events, _, err := servlet.GetEvents(table, objectId)
+	if err != nil {
+		return err
+	}
+
+	output := make([]interface{}, 0)
+	for _, event := range events {
+		if err = table.DefactorizeEvent(event, s.factors); err != nil {
+			return err
+		}
+		e, err := table.SerializeEvent(event)
+		if err != nil {
+			return err
+		}
+		e["timestamp"] = event.Timestamp.UTC().Format(time.RFC3339Nano)
+		output = append(output, e)
+	}
+	return encoder.Encode(map[string]interface{}{"id": objectId, "events": output})
+}
+
+// Converts properties into dump records. Metadata is only included if set.
+func serializeDumpProperties(properties []*Property) []interface{} {
+	output := make([]interface{}, 0)
+	for _, property := range properties {
+		m := map[string]interface{}{
+			"id":        property.Id,
+			"name":      property.Name,
+			"transient": property.Transient,
+			"dataType":  property.DataType,
+		}
+		if property.Description != "" {
+			m["description"] = property.Description
+		}
+		if property.Unit != "" {
+			m["unit"] = property.Unit
+		}
+		if len(property.Enum) > 0 {
+			enum := make([]interface{}, 0, len(property.Enum))
+			for _, value := range property.Enum {
+				enum = append(enum, property.denormalize(value))
+			}
+			m["enum"] = enum
+		}
+		if property.Nullable != nil {
+			m["nullable"] = *property.Nullable
+		}
+		if property.Default != nil {
+			m["default"] = property.denormalize(property.Default)
+		}
+		output = append(output, m)
+	}
+	return output
+}
+
+//--------------------------------------
+// Import
+//--------------------------------------
+
+// Reads a dump into a table. The table is created with the dump's settings if
+// it doesn't exist. Properties are matched by name and created if they are
+// missing. Imported events replace existing events with the same timestamp.
+func (s *Server) ImportTable(name string, r io.Reader, format string) (*DumpResult, error) {
+	decoder, err := newDumpDecoder(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the schema.
+	header, err := decodeDumpRecord(decoder)
+	if err != nil {
+		return nil, err
+	} else if header == nil {
+		return nil, errors.New("Dump is empty.")
+	}
+	if version := normalize(header["version"]); version != int64(DumpVersion) && version != float64(DumpVersion) {
+		return nil, fmt.Errorf("Unsupported dump version: %v", header["version"])
+	}
+	table, err := s.importSchema(name, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write each object.
+	result := &DumpResult{}
+	for n := 2; ; n++ {
+		record, err := decodeDumpRecord(decoder)
+		if err != nil {
+			return result, fmt.Errorf("Invalid dump record %d: %v", n, err)
+		} else if record == nil {
+			break
+		}
+		count, err := s.importObject(table, record)
+		if err != nil {
+			return result, fmt.Errorf("Invalid dump record %d: %v", n, err)
+		}
+		result.ObjectCount++
+		result.EventCount += count
+	}
+
+	return result, nil
+}
+
+// Creates a table and any missing properties from a dump header.
+func (s *Server) importSchema(name string, header map[string]interface{}) (*Table, error) {
+	table := NewTable(name, s.TablePath(name))
+	if !table.Exists() {
+		if err := table.Create(); err != nil {
+			return nil, err
+		}
+		settings, _ := header["settings"].(map[string]interface{})
+		if err := s.updateTableSettings(table, settings); err != nil {
+			return nil, err
+		}
+	}
+	table, err := s.OpenTable(name)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, _ := header["properties"].([]interface{})
+	for _, p := range properties {
+		m, _ := p.(map[string]interface{})
+		propertyName, _ := m["name"].(string)
+		transient, _ := m["transient"].(bool)
+		dataType, _ := m["dataType"].(string)
+		if propertyName == "" {
+			return nil, errors.New("Dump property name required.")
+		}
+
+		property, err := table.GetPropertyByName(propertyName)
+		if err != nil {
+			return nil, err
+		}
+		if property == nil {
+			if property, err = NewProperty(0, propertyName, transient, dataType); err != nil {
+				return nil, err
+			}
+			metadata, err := NewPropertyMetadata(m, PropertyMetadata{})
+			if err != nil {
+				return nil, err
+			}
+			if err = property.SetMetadata(metadata, false); err != nil {
+				return nil, err
+			}
+			if _, err = table.AddProperty(property); err != nil {
+				return nil, err
+			}
+		} else if property.Transient != transient || property.DataType != dataType {
+			return nil, fmt.Errorf("Property does not match dump: %v", propertyName)
+		}
+	}
+
+	return table, nil
+}
+
+// Writes the events for a single object from a dump record. Returns the
+// number of events written.
+func (s *Server) importObject(table *Table, record map[string]interface{}) (int, error) {
+	objectId, ok := record["id"].(string)
+	if !ok {
+		return 0, errors.New("Object id required.")
+	}
+	_, servlet, err := s.GetObjectContext(table.Name, objectId)
+	if err != nil {
+		return 0, err
+	}
+
+	items, _ := record["events"].([]interface{})
+	events := make([]*Event, 0)
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return 0, errors.New("Event must be a map.")
+		}
+		event, err := table.DeserializeEvent(m)
+		if err != nil {
+			return 0, err
+		}
+		if err = table.FactorizeEvent(event, s.factors, true); err != nil {
+			return 0, err
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	return len(events), servlet.PutEvents(table, objectId, events, true)
+}
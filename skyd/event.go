@@ -119,12 +119,12 @@ func (e *Event) Equal(x *Event) bool {
 		return false
 	}
 	for k, v := range e.Data {
-		if normalize(v) != normalize(x.Data[k]) {
+		if !equalValues(v, x.Data[k]) {
 			return false
 		}
 	}
 	for k, v := range x.Data {
-		if normalize(v) != normalize(e.Data[k]) {
+		if !equalValues(v, e.Data[k]) {
 			return false
 		}
 	}
@@ -157,7 +157,7 @@ func (e *Event) MergePermanent(a *Event) {
 // Removes data in the event that is present in another event.
 func (e *Event) Dedupe(a *Event) {
 	for k, v := range a.Data {
-		if normalize(e.Data[k]) == normalize(v) {
+		if equalValues(e.Data[k], v) {
 			delete(e.Data, k)
 		}
 	}
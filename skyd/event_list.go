@@ -1,5 +1,9 @@
 package skyd
 
+import (
+	"sort"
+)
+
 type EventList []*Event
 
 // Determines the length of an event slice.
@@ -16,3 +20,39 @@ func (s EventList) Less(i, j int) bool {
 func (s EventList) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
+
+// Interleaves two event streams by timestamp and returns the combined stream
+// along with its permanent state. Events from both streams that share a
+// timestamp are merged with the data from the first stream taking precedence.
+// Permanent data is deduplicated against the combined state.
+func MergeEventLists(a []*Event, b []*Event) ([]*Event, *Event) {
+	// Order the second stream first so that the first wins on collisions.
+	combined := make([]*Event, 0, len(a)+len(b))
+	combined = append(combined, b...)
+	combined = append(combined, a...)
+	sort.Stable(EventList(combined))
+
+	// Merge events with the same timestamp.
+	events := make([]*Event, 0, len(combined))
+	for _, event := range combined {
+		if n := len(events); n > 0 && events[n-1].Timestamp.Equal(event.Timestamp) {
+			events[n-1].Merge(event)
+		} else {
+			e := &Event{Timestamp: event.Timestamp, Data: map[int64]interface{}{}}
+			e.Merge(event)
+			events = append(events, e)
+		}
+	}
+
+	// Rebuild the permanent state.
+	state := &Event{Data: map[int64]interface{}{}}
+	for _, event := range events {
+		event.Dedupe(state)
+		state.MergePermanent(event)
+	}
+	if len(events) > 0 {
+		state.Timestamp = events[len(events)-1].Timestamp
+	}
+
+	return events, state
+}
@@ -60,3 +60,42 @@ func TestDedupe(t *testing.T) {
 		t.Fatalf("Invalid dedupe: %v", a.Data)
 	}
 }
+
+// Ensure that list values are compared element by element.
+func TestDedupeLists(t *testing.T) {
+	a := NewEvent("1970-01-01T00:00:00Z", map[int64]interface{}{1: []interface{}{int64(1), int64(2)}, 2: []interface{}{int64(3)}})
+	b := NewEvent("1970-01-01T00:00:00Z", map[int64]interface{}{1: []interface{}{uint64(1), uint64(2)}, 2: []interface{}{int64(3), int64(4)}})
+	a.Dedupe(b)
+	if a.Data[1] != nil || a.Data[2] == nil {
+		t.Fatalf("Invalid dedupe: %v", a.Data)
+	}
+}
+
+// Ensure that two event streams can be interleaved.
+func TestMergeEventLists(t *testing.T) {
+	a := []*Event{
+		NewEvent("1970-01-01T00:00:00Z", map[int64]interface{}{1: "foo", -1: 10}),
+		NewEvent("1970-01-03T00:00:00Z", map[int64]interface{}{2: "bar"}),
+	}
+	b := []*Event{
+		NewEvent("1970-01-02T00:00:00Z", map[int64]interface{}{1: "foo", -1: 20}),
+		NewEvent("1970-01-03T00:00:00Z", map[int64]interface{}{2: "baz", -2: 30}),
+	}
+	events, state := MergeEventLists(a, b)
+	expected := []*Event{
+		NewEvent("1970-01-01T00:00:00Z", map[int64]interface{}{1: "foo", -1: 10}),
+		NewEvent("1970-01-02T00:00:00Z", map[int64]interface{}{-1: 20}),
+		NewEvent("1970-01-03T00:00:00Z", map[int64]interface{}{2: "bar", -2: 30}),
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v events, received %v", len(expected), len(events))
+	}
+	for i := range events {
+		if !expected[i].Equal(events[i]) {
+			t.Fatalf("Events not equal:\n  exp: %v\n  got: %v", expected[i], events[i])
+		}
+	}
+	if expectedState := NewEvent("1970-01-03T00:00:00Z", map[int64]interface{}{1: "foo", 2: "bar"}); !expectedState.Equal(state) {
+		t.Fatalf("Incorrect state.\nexp: %v\ngot: %v", expectedState, state)
+	}
+}
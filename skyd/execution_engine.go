@@ -12,13 +12,19 @@ int mp_pack(lua_State *L);
 int mp_unpack(lua_State *L);
 
 int executionEngine_nextObject(void *cursor);
+int executionEngine_nextChunk(void *cursor);
 
 int executionEngine_c_next_object(void *cursor) {
 	return (bool)executionEngine_nextObject(cursor);
 }
 
+int executionEngine_c_next_chunk(void *cursor) {
+	return (bool)executionEngine_nextChunk(cursor);
+}
+
 void executionEngine_setNextObjectFunc(void *cursor) {
 	((sky_cursor*)cursor)->next_object_func = executionEngine_c_next_object;
+	((sky_cursor*)cursor)->next_chunk_func = executionEngine_c_next_chunk;
 }
 
 */
@@ -28,7 +34,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"github.com/jmhodges/levigo"
 	"github.com/ugorji/go-msgpack"
 	"regexp"
 	"sort"
@@ -44,16 +49,19 @@ import (
 
 // An ExecutionEngine is used to iterate over a series of objects.
 type ExecutionEngine struct {
-	tableName    string
-	iterator     *levigo.Iterator
-	cursor       *C.sky_cursor
-	prefix       []byte
-	state        *C.lua_State
-	header       string
-	source       string
-	fullSource   string
-	propertyFile *PropertyFile
-	propertyRefs []*Property
+	tableName     string
+	iterator      StorageIterator
+	cursor        *C.sky_cursor
+	prefix        []byte
+	objectKey     []byte
+	values        [][]byte
+	state         *C.lua_State
+	header        string
+	source        string
+	fullSource    string
+	propertyFile  *PropertyFile
+	propertyRefs  []*Property
+	schemaVersion uint64
 
 	cprefix    unsafe.Pointer
 	cprefix_sz C.size_t
@@ -75,6 +83,7 @@ func NewExecutionEngine(table *Table, source string) (*ExecutionEngine, error) {
 	}
 
 	// Find a list of all references properties.
+	schemaVersion := propertyFile.Version()
 	propertyRefs, err := extractPropertyReferences(propertyFile, source)
 	if err != nil {
 		return nil, err
@@ -88,11 +97,12 @@ func NewExecutionEngine(table *Table, source string) (*ExecutionEngine, error) {
 
 	// Create the engine.
 	e := &ExecutionEngine{
-		tableName:    table.Name,
-		prefix:       prefix,
-		propertyFile: propertyFile,
-		source:       source,
-		propertyRefs: propertyRefs,
+		tableName:     table.Name,
+		prefix:        prefix,
+		propertyFile:  propertyFile,
+		source:        source,
+		propertyRefs:  propertyRefs,
+		schemaVersion: schemaVersion,
 	}
 
 	// Initialize the engine.
@@ -127,6 +137,27 @@ func (e *ExecutionEngine) FullSource() string {
 	return e.fullSource
 }
 
+// Retrieves the schema version of the table when the engine was created.
+func (e *ExecutionEngine) SchemaVersion() uint64 {
+	return e.schemaVersion
+}
+
+// Determines if a property referenced by the engine has been renamed,
+// retyped or removed since the engine was created. Properties added to the
+// table and changes to property metadata don't affect the engine.
+func (e *ExecutionEngine) SchemaChanged() bool {
+	if e.propertyFile.Version() == e.schemaVersion {
+		return false
+	}
+	for _, property := range e.propertyRefs {
+		current := e.propertyFile.GetProperty(property.Id)
+		if current == nil || current.Name != property.Name || current.Transient != property.Transient || current.DataType != property.DataType {
+			return true
+		}
+	}
+	return false
+}
+
 // Retrieves the full annotated source with line numbers.
 func (e *ExecutionEngine) FullAnnotatedSource() string {
 	lineNumber := 1
@@ -138,7 +169,7 @@ func (e *ExecutionEngine) FullAnnotatedSource() string {
 }
 
 // Sets the iterator to use.
-func (e *ExecutionEngine) SetIterator(iterator *levigo.Iterator) error {
+func (e *ExecutionEngine) SetIterator(iterator StorageIterator) error {
 	// Close the old iterator.
 	if e.iterator != nil {
 		e.iterator.Close()
@@ -146,6 +177,7 @@ func (e *ExecutionEngine) SetIterator(iterator *levigo.Iterator) error {
 
 	// Attach the new iterator.
 	e.iterator = iterator
+	e.objectKey, e.values = nil, nil
 	if e.iterator != nil {
 		e.iterator.Seek(e.prefix)
 	}
@@ -407,6 +439,15 @@ func metatypeFunctionDef(args ...interface{}) string {
 		switch property.DataType {
 		case StringDataType:
 			return fmt.Sprintf("%v = function(event) return ffi.string(event._%v.data, event._%v.length) end,", property.Name, property.Name, property.Name)
+		case FactorDataType, IntegerDataType:
+			// Integers are passed to Lua as numbers so they can be used as
+			// dimensions and aggregated. Integer values are limited to 2^53
+			// in magnitude when they're written so they're always exact.
+			return fmt.Sprintf("%v = function(event) return tonumber(event._%v) end,", property.Name, property.Name)
+		case TimestampDataType:
+			return fmt.Sprintf("%v = function(event) return tonumber(ffi.C.sky_timestamp_to_seconds(event._%v)) end,", property.Name, property.Name)
+		case FactorListDataType:
+			return fmt.Sprintf("%v = function(event) return sky_factor_list(event._%v) end,", property.Name, property.Name)
 		default:
 			return fmt.Sprintf("%v = function(event) return event._%v end,", property.Name, property.Name)
 		}
@@ -425,8 +466,10 @@ func getPropertyCType(property *Property) string {
 	switch property.DataType {
 	case StringDataType:
 		return "sky_string_t"
-	case FactorDataType, IntegerDataType:
-		return "int32_t"
+	case FactorDataType, IntegerDataType, TimestampDataType:
+		return "int64_t"
+	case FactorListDataType:
+		return "sky_factor_list_t"
 	case FloatDataType:
 		return "double"
 	case BooleanDataType:
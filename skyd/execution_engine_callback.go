@@ -18,6 +18,12 @@ import (
 func executionEngine_nextObject(cursor unsafe.Pointer) C.int {
 	e := (*ExecutionEngine)(((*C.sky_cursor)(cursor)).context)
 
+	// Skip over any chunks of the previous object that weren't read.
+	for e.objectKey != nil && e.iterator.Valid() && bytes.HasPrefix(e.iterator.Key(), e.objectKey) {
+		e.iterator.Next()
+	}
+	e.objectKey, e.values = nil, nil
+
 	// If the iterator is invalid then exit.
 	if !e.iterator.Valid() {
 		return 0
@@ -28,12 +34,55 @@ func executionEngine_nextObject(cursor unsafe.Pointer) C.int {
 	if !bytes.HasPrefix(key, e.prefix) {
 		return 0
 	}
+	sz, err := objectKeyLength(key)
+	if err != nil {
+		return 0
+	}
+	e.objectKey = key[:sz]
 
-	// Set the object data on the cursor.
-	value := e.iterator.Value()
+	// Set the object state on the cursor. The value is retained so that it
+	// isn't collected while the cursor points to it.
+	value, err := decodeValue(e.iterator.Value())
+	if err != nil {
+		warn("skyd.ExecutionEngine: Unable to decompress state %x: %v", key, err)
+		return 0
+	}
+	e.values = append(e.values, value)
 	C.sky_cursor_set_ptr(e.cursor, unsafe.Pointer(&value[0]), (C.size_t)(len(value)))
 
-	// Move to the next object.
+	// Move to the first chunk.
+	e.iterator.Next()
+
+	return 1
+}
+
+//export executionEngine_nextChunk
+func executionEngine_nextChunk(cursor unsafe.Pointer) C.int {
+	e := (*ExecutionEngine)(((*C.sky_cursor)(cursor)).context)
+
+	// If the iterator is invalid then exit.
+	if !e.iterator.Valid() {
+		return 0
+	}
+
+	// If the key isn't a chunk of the current object then the object is done.
+	key := e.iterator.Key()
+	if !bytes.HasPrefix(key, e.objectKey) || len(key) == len(e.objectKey) {
+		return 0
+	}
+
+	// Set the chunk data on the cursor.
+	value, err := decodeValue(e.iterator.Value())
+	if err != nil {
+		warn("skyd.ExecutionEngine: Unable to decompress chunk %x: %v", key, err)
+		return 0
+	}
+	e.values = append(e.values, value)
+	if len(value) > 0 {
+		C.sky_cursor_set_chunk_ptr(e.cursor, unsafe.Pointer(&value[0]), (C.size_t)(len(value)))
+	}
+
+	// Move to the next chunk.
 	e.iterator.Next()
 
 	return 1
@@ -32,3 +32,36 @@ func TestExecutionEngineExtractPropertyReferences(t *testing.T) {
 		t.Fatalf("Expected %v, got %v", p, l.propertyRefs[2])
 	}
 }
+
+// Ensure that the engine detects changes to the properties it references.
+func TestExecutionEngineSchemaChanged(t *testing.T) {
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	table.CreateProperty("name", false, "string")
+	table.CreateProperty("salary", false, "float")
+
+	e, err := NewExecutionEngine(table, "function f(event) return event:name() end")
+	if err != nil {
+		t.Fatalf("Unable to create execution engine: %v", err)
+	}
+	defer e.Destroy()
+
+	// Unreferenced properties don't affect the engine.
+	table.CreateProperty("age", false, "integer")
+	salary, _ := table.GetPropertyByName("salary")
+	table.RenameProperty(salary, "income")
+	if e.SchemaVersion() == table.propertyFile.Version() {
+		t.Fatalf("Expected schema version to change")
+	}
+	if e.SchemaChanged() {
+		t.Fatalf("Unexpected schema change")
+	}
+
+	name, _ := table.GetPropertyByName("name")
+	table.RenameProperty(name, "fullName")
+	if !e.SchemaChanged() {
+		t.Fatalf("Expected schema change")
+	}
+}
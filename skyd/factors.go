@@ -3,7 +3,6 @@ package skyd
 import (
 	"errors"
 	"fmt"
-	"github.com/jmhodges/levigo"
 	"strconv"
 	"sync"
 )
@@ -16,11 +15,10 @@ import (
 
 // A Factors object manages the factorization and defactorization of values.
 type Factors struct {
-	db    *levigo.DB
-	ro    *levigo.ReadOptions
-	wo    *levigo.WriteOptions
-	path  string
-	mutex sync.Mutex
+	db     Storage
+	engine StorageEngine
+	path   string
+	mutex  sync.Mutex
 }
 
 //------------------------------------------------------------------------------
@@ -84,19 +82,17 @@ func (f *Factors) Open() error {
 	}
 
 	// Open database.
-	opts := levigo.NewOptions()
-	opts.SetCreateIfMissing(true)
-	db, err := levigo.Open(f.path, opts)
+	engine := f.engine
+	if engine == nil {
+		engine = NewLevelDBEngine(nil)
+	}
+	db, err := engine.Open(f.path)
 	if err != nil {
 		f.Close()
 		return fmt.Errorf("skyd.Factors: Unable to open database: %v", err)
 	}
 	f.db = db
 
-	// Setup read and write options.
-	f.ro = levigo.NewReadOptions()
-	f.wo = levigo.NewWriteOptions()
-
 	return nil
 }
 
@@ -105,12 +101,6 @@ func (f *Factors) Close() {
 	if f.db != nil {
 		f.db.Close()
 	}
-	if f.ro != nil {
-		f.ro.Close()
-	}
-	if f.wo != nil {
-		f.wo.Close()
-	}
 }
 
 // Returns whether the factors database is open.
@@ -149,7 +139,7 @@ func (f *Factors) Factorize(namespace string, id string, value string, createIfM
 	}
 
 	// Otherwise find it in the LevelDB database.
-	data, err := f.db.Get(f.ro, []byte(f.key(namespace, id, value)))
+	data, err := f.db.Get([]byte(f.key(namespace, id, value)))
 	if err != nil {
 		return 0, err
 	}
@@ -188,11 +178,11 @@ func (f *Factors) add(namespace string, id string, value string) (uint64, error)
 	}
 
 	// Save lookup and reverse lookup.
-	err = f.db.Put(f.wo, []byte(f.key(namespace, id, value)), []byte(strconv.FormatUint(sequence, 10)))
+	err = f.db.Put([]byte(f.key(namespace, id, value)), []byte(strconv.FormatUint(sequence, 10)))
 	if err != nil {
 		return 0, err
 	}
-	err = f.db.Put(f.wo, []byte(f.revkey(namespace, id, sequence)), []byte(value))
+	err = f.db.Put([]byte(f.revkey(namespace, id, sequence)), []byte(value))
 	if err != nil {
 		return 0, err
 	}
@@ -208,7 +198,7 @@ func (f *Factors) Defactorize(namespace string, id string, value uint64) (string
 	}
 
 	// Find it in LevelDB.
-	data, err := f.db.Get(f.ro, []byte(f.revkey(namespace, id, value)))
+	data, err := f.db.Get([]byte(f.revkey(namespace, id, value)))
 	if err != nil {
 		return "", err
 	}
@@ -220,14 +210,14 @@ func (f *Factors) Defactorize(namespace string, id string, value uint64) (string
 
 // Retrieves the next available sequence number within a namespace for an id.
 func (f *Factors) inc(namespace string, id string) (uint64, error) {
-	data, err := f.db.Get(f.ro, []byte(f.seqkey(namespace, id)))
+	data, err := f.db.Get([]byte(f.seqkey(namespace, id)))
 	if err != nil {
 		return 0, err
 	}
 
 	// Initialize key if it doesn't exist. Otherwise increment it.
 	if data == nil {
-		err := f.db.Put(f.wo, []byte(f.seqkey(namespace, id)), []byte("1"))
+		err := f.db.Put([]byte(f.seqkey(namespace, id)), []byte("1"))
 		if err != nil {
 			return 0, err
 		}
@@ -242,7 +232,7 @@ func (f *Factors) inc(namespace string, id string) (uint64, error) {
 
 	// Increment and save the new value.
 	sequence += 1
-	err = f.db.Put(f.wo, []byte(f.seqkey(namespace, id)), []byte(strconv.FormatUint(sequence, 10)))
+	err = f.db.Put([]byte(f.seqkey(namespace, id)), []byte(strconv.FormatUint(sequence, 10)))
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,50 @@
+package skyd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Replaces a file with the output of an encoding function. The output is
+// written to a temporary file and flushed to disk before it is renamed over
+// the original so a crash never leaves a partially written file behind.
+// Callers must not save the same path concurrently.
+func saveFile(path string, encode func(io.Writer) error) error {
+	// Open the temporary file for writing.
+	tmpPath := fmt.Sprintf("%v.tmp", path)
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	// Then encode to it and flush it to disk.
+	w := bufio.NewWriter(file)
+	err = encode(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Replace the original file and make sure the rename is durable.
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
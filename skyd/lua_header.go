@@ -5,6 +5,7 @@ const LuaHeader = `
 local ffi = require('ffi')
 ffi.cdef([[
 typedef struct sky_string_t { int32_t length; char *data; } sky_string_t;
+typedef struct sky_factor_list_t { int32_t count; void *data; } sky_factor_list_t;
 typedef struct {
   {{range .}}{{structdef .}}
   {{end}}
@@ -25,6 +26,9 @@ bool sky_cursor_eos(sky_cursor_t *);
 bool sky_lua_cursor_next_event(sky_cursor_t *);
 bool sky_lua_cursor_next_session(sky_cursor_t *);
 bool sky_cursor_set_session_idle(sky_cursor_t *, uint32_t);
+
+int64_t sky_factor_list_at(sky_factor_list_t *list, uint32_t index);
+int64_t sky_timestamp_to_seconds(int64_t value);
 ]])
 ffi.metatype('sky_cursor_t', {
   __index = {
@@ -50,6 +54,23 @@ ffi.metatype('sky_lua_event_t', {
   }
 })
 
+-- Converts a factor list into a table of factors.
+function sky_factor_list(list)
+  local values = {}
+  for i = 0, list.count - 1 do
+    values[i + 1] = tonumber(ffi.C.sky_factor_list_at(list, i))
+  end
+  return values
+end
+
+-- Determines if a table of values contains a value.
+function sky_contains(values, value)
+  for _, v in ipairs(values) do
+    if v == value then return true end
+  end
+  return false
+end
+
 function sky_init_cursor(_cursor)
   cursor = ffi.cast('sky_cursor_t*', _cursor)
   {{range .}}{{initdescriptor .}}
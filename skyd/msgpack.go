@@ -0,0 +1,166 @@
+package skyd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when serialized data ends in the middle of a value or contains an
+// unknown type.
+var errInvalidMsgpack = errors.New("skyd: Invalid msgpack data.")
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Determines the number of bytes used by the msgpack value at the beginning
+// of a byte slice, including any nested values, without decoding it.
+func msgpackSize(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, errInvalidMsgpack
+	}
+
+	// Determine the header size, the data size and the number of nested values.
+	hdr, sz, count := 1, 0, 0
+	switch c := b[0]; {
+	case c <= 0x7f, c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+	case c >= 0xa0 && c <= 0xbf:
+		sz = int(c & 0x1f)
+	case c >= 0x90 && c <= 0x9f:
+		count = int(c & 0x0f)
+	case c >= 0x80 && c <= 0x8f:
+		count = int(c&0x0f) * 2
+	case c == 0xcc, c == 0xd0:
+		sz = 1
+	case c == 0xcd, c == 0xd1:
+		sz = 2
+	case c == 0xca, c == 0xce, c == 0xd2:
+		sz = 4
+	case c == 0xcb, c == 0xcf, c == 0xd3:
+		sz = 8
+	case c == 0xd4, c == 0xd5, c == 0xd6, c == 0xd7, c == 0xd8:
+		hdr, sz = 2, 1<<(c-0xd4)
+	case c == 0xc4, c == 0xc7, c == 0xd9:
+		n, err := msgpackLength(b, 1)
+		if err != nil {
+			return 0, err
+		}
+		hdr, sz = 2, n
+		if c == 0xc7 {
+			hdr, sz = 3, n
+		}
+	case c == 0xc5, c == 0xc8, c == 0xda:
+		n, err := msgpackLength(b, 2)
+		if err != nil {
+			return 0, err
+		}
+		hdr, sz = 3, n
+		if c == 0xc8 {
+			hdr, sz = 4, n
+		}
+	case c == 0xc6, c == 0xc9, c == 0xdb:
+		n, err := msgpackLength(b, 4)
+		if err != nil {
+			return 0, err
+		}
+		hdr, sz = 5, n
+		if c == 0xc9 {
+			hdr, sz = 6, n
+		}
+	case c == 0xdc, c == 0xdd, c == 0xde, c == 0xdf:
+		width := 2
+		if c == 0xdd || c == 0xdf {
+			width = 4
+		}
+		n, err := msgpackLength(b, width)
+		if err != nil {
+			return 0, err
+		}
+		hdr, count = 1+width, n
+		if c == 0xde || c == 0xdf {
+			count *= 2
+		}
+	default:
+		return 0, errInvalidMsgpack
+	}
+
+	// Skip over the data and each nested value.
+	index := hdr + sz
+	if index > len(b) {
+		return 0, errInvalidMsgpack
+	}
+	for i := 0; i < count; i++ {
+		n, err := msgpackSize(b[index:])
+		if err != nil {
+			return 0, err
+		}
+		index += n
+	}
+	return index, nil
+}
+
+// Reads the big endian length that follows a msgpack type byte.
+func msgpackLength(b []byte, width int) (int, error) {
+	if len(b) < 1+width {
+		return 0, errInvalidMsgpack
+	}
+	switch width {
+	case 1:
+		return int(b[1]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b[1:])), nil
+	default:
+		n := binary.BigEndian.Uint32(b[1:])
+		if uint64(n) > math.MaxInt32 {
+			return 0, errInvalidMsgpack
+		}
+		return int(n), nil
+	}
+}
+
+// Decodes the msgpack integer at the beginning of a byte slice. Returns the
+// value and the number of bytes used.
+func msgpackInt(b []byte) (int64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errInvalidMsgpack
+	}
+	c := b[0]
+	if c <= 0x7f {
+		return int64(c), 1, nil
+	} else if c >= 0xe0 {
+		return int64(int8(c)), 1, nil
+	}
+
+	sz, err := msgpackSize(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch c {
+	case 0xcc:
+		return int64(b[1]), sz, nil
+	case 0xcd:
+		return int64(binary.BigEndian.Uint16(b[1:])), sz, nil
+	case 0xce:
+		return int64(binary.BigEndian.Uint32(b[1:])), sz, nil
+	case 0xcf:
+		return int64(binary.BigEndian.Uint64(b[1:])), sz, nil
+	case 0xd0:
+		return int64(int8(b[1])), sz, nil
+	case 0xd1:
+		return int64(int16(binary.BigEndian.Uint16(b[1:]))), sz, nil
+	case 0xd2:
+		return int64(int32(binary.BigEndian.Uint32(b[1:]))), sz, nil
+	case 0xd3:
+		return int64(binary.BigEndian.Uint64(b[1:])), sz, nil
+	}
+	return 0, 0, errInvalidMsgpack
+}
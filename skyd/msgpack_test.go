@@ -0,0 +1,46 @@
+package skyd
+
+import (
+	"bytes"
+	"github.com/ugorji/go-msgpack"
+	"testing"
+)
+
+// Ensure that the size of encoded values can be found without decoding them.
+func TestMsgpackSize(t *testing.T) {
+	values := []interface{}{
+		nil, true, 1, -1, 300, -300, int64(1) << 40, 1.5, "foo", string(make([]byte, 300)),
+		[]interface{}{1, "two", []interface{}{3}}, map[string]interface{}{"a": 1, "b": []interface{}{"c"}},
+	}
+	for _, value := range values {
+		b, err := msgpack.Marshal(value)
+		if err != nil {
+			t.Fatalf("Unable to encode %v: %v", value, err)
+		}
+		if sz, err := msgpackSize(append(b, 0xc0)); sz != len(b) || err != nil {
+			t.Fatalf("Unexpected size for %v: %v (%v)", value, sz, err)
+		}
+		if _, err := msgpackSize(b[:len(b)-1]); err == nil {
+			t.Fatalf("Expected truncated value to fail: %v", value)
+		}
+	}
+}
+
+// Ensure that events can be summarized without decoding their data.
+func TestSummarizeEvents(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo", 2: []interface{}{1, 2}}).EncodeRaw(buffer)
+	NewEvent("2012-01-02T00:00:00Z", nil).EncodeRaw(buffer)
+	NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{-1: 5000000000}).EncodeRaw(buffer)
+
+	count, first, last, err := summarizeEvents(buffer.Bytes())
+	if err != nil || count != 3 {
+		t.Fatalf("Unexpected count: %v (%v)", count, err)
+	}
+	if !first.Equal(NewEvent("2012-01-01T00:00:00Z", nil).Timestamp) || !last.Equal(NewEvent("2012-01-03T00:00:00Z", nil).Timestamp) {
+		t.Fatalf("Unexpected timestamps: %v, %v", first, last)
+	}
+	if _, _, _, err = summarizeEvents(buffer.Bytes()[:buffer.Len()-1]); err == nil {
+		t.Fatalf("Expected truncated events to fail")
+	}
+}
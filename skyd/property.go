@@ -1,22 +1,79 @@
 package skyd
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// A Property is a loose schema column on a Table.
+// A Property is a loose schema column on a Table. Properties whose values
+// are still being converted from a previous data type have a migration.
+// Deleted properties are kept without a name until their values are purged.
 type Property struct {
 	Id        int64  `json:"id"`
 	Name      string `json:"name"`
 	Transient bool   `json:"transient"`
 	DataType  string `json:"dataType"`
+	PropertyMetadata
+	Migration *PropertyMigration `json:"migration,omitempty"`
+	Deleted   bool               `json:"deleted,omitempty"`
+}
+
+// PropertyMetadata describes what a property means and constrains the values
+// that can be written to it. Nulls are allowed unless nullable is false and
+// the default replaces null values when they're written.
+type PropertyMetadata struct {
+	Description string        `json:"description,omitempty"`
+	Unit        string        `json:"unit,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Nullable    *bool         `json:"nullable,omitempty"`
+	Default     interface{}   `json:"default,omitempty"`
+}
+
+// Creates property metadata from a map of settings. Settings that are not in
+// the map are copied from a base set of metadata and settings that are null
+// are cleared.
+func NewPropertyMetadata(m map[string]interface{}, base PropertyMetadata) (PropertyMetadata, error) {
+	metadata := base
+	if value, ok := m["description"]; ok {
+		if metadata.Description, ok = value.(string); !ok && value != nil {
+			return metadata, fmt.Errorf("Invalid property description: %v", value)
+		}
+	}
+	if value, ok := m["unit"]; ok {
+		if metadata.Unit, ok = value.(string); !ok && value != nil {
+			return metadata, fmt.Errorf("Invalid property unit: %v", value)
+		}
+	}
+	if value, ok := m["enum"]; ok {
+		if metadata.Enum, ok = value.([]interface{}); !ok && value != nil {
+			return metadata, fmt.Errorf("Invalid property enum: %v", value)
+		}
+	}
+	if value, ok := m["nullable"]; ok {
+		switch value := value.(type) {
+		case bool:
+			metadata.Nullable = &value
+		case nil:
+			metadata.Nullable = nil
+		default:
+			return metadata, fmt.Errorf("Invalid property nullable setting: %v", value)
+		}
+	}
+	if value, ok := m["default"]; ok {
+		metadata.Default = value
+	}
+	return metadata, nil
 }
 
 // NewProperty returns a new Property.
 func NewProperty(id int64, name string, transient bool, dataType string) (*Property, error) {
 	// Validate data type.
 	switch dataType {
-	case FactorDataType, StringDataType, IntegerDataType, FloatDataType, BooleanDataType:
+	case FactorDataType, FactorListDataType, StringDataType, IntegerDataType, FloatDataType, BooleanDataType, TimestampDataType:
 	default:
 		return nil, fmt.Errorf("Invalid property data type: %v", dataType)
 	}
@@ -28,3 +85,215 @@ func NewProperty(id int64, name string, transient bool, dataType string) (*Prope
 		DataType:  dataType,
 	}, nil
 }
+
+// Validates a value against the property's data type and converts it to the
+// type that is stored. Whole numbers up to 2^53 in magnitude are accepted for
+// integer properties.
+// Timestamps are accepted as RFC3339 strings and stored as Sky timestamps.
+// Factor lists are accepted as lists of strings. If lenient is true then
+// strings are also parsed into numbers and booleans, numbers and booleans are
+// formatted into strings and single values are wrapped in factor lists. Nil
+// values are allowed.
+func (p *Property) Cast(value interface{}, lenient bool) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch p.DataType {
+	case FactorDataType, StringDataType:
+		switch v := normalize(value).(type) {
+		case string:
+			return v, nil
+		case int64:
+			if lenient {
+				return strconv.FormatInt(v, 10), nil
+			}
+		case float64:
+			if lenient {
+				return strconv.FormatFloat(v, 'f', -1, 64), nil
+			}
+		case bool:
+			if lenient {
+				return strconv.FormatBool(v), nil
+			}
+		}
+
+	case IntegerDataType:
+		switch v := normalize(value).(type) {
+		case int64:
+			if v >= -maxIntegerValue && v <= maxIntegerValue {
+				return v, nil
+			}
+		case float64:
+			if v == math.Trunc(v) && v >= -maxIntegerValue && v <= maxIntegerValue {
+				return int64(v), nil
+			}
+		case string:
+			if lenient {
+				if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && i >= -maxIntegerValue && i <= maxIntegerValue {
+					return i, nil
+				}
+			}
+		}
+
+	case FloatDataType:
+		switch v := normalize(value).(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			if lenient {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					return f, nil
+				}
+			}
+		}
+
+	case BooleanDataType:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if lenient {
+				if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+					return b, nil
+				}
+			}
+		}
+
+	case TimestampDataType:
+		// Numbers from JSON aren't taken as stored timestamps.
+		if _, ok := value.(json.Number); ok {
+			break
+		}
+		switch v := normalize(value).(type) {
+		case int64:
+			return v, nil
+		case time.Time:
+			return ShiftTime(v), nil
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(v)); err == nil {
+				return ShiftTime(t), nil
+			}
+		}
+
+	case FactorListDataType:
+		element := p.element()
+		if list, ok := value.([]interface{}); ok {
+			values := make([]interface{}, 0, len(list))
+			for _, elem := range list {
+				v, err := element.Cast(elem, lenient)
+				if err != nil || v == nil {
+					return nil, fmt.Errorf("Invalid %v value for property %v: %v", p.DataType, p.Name, value)
+				}
+				values = append(values, v)
+			}
+			return values, nil
+		} else if lenient {
+			if v, err := element.Cast(value, lenient); err == nil {
+				return []interface{}{v}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Invalid %v value for property %v: %v", p.DataType, p.Name, value)
+}
+
+// Replaces the property's metadata. The enumerated values and the default
+// are converted to the property's data type and the default must be one of
+// the enumerated values. Strings are only parsed if lenient is true.
+func (p *Property) SetMetadata(metadata PropertyMetadata, lenient bool) error {
+	var enum []interface{}
+	for _, value := range metadata.Enum {
+		v, err := p.element().Cast(value, lenient)
+		if err != nil || v == nil {
+			return fmt.Errorf("Invalid enum value for property %v: %v", p.Name, value)
+		}
+		enum = append(enum, v)
+	}
+	metadata.Enum = enum
+
+	if metadata.Default != nil {
+		v, err := p.Cast(metadata.Default, lenient)
+		if err != nil {
+			return fmt.Errorf("Invalid default value for property %v: %v", p.Name, metadata.Default)
+		}
+		metadata.Default = v
+	}
+
+	property := *p
+	property.PropertyMetadata = metadata
+	if metadata.Default != nil && !property.allows(metadata.Default) {
+		return fmt.Errorf("Default value not allowed for property %v: %v", p.Name, metadata.Default)
+	}
+
+	p.PropertyMetadata = metadata
+	return nil
+}
+
+// Applies the property's default to a null value and then checks the value
+// against the property's nullability and enumerated values. The value must
+// already be converted to the property's data type.
+func (p *Property) Validate(value interface{}) (interface{}, error) {
+	if value == nil && p.Default != nil {
+		value, _ = p.Cast(p.Default, false)
+	}
+	if value == nil {
+		if p.Nullable != nil && !*p.Nullable {
+			return nil, fmt.Errorf("Null value not allowed for property %v", p.Name)
+		}
+		return nil, nil
+	}
+	if !p.allows(value) {
+		return nil, fmt.Errorf("Value not allowed for property %v: %v", p.Name, value)
+	}
+	return value, nil
+}
+
+// Converts a stored value into the form that is returned to clients.
+// Timestamps are formatted as RFC3339 strings in UTC. Factors are not
+// defactorized since that requires the factors database.
+func (p *Property) denormalize(value interface{}) interface{} {
+	if p.DataType == TimestampDataType {
+		if timestamp, ok := normalize(value).(int64); ok {
+			return UnshiftTime(timestamp).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return value
+}
+
+// Retrieves a property describing a single value of the property. Factor
+// lists hold factors and every other data type describes itself.
+func (p *Property) element() *Property {
+	if p.DataType == FactorListDataType {
+		element := *p
+		element.DataType = FactorDataType
+		return &element
+	}
+	return p
+}
+
+// Determines if a value is one of the property's enumerated values. Every
+// value in a factor list must be allowed. All values are allowed if the
+// property has no enumerated values.
+func (p *Property) allows(value interface{}) bool {
+	if len(p.Enum) == 0 {
+		return true
+	}
+	if list, ok := value.([]interface{}); ok && p.DataType == FactorListDataType {
+		for _, elem := range list {
+			if !p.allows(elem) {
+				return false
+			}
+		}
+		return true
+	}
+	element := p.element()
+	for _, allowed := range p.Enum {
+		if allowed, err := element.Cast(allowed, false); err == nil && equalValues(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,16 @@ package skyd
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 )
 
 //------------------------------------------------------------------------------
@@ -17,9 +21,14 @@ import (
 //------------------------------------------------------------------------------
 
 // A PropertyFile manages the serialization of Property objects for a table.
+// Properties are never modified once they're added to the file. Changes
+// replace them with a new copy and increment the schema version.
 type PropertyFile struct {
+	mutex            sync.RWMutex
+	saveMutex        sync.Mutex
 	opened           bool
 	path             string
+	version          uint64
 	properties       map[int64]*Property
 	propertiesByName map[string]*Property
 }
@@ -35,7 +44,7 @@ func NewPropertyFile(path string) *PropertyFile {
 	p := &PropertyFile{
 		path: path,
 	}
-	p.Reset()
+	p.reset()
 	return p
 }
 
@@ -58,6 +67,14 @@ func (p *PropertyFile) DbPath() string {
 	return ""
 }
 
+// The schema version. This is incremented every time a property is added,
+// changed or removed.
+func (p *PropertyFile) Version() uint64 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.version
+}
+
 //------------------------------------------------------------------------------
 //
 // Methods
@@ -70,32 +87,218 @@ func (p *PropertyFile) DbPath() string {
 
 // Adds a new property to the property file and generate an identifier for it.
 func (p *PropertyFile) CreateProperty(name string, transient bool, dataType string) (*Property, error) {
-	// Don't allow duplicate names.
-	if p.propertiesByName[name] != nil {
-		return nil, errors.New("Property already exists.")
-	}
-
 	property, err := NewProperty(0, name, transient, dataType)
 	if err != nil {
 		return nil, err
 	}
+	return p.AddProperty(property)
+}
+
+// Adds a property that hasn't been added to a property file yet and generates
+// an identifier for it.
+func (p *PropertyFile) AddProperty(property *Property) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Don't allow duplicate names.
+	if p.propertiesByName[property.Name] != nil {
+		return nil, errors.New("Property already exists.")
+	}
 
 	// Find the next object/action identifier.
 	if property.Transient {
-		_, property.Id = p.NextIdentifiers()
+		_, property.Id = p.nextIdentifiers()
 	} else {
-		property.Id, _ = p.NextIdentifiers()
+		property.Id, _ = p.nextIdentifiers()
 	}
 
 	// Add to the list.
 	p.properties[property.Id] = property
 	p.propertiesByName[property.Name] = property
+	p.version++
 
 	return property, nil
 }
 
+// Renames a property. The renamed property is returned as a new copy.
+func (p *PropertyFile) RenameProperty(property *Property, name string) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.renameProperty(property, name)
+}
+
+// Renames a property without locking.
+func (p *PropertyFile) renameProperty(property *Property, name string) (*Property, error) {
+	if property == nil || p.propertiesByName[property.Name] == nil {
+		return nil, errors.New("Property does not exist.")
+	}
+	if name == "" {
+		return nil, errors.New("Property name required.")
+	}
+	if name == property.Name {
+		return p.propertiesByName[name], nil
+	}
+	if p.propertiesByName[name] != nil {
+		return nil, errors.New("Property already exists.")
+	}
+
+	renamed := *p.propertiesByName[property.Name]
+	renamed.Name = name
+	delete(p.propertiesByName, property.Name)
+	p.properties[renamed.Id] = &renamed
+	p.propertiesByName[renamed.Name] = &renamed
+	p.version++
+
+	return &renamed, nil
+}
+
+// Changes the data type or transience of a property. The property is given a
+// new identifier so that its stored values can be migrated in the background.
+// The previous identifier is kept as an unnamed placeholder so that it isn't
+// reused while values still refer to it. Returns the changed property.
+func (p *PropertyFile) ChangeProperty(property *Property, dataType string, transient bool) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.changeProperty(property, dataType, transient)
+}
+
+// Changes the data type or transience of a property without locking.
+func (p *PropertyFile) changeProperty(property *Property, dataType string, transient bool) (*Property, error) {
+	if property == nil || p.propertiesByName[property.Name] == nil {
+		return nil, errors.New("Property does not exist.")
+	}
+	current := p.propertiesByName[property.Name]
+	if current.Migration != nil {
+		return nil, errors.New("Property migration is already in progress.")
+	}
+	if current.DataType == dataType && current.Transient == transient {
+		return current, nil
+	}
+
+	// Metadata is converted to the new data type.
+	changed, err := NewProperty(0, current.Name, transient, dataType)
+	if err != nil {
+		return nil, err
+	}
+	if err = changed.SetMetadata(current.PropertyMetadata, true); err != nil {
+		return nil, err
+	}
+	if changed.Transient {
+		_, changed.Id = p.nextIdentifiers()
+	} else {
+		changed.Id, _ = p.nextIdentifiers()
+	}
+	changed.Migration = &PropertyMigration{
+		SourceId:       current.Id,
+		SourceName:     current.Name,
+		SourceDataType: current.DataType,
+	}
+
+	placeholder := *current
+	placeholder.Name = ""
+	p.properties[placeholder.Id] = &placeholder
+	p.properties[changed.Id] = changed
+	p.propertiesByName[changed.Name] = changed
+	p.version++
+
+	return changed, nil
+}
+
+// Marks the migration of a property's values as complete and removes the
+// placeholder for its previous identifier. Returns the completed property.
+func (p *PropertyFile) CompleteMigration(property *Property) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if property == nil || p.properties[property.Id] == nil || p.properties[property.Id].Name == "" {
+		return nil, errors.New("Property does not exist.")
+	}
+	current := p.properties[property.Id]
+	if current.Migration == nil {
+		return current, nil
+	}
+
+	if placeholder := p.properties[current.Migration.SourceId]; placeholder != nil && placeholder.Name == "" && !placeholder.Deleted {
+		delete(p.properties, placeholder.Id)
+	}
+	completed := *current
+	completed.Migration = nil
+	p.properties[completed.Id] = &completed
+	p.propertiesByName[completed.Name] = &completed
+	p.version++
+
+	return &completed, nil
+}
+
+// Replaces the metadata of a property. The updated property is returned as a
+// new copy.
+func (p *PropertyFile) SetPropertyMetadata(property *Property, metadata PropertyMetadata) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.setPropertyMetadata(property, metadata)
+}
+
+// Replaces the metadata of a property without locking.
+func (p *PropertyFile) setPropertyMetadata(property *Property, metadata PropertyMetadata) (*Property, error) {
+	if property == nil || p.propertiesByName[property.Name] == nil {
+		return nil, errors.New("Property does not exist.")
+	}
+
+	updated := *p.propertiesByName[property.Name]
+	if err := updated.SetMetadata(metadata, false); err != nil {
+		return nil, err
+	}
+	p.properties[updated.Id] = &updated
+	p.propertiesByName[updated.Name] = &updated
+	p.version++
+
+	return &updated, nil
+}
+
+// Renames a property, replaces its metadata and changes its data type or
+// transience as a single update. Nothing is changed if any part of the update
+// is invalid. Returns the updated property.
+func (p *PropertyFile) UpdateProperty(property *Property, name string, metadata PropertyMetadata, dataType string, transient bool) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Properties are replaced rather than modified so copies of the lookups
+	// are enough to undo a partial update.
+	properties, propertiesByName, version := make(map[int64]*Property), make(map[string]*Property), p.version
+	for k, v := range p.properties {
+		properties[k] = v
+	}
+	for k, v := range p.propertiesByName {
+		propertiesByName[k] = v
+	}
+
+	updated, err := p.updateProperty(property, name, metadata, dataType, transient)
+	if err != nil {
+		p.properties, p.propertiesByName, p.version = properties, propertiesByName, version
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Applies each part of a property update in turn without locking.
+func (p *PropertyFile) updateProperty(property *Property, name string, metadata PropertyMetadata, dataType string, transient bool) (*Property, error) {
+	property, err := p.renameProperty(property, name)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(metadata, property.PropertyMetadata) {
+		if property, err = p.setPropertyMetadata(property, metadata); err != nil {
+			return nil, err
+		}
+	}
+	return p.changeProperty(property, dataType, transient)
+}
+
 // Retrieves a list of undeleted properties sorted by id.
 func (p *PropertyFile) GetProperties() []*Property {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	list := make([]*Property, 0)
 	for _, property := range p.propertiesByName {
 		list = append(list, property)
@@ -106,6 +309,13 @@ func (p *PropertyFile) GetProperties() []*Property {
 
 // Retrieves a list of all properties sorted by id.
 func (p *PropertyFile) GetAllProperties() []*Property {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.getAllProperties()
+}
+
+// Retrieves a list of all properties sorted by id without locking.
+func (p *PropertyFile) getAllProperties() []*Property {
 	list := make([]*Property, 0)
 	for _, property := range p.properties {
 		list = append(list, property)
@@ -116,26 +326,83 @@ func (p *PropertyFile) GetAllProperties() []*Property {
 
 // Retrieves a single property by id.
 func (p *PropertyFile) GetProperty(id int64) *Property {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
 	return p.properties[id]
 }
 
 // Retrieves a single property by name.
 func (p *PropertyFile) GetPropertyByName(name string) *Property {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
 	return p.propertiesByName[name]
 }
 
-// Deletes a property.
-func (p *PropertyFile) DeleteProperty(property *Property) {
-	if property != nil && property.Name != "" {
-		delete(p.properties, property.Id)
-		delete(p.propertiesByName, property.Name)
+// Retrieves the property whose values are being migrated from a previous
+// identifier. Returns nil if no property is migrating from the identifier.
+func (p *PropertyFile) GetMigratingProperty(sourceId int64) *Property {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for _, property := range p.propertiesByName {
+		if property.Migration != nil && property.Migration.SourceId == sourceId {
+			return property
+		}
+	}
+	return nil
+}
+
+// Deletes a property. The property is replaced by an unnamed placeholder so
+// that its values are skipped and its identifier isn't reused until its
+// values are purged. Returns the placeholder.
+func (p *PropertyFile) DeleteProperty(property *Property) (*Property, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if property == nil || p.propertiesByName[property.Name] == nil {
+		return nil, errors.New("Property does not exist.")
+	}
+	current := p.propertiesByName[property.Name]
+	if current.Migration != nil {
+		return nil, errors.New("Property migration is in progress.")
+	}
+
+	placeholder := *current
+	placeholder.Name = ""
+	placeholder.Deleted = true
+	p.properties[placeholder.Id] = &placeholder
+	delete(p.propertiesByName, current.Name)
+	p.version++
+
+	return &placeholder, nil
+}
+
+// Removes the placeholder of a deleted property once its values have been
+// purged. The identifier can be reused afterward.
+func (p *PropertyFile) CompletePurge(id int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.properties[id] == nil || !p.properties[id].Deleted {
+		return fmt.Errorf("skyd.PropertyFile: Deleted property not found: %v", id)
 	}
+	delete(p.properties, id)
+	p.version++
+
+	return nil
 }
 
 // Clears out the property file.
 func (p *PropertyFile) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reset()
+}
+
+// Clears out the property file without locking.
+func (p *PropertyFile) reset() {
 	p.properties = make(map[int64]*Property)
 	p.propertiesByName = make(map[string]*Property)
+	p.version++
 }
 
 //--------------------------------------
@@ -144,8 +411,15 @@ func (p *PropertyFile) Reset() {
 
 // Encodes a property file.
 func (p *PropertyFile) Encode(writer io.Writer) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.encode(writer)
+}
+
+// Encodes a property file without locking.
+func (p *PropertyFile) encode(writer io.Writer) error {
 	// Convert the lookup into a sorted slice.
-	list := p.GetAllProperties()
+	list := p.getAllProperties()
 
 	// Encode the slice.
 	encoder := json.NewEncoder(writer)
@@ -157,14 +431,24 @@ func (p *PropertyFile) Encode(writer io.Writer) error {
 func (p *PropertyFile) Decode(reader io.Reader) error {
 	list := make([]*Property, 0)
 	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
 	err := decoder.Decode(&list)
 	if err != nil {
 		return err
 	}
 
 	// Create lookups for the properties.
-	p.Reset()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reset()
 	for _, property := range list {
+		// Metadata values are held as the types they're cast to rather than
+		// as the numbers they were decoded from.
+		for i, value := range property.Enum {
+			property.Enum[i] = normalize(value)
+		}
+		property.Default = normalize(property.Default)
+
 		p.properties[property.Id] = property
 		if property.Name != "" {
 			p.propertiesByName[property.Name] = property
@@ -202,19 +486,25 @@ func (p *PropertyFile) Open() error {
 		return err
 	}
 
+	p.mutex.Lock()
 	p.opened = true
+	p.mutex.Unlock()
 
 	return nil
 }
 
 // Closes the property file.
 func (p *PropertyFile) Close() {
-	p.Reset()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reset()
 	p.opened = false
 }
 
 // Returns whether the property file is currently open.
 func (p *PropertyFile) IsOpen() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
 	return p.opened
 }
 
@@ -222,26 +512,24 @@ func (p *PropertyFile) IsOpen() bool {
 // Persistence
 //--------------------------------------
 
-// Saves the property file to disk.
+// Saves the property file to disk. The file is written to a temporary file
+// in the same directory and then renamed over the original so that a crash
+// never leaves a partially written file behind.
 func (p *PropertyFile) Save() error {
-	// Open the file for writing.
-	file, err := os.Create(p.path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Then decode it.
-	w := bufio.NewWriter(file)
-	err = p.Encode(w)
-	if err != nil {
+	// Saves share a temporary file so only allow one at a time.
+	p.saveMutex.Lock()
+	defer p.saveMutex.Unlock()
+
+	// Encode a snapshot first so that properties can still be read and
+	// changed while it's written to disk.
+	var buffer bytes.Buffer
+	if err := p.Encode(&buffer); err != nil {
 		return err
 	}
-	if err = w.Flush(); err != nil {
+	return saveFile(p.path, func(w io.Writer) error {
+		_, err := w.Write(buffer.Bytes())
 		return err
-	}
-
-	return nil
+	})
 }
 
 //--------------------------------------
@@ -250,10 +538,13 @@ func (p *PropertyFile) Save() error {
 
 // Converts a map with string keys to use property identifier keys.
 func (p *PropertyFile) NormalizeMap(m map[string]interface{}) (map[int64]interface{}, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	clone := make(map[int64]interface{})
 	for k, v := range m {
 		// Look up the property by name and convert it to the ID.
-		property := p.GetPropertyByName(string(k))
+		property := p.propertiesByName[string(k)]
 		if property != nil {
 			clone[property.Id] = v
 		} else {
@@ -263,14 +554,22 @@ func (p *PropertyFile) NormalizeMap(m map[string]interface{}) (map[int64]interfa
 	return clone, nil
 }
 
-// Converts a map with property identifier keys to use string keys.
+// Converts a map with property identifier keys to use string keys. Timestamps
+// are formatted as strings.
 func (p *PropertyFile) DenormalizeMap(m map[int64]interface{}) (map[string]interface{}, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	clone := make(map[string]interface{})
 	for k, v := range m {
 		// Look up the property by ID and convert it to the name.
-		property := p.GetProperty(k)
+		// Values of placeholders are left out until they're migrated or
+		// purged.
+		property := p.properties[k]
 		if property != nil {
-			clone[property.Name] = v
+			if property.Name != "" {
+				clone[property.Name] = property.denormalize(v)
+			}
 		} else {
 			return nil, fmt.Errorf("skyd.PropertyFile: Property not found: %v", k)
 		}
@@ -278,6 +577,42 @@ func (p *PropertyFile) DenormalizeMap(m map[int64]interface{}) (map[string]inter
 	return clone, nil
 }
 
+// Validates and converts the values of a map with property identifier keys
+// in place. Values are also checked against the metadata of each property.
+// Errors for each invalid property are combined into one error.
+func (p *PropertyFile) CastMap(m map[int64]interface{}, lenient bool) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	ids := make([]int, 0, len(m))
+	for k := range m {
+		ids = append(ids, int(k))
+	}
+	sort.Ints(ids)
+
+	messages := make([]string, 0)
+	for _, id := range ids {
+		property := p.properties[int64(id)]
+		if property == nil {
+			return fmt.Errorf("skyd.PropertyFile: Property not found: %v", id)
+		}
+		value, err := property.Cast(m[int64(id)], lenient)
+		if err == nil {
+			value, err = property.Validate(value)
+		}
+		if err != nil {
+			messages = append(messages, err.Error())
+			continue
+		}
+		m[int64(id)] = value
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
 //--------------------------------------
 // Factorization
 //--------------------------------------
@@ -297,6 +632,13 @@ func (p *PropertyFile) Factorize(property *Property, value interface{}) (interfa
 
 // Finds the next available action and object property identifiers.
 func (p *PropertyFile) NextIdentifiers() (int64, int64) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.nextIdentifiers()
+}
+
+// Finds the next available identifiers without locking.
+func (p *PropertyFile) nextIdentifiers() (int64, int64) {
 	var nextPermanentId, nextTransientId int64 = 1, -1
 	for _, property := range p.properties {
 		if property.Transient && property.Id <= nextTransientId {
@@ -2,7 +2,13 @@ package skyd
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Encode a property file.
@@ -64,6 +70,98 @@ func TestPropertyFileNormalizeMap(t *testing.T) {
 	}
 }
 
+// Validate and convert the values of a map against property data types.
+func TestPropertyFileCastMap(t *testing.T) {
+	p := NewPropertyFile("")
+	p.CreateProperty("name", false, "string")
+	p.CreateProperty("salary", false, "float")
+	p.CreateProperty("active", false, "boolean")
+	p.CreateProperty("purchaseAmount", true, "integer")
+
+	m := map[int64]interface{}{1: "bob", 2: 100, 3: true, -1: float64(12)}
+	if err := p.CastMap(m, false); err != nil {
+		t.Fatalf("Unable to cast map: %v", err)
+	}
+	if m[2] != float64(100) || m[-1] != int64(12) {
+		t.Fatalf("Unexpected values: %v", m)
+	}
+
+	// Strings are only parsed in lenient mode.
+	m = map[int64]interface{}{3: "true", -1: "42"}
+	if err := p.CastMap(m, false); err == nil || err.Error() != "Invalid integer value for property purchaseAmount: 42; Invalid boolean value for property active: true" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.CastMap(m, true); err != nil {
+		t.Fatalf("Unable to cast map: %v", err)
+	}
+	if m[3] != true || m[-1] != int64(42) {
+		t.Fatalf("Unexpected values: %v", m)
+	}
+
+	// Fractional numbers are never integers or booleans.
+	m = map[int64]interface{}{3: 1.5, -1: 1.5}
+	if err := p.CastMap(m, true); err == nil {
+		t.Fatalf("Expected cast to fail: %v", m)
+	}
+}
+
+// Validate and convert timestamps and factor lists.
+func TestPropertyFileCastMapDataTypes(t *testing.T) {
+	p := NewPropertyFile("")
+	p.CreateProperty("signup", false, "timestamp")
+	p.CreateProperty("tags", false, "factor[]")
+
+	m := map[int64]interface{}{1: "2012-01-02T00:00:00Z", 2: []interface{}{"red", "blue"}}
+	if err := p.CastMap(m, false); err != nil {
+		t.Fatalf("Unable to cast map: %v", err)
+	}
+	if m[1] != ShiftTime(time.Date(2012, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected timestamp: %v", m[1])
+	}
+	if !equalValues(m[2], []interface{}{"red", "blue"}) {
+		t.Fatalf("Unexpected factor list: %v", m[2])
+	}
+
+	// Single factors are only wrapped in lenient mode.
+	m = map[int64]interface{}{1: "yesterday", 2: "red"}
+	if err := p.CastMap(m, false); err == nil || err.Error() != "Invalid timestamp value for property signup: yesterday; Invalid factor[] value for property tags: red" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m = map[int64]interface{}{2: "red"}
+	if err := p.CastMap(m, true); err != nil || !equalValues(m[2], []interface{}{"red"}) {
+		t.Fatalf("Unexpected lenient cast: %v (%v)", m, err)
+	}
+
+	// Timestamps are formatted when denormalized.
+	ret, err := p.DenormalizeMap(map[int64]interface{}{1: ShiftTime(time.Date(2012, 1, 2, 0, 0, 0, 0, time.UTC))})
+	if err != nil || ret["signup"] != "2012-01-02T00:00:00Z" {
+		t.Fatalf("Unexpected denormalized map: %v (%v)", ret, err)
+	}
+}
+
+// Convert JSON numbers without losing the precision of large integers.
+func TestPropertyFileCastMapJSONNumbers(t *testing.T) {
+	p := NewPropertyFile("")
+	p.CreateProperty("views", false, "integer")
+	p.CreateProperty("price", false, "float")
+	p.CreateProperty("name", false, "string")
+	p.CreateProperty("signup", false, "timestamp")
+
+	m := map[int64]interface{}{1: json.Number("9007199254740992"), 2: json.Number("12.5"), 3: json.Number("9007199254740993")}
+	if err := p.CastMap(m, true); err != nil {
+		t.Fatalf("Unable to cast map: %v", err)
+	}
+	if m[1] != int64(9007199254740992) || m[2] != 12.5 || m[3] != "9007199254740993" {
+		t.Fatalf("Unexpected values: %v", m)
+	}
+
+	// Numbers beyond 2^53 aren't integers since queries couldn't see them.
+	m = map[int64]interface{}{1: json.Number("-9007199254740993"), 4: json.Number("100")}
+	if err := p.CastMap(m, false); err == nil || err.Error() != "Invalid integer value for property views: -9007199254740993; Invalid timestamp value for property signup: 100" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
 // Convert a map of string keys into property id keys.
 func TestPropertyFileDenormalizeMap(t *testing.T) {
 	p := NewPropertyFile("")
@@ -86,3 +184,154 @@ func TestPropertyFileDenormalizeMap(t *testing.T) {
 		t.Fatalf("ret[\"purchaseAmount\"]: Expected %q, got %q", 12, ret["purchaseAmount"])
 	}
 }
+
+// Save a property file without leaving a temporary file behind.
+func TestPropertyFileSave(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+
+	p := NewPropertyFile(path + "/properties")
+	p.CreateProperty("name", false, "string")
+	if err := p.Save(); err != nil {
+		t.Fatalf("Unable to save property file: %v", err)
+	}
+	p.CreateProperty("salary", false, "float")
+	if err := p.Save(); err != nil {
+		t.Fatalf("Unable to save property file: %v", err)
+	}
+	if _, err := os.Stat(path + "/properties.tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Temporary property file not removed: %v", err)
+	}
+
+	p = NewPropertyFile(path + "/properties")
+	if err := p.Open(); err != nil {
+		t.Fatalf("Unable to open property file: %v", err)
+	}
+	assertProperty(t, p.GetPropertyByName("name"), 1, "name", false, "string")
+	assertProperty(t, p.GetPropertyByName("salary"), 2, "salary", false, "float")
+}
+
+// Rename a property and increment the schema version.
+func TestPropertyFileRenameProperty(t *testing.T) {
+	p := NewPropertyFile("")
+	property, _ := p.CreateProperty("name", false, "string")
+	p.CreateProperty("salary", false, "float")
+	version := p.Version()
+
+	renamed, err := p.RenameProperty(property, "fullName")
+	if err != nil {
+		t.Fatalf("Unable to rename property: %v", err)
+	}
+	assertProperty(t, renamed, 1, "fullName", false, "string")
+	assertProperty(t, property, 1, "name", false, "string")
+	if p.GetPropertyByName("name") != nil || p.GetPropertyByName("fullName") != renamed || p.GetProperty(1) != renamed {
+		t.Fatalf("Property lookups not updated: %v", p.GetAllProperties())
+	}
+	if p.Version() != version+1 {
+		t.Fatalf("Unexpected version: %v", p.Version())
+	}
+
+	// Names must be unique.
+	if _, err := p.RenameProperty(renamed, "salary"); err == nil || err.Error() != "Property already exists." {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := p.RenameProperty(property, "name2"); err == nil || err.Error() != "Property does not exist." {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Version() != version+1 {
+		t.Fatalf("Unexpected version: %v", p.Version())
+	}
+}
+
+// Create and look up properties concurrently.
+func TestPropertyFileConcurrency(t *testing.T) {
+	p := NewPropertyFile("")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("p%d_%d", i, j)
+				if _, err := p.CreateProperty(name, false, "string"); err != nil {
+					t.Errorf("Unable to create property: %v", err)
+				}
+				if p.GetPropertyByName(name) == nil {
+					t.Errorf("Property not found: %v", name)
+				}
+				p.GetProperties()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(p.GetAllProperties()); n != 1000 {
+		t.Fatalf("Expected %v properties, got %v", 1000, n)
+	}
+	if next, _ := p.NextIdentifiers(); next != 1001 {
+		t.Fatalf("Unexpected next identifier: %v", next)
+	}
+}
+
+// Delete a property and keep its identifier until its values are purged.
+func TestPropertyFileDeleteProperty(t *testing.T) {
+	p := NewPropertyFile("")
+	property, _ := p.CreateProperty("name", false, "string")
+	p.CreateProperty("salary", false, "float")
+	salary := p.GetPropertyByName("salary")
+
+	placeholder, err := p.DeleteProperty(salary)
+	if err != nil {
+		t.Fatalf("Unable to delete property: %v", err)
+	}
+	if p.GetPropertyByName("salary") != nil || p.GetProperty(2) != placeholder || !placeholder.Deleted || placeholder.Name != "" {
+		t.Fatalf("Unexpected placeholder: %v", p.GetProperty(2))
+	}
+	if next, _ := p.NextIdentifiers(); next != 3 {
+		t.Fatalf("Unexpected next identifier: %v", next)
+	}
+	if m, err := p.DenormalizeMap(map[int64]interface{}{1: "bob", 2: 100}); err != nil || len(m) != 1 || m["name"] != "bob" {
+		t.Fatalf("Unexpected denormalized map: %v (%v)", m, err)
+	}
+
+	// The identifier is released once the values are purged.
+	if err := p.CompletePurge(property.Id); err == nil {
+		t.Fatalf("Expected purge of undeleted property to fail")
+	}
+	if err := p.CompletePurge(2); err != nil {
+		t.Fatalf("Unable to complete purge: %v", err)
+	}
+	if next, _ := p.NextIdentifiers(); next != 2 {
+		t.Fatalf("Unexpected next identifier: %v", next)
+	}
+}
+
+// Change a property's data type and release its previous identifier once its
+// values are migrated.
+func TestPropertyFileChangeProperty(t *testing.T) {
+	p := NewPropertyFile("")
+	property, _ := p.CreateProperty("name", false, "string")
+
+	changed, err := p.ChangeProperty(property, "factor", true)
+	if err != nil {
+		t.Fatalf("Unable to change property: %v", err)
+	}
+	assertProperty(t, changed, -1, "name", true, "factor")
+	if placeholder := p.GetProperty(1); placeholder == nil || placeholder.Name != "" || placeholder.Deleted {
+		t.Fatalf("Unexpected placeholder: %v", placeholder)
+	}
+	if _, err := p.ChangeProperty(changed, "string", false); err == nil || err.Error() != "Property migration is already in progress." {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	completed, err := p.CompleteMigration(changed)
+	if err != nil {
+		t.Fatalf("Unable to complete migration: %v", err)
+	}
+	if completed.Migration != nil || p.GetPropertyByName("name") != completed {
+		t.Fatalf("Unexpected completed property: %v", completed)
+	}
+	if p.GetProperty(1) != nil || len(p.GetAllProperties()) != 1 {
+		t.Fatalf("Expected placeholder to be removed: %v", p.GetAllProperties())
+	}
+}
@@ -0,0 +1,366 @@
+package skyd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	PropertyMigrationRunning  = "running"
+	PropertyMigrationComplete = "complete"
+	PropertyMigrationFailed   = "failed"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when a migration or purge is interrupted by the server shutting down. The
+// migration is resumed when the server is next opened.
+var errPropertyMigrationStopped = errors.New("skyd: Property migration stopped.")
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A PropertyMigration describes where the stored values of a property came
+// from before its data type or transience was changed. The source name is
+// used to defactorize values that were stored as factors.
+type PropertyMigration struct {
+	SourceId       int64  `json:"sourceId"`
+	SourceName     string `json:"sourceName"`
+	SourceDataType string `json:"sourceDataType"`
+}
+
+// PropertyMigrationStatus reports the progress of a property migration or
+// purge. The value count is the number of values converted or removed and
+// the dropped count is the number of values that couldn't be represented in
+// the new data type.
+type PropertyMigrationStatus struct {
+	mutex          sync.Mutex
+	State          string `json:"state"`
+	ObjectCount    int    `json:"objectCount"`
+	ProcessedCount int    `json:"processedCount"`
+	ValueCount     int    `json:"valueCount"`
+	DroppedCount   int    `json:"droppedCount"`
+	Error          string `json:"error,omitempty"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Conversion
+//--------------------------------------
+
+// Converts a stored value from the source data type to the data type of a
+// property. Factors are defactorized before conversion and refactorized
+// under the property's name afterward. Returns false if the value can't be
+// represented in the new data type.
+func (m *PropertyMigration) convert(tableName string, property *Property, factors *Factors, value interface{}) (interface{}, bool, error) {
+	value, ok := m.cast(tableName, property, factors, value)
+	if !ok || value == nil {
+		return value, ok, nil
+	}
+
+	// Factorize strings for factor properties.
+	var err error
+	switch property.DataType {
+	case FactorDataType:
+		if stringValue, ok := value.(string); ok {
+			if value, err = factors.Factorize(tableName, property.Name, stringValue, true); err != nil {
+				return nil, false, err
+			}
+		}
+	case FactorListDataType:
+		if list, ok := value.([]interface{}); ok {
+			for i, elem := range list {
+				if list[i], err = factors.Factorize(tableName, property.Name, elem.(string), true); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+	}
+
+	return value, true, nil
+}
+
+// Converts a stored value from the source data type to the data type of a
+// property without factorizing it. Factors are defactorized before
+// conversion. Returns false if the value can't be represented in the new
+// data type.
+func (m *PropertyMigration) cast(tableName string, property *Property, factors *Factors, value interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+
+	// Convert factors back to their original strings.
+	switch m.SourceDataType {
+	case FactorDataType:
+		stringValue, ok := m.defactorize(tableName, factors, value)
+		if !ok {
+			return nil, false
+		}
+		value = stringValue
+	case FactorListDataType:
+		list, ok := normalize(value).([]interface{})
+		if !ok {
+			return nil, false
+		}
+		for i, elem := range list {
+			if list[i], ok = m.defactorize(tableName, factors, elem); !ok {
+				return nil, false
+			}
+		}
+		value = list
+	}
+
+	// Cast to the new data type.
+	value, err := property.Cast(value, true)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Converts a stored factor of the source property back to its string.
+func (m *PropertyMigration) defactorize(tableName string, factors *Factors, value interface{}) (string, bool) {
+	sequence, ok := normalize(value).(int64)
+	if !ok {
+		return "", false
+	}
+	stringValue, err := factors.Defactorize(tableName, m.SourceName, uint64(sequence))
+	if err != nil {
+		return "", false
+	}
+	return stringValue, true
+}
+
+//--------------------------------------
+// Status
+//--------------------------------------
+
+// Adds the number of objects processed and values converted and dropped.
+func (s *PropertyMigrationStatus) add(objectCount int, valueCount int, droppedCount int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ProcessedCount += objectCount
+	s.ValueCount += valueCount
+	s.DroppedCount += droppedCount
+}
+
+// Sets the total number of objects to be processed.
+func (s *PropertyMigrationStatus) setObjectCount(count int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ObjectCount = count
+}
+
+// Sets the state of the migration along with an optional error.
+func (s *PropertyMigrationStatus) setState(state string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.State = state
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Returns a copy of the status that is safe to read.
+func (s *PropertyMigrationStatus) copy() *PropertyMigrationStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &PropertyMigrationStatus{
+		State:          s.State,
+		ObjectCount:    s.ObjectCount,
+		ProcessedCount: s.ProcessedCount,
+		ValueCount:     s.ValueCount,
+		DroppedCount:   s.DroppedCount,
+		Error:          s.Error,
+	}
+}
+
+//--------------------------------------
+// Server
+//--------------------------------------
+
+// Retrieves the status of the most recent migration of a property since the
+// server was opened. Returns nil if there isn't one.
+func (s *Server) GetPropertyMigrationStatus(table *Table, property *Property) *PropertyMigrationStatus {
+	s.migrationMutex.Lock()
+	defer s.migrationMutex.Unlock()
+	if status := s.migrations[table.Name][property.Id]; status != nil {
+		return status.copy()
+	}
+	return nil
+}
+
+// Retrieves the status of the purge of a deleted property's values since the
+// server was opened. Returns nil if there isn't one.
+func (s *Server) GetPropertyPurgeStatus(table *Table, id int64) *PropertyMigrationStatus {
+	s.migrationMutex.Lock()
+	defer s.migrationMutex.Unlock()
+	if status := s.purges[table.Name][id]; status != nil {
+		return status.copy()
+	}
+	return nil
+}
+
+// Starts converting the stored values of a property in the background.
+func (s *Server) startPropertyMigration(table *Table, property *Property) {
+	status := s.addPropertyJob(s.migrations, table, property.Id)
+	s.migrationGroup.Add(1)
+	go s.migrateProperty(table, property, status, s.migrationStop)
+}
+
+// Starts removing the stored values of a deleted property in the background.
+func (s *Server) startPropertyPurge(table *Table, property *Property) {
+	status := s.addPropertyJob(s.purges, table, property.Id)
+	s.migrationGroup.Add(1)
+	go s.purgeProperty(table, property.Id, status, s.migrationStop)
+}
+
+// Records the status of a new migration or purge.
+func (s *Server) addPropertyJob(jobs map[string]map[int64]*PropertyMigrationStatus, table *Table, id int64) *PropertyMigrationStatus {
+	s.migrationMutex.Lock()
+	defer s.migrationMutex.Unlock()
+
+	status := &PropertyMigrationStatus{State: PropertyMigrationRunning}
+	if jobs[table.Name] == nil {
+		jobs[table.Name] = make(map[int64]*PropertyMigrationStatus)
+	}
+	jobs[table.Name][id] = status
+	return status
+}
+
+// Restarts every property migration and purge that was interrupted. Only
+// the tables with unfinished work are opened.
+func (s *Server) resumePropertyJobs() {
+	tables, err := s.GetAllTables()
+	if err != nil {
+		s.logger.Printf("ERROR Migration: %v", err)
+		return
+	}
+
+	for _, table := range tables {
+		propertyFile := NewPropertyFile(fmt.Sprintf("%v/%v", table.Path(), "properties"))
+		if err := propertyFile.Open(); err != nil {
+			s.logger.Printf("ERROR Migration [%s]: %v", table.Name, err)
+			continue
+		}
+		pending := false
+		for _, property := range propertyFile.GetAllProperties() {
+			pending = pending || property.Migration != nil || property.Deleted
+		}
+		if !pending {
+			continue
+		}
+
+		opened, err := s.OpenTable(table.Name)
+		if err != nil {
+			s.logger.Printf("ERROR Migration [%s]: %v", table.Name, err)
+			continue
+		}
+		for _, property := range opened.propertyFile.GetAllProperties() {
+			if property.Migration != nil {
+				s.startPropertyMigration(opened, property)
+			} else if property.Deleted {
+				s.startPropertyPurge(opened, property)
+			}
+		}
+	}
+}
+
+// Converts the stored values of a property on each servlet and then marks
+// the migration as complete. Stops early if the server is closed.
+func (s *Server) migrateProperty(table *Table, property *Property, status *PropertyMigrationStatus, stop chan bool) {
+	defer s.migrationGroup.Done()
+
+	err := s.runPropertyJob(table, status, func(servlet *Servlet) error {
+		return servlet.MigrateProperty(table, property, s.factors, status, stop)
+	})
+	if err == nil {
+		_, err = table.CompletePropertyMigration(property)
+	}
+	if err == errPropertyMigrationStopped {
+		return
+	} else if err != nil {
+		status.setState(PropertyMigrationFailed, err)
+		s.logger.Printf("ERROR Migration [%s]: %s: %v", table.Name, property.Name, err)
+		return
+	}
+	status.setState(PropertyMigrationComplete, nil)
+
+	status = status.copy()
+	s.logger.Printf("Migration [%s]: Converted %d values for property %s (%d dropped)", table.Name, status.ValueCount, property.Name, status.DroppedCount)
+}
+
+// Removes the stored values of a deleted property on each servlet and then
+// releases its identifier. Stops early if the server is closed.
+func (s *Server) purgeProperty(table *Table, id int64, status *PropertyMigrationStatus, stop chan bool) {
+	defer s.migrationGroup.Done()
+
+	err := s.runPropertyJob(table, status, func(servlet *Servlet) error {
+		return servlet.PurgeProperty(table, id, status, stop)
+	})
+	if err == nil {
+		err = table.CompletePropertyPurge(id)
+	}
+	if err == errPropertyMigrationStopped {
+		return
+	} else if err != nil {
+		status.setState(PropertyMigrationFailed, err)
+		s.logger.Printf("ERROR Purge [%s]: %d: %v", table.Name, id, err)
+		return
+	}
+	status.setState(PropertyMigrationComplete, nil)
+
+	status = status.copy()
+	s.logger.Printf("Purge [%s]: Removed %d values for property %d", table.Name, status.ValueCount, id)
+}
+
+// Counts the objects in a table so that progress can be reported and then
+// runs a function against each servlet. Queued writes are committed first so
+// that values written under a previous identifier are seen by the job.
+func (s *Server) runPropertyJob(table *Table, status *PropertyMigrationStatus, fn func(*Servlet) error) error {
+	count := 0
+	for _, servlet := range s.servlets {
+		if err := servlet.FlushWrites(); err != nil {
+			return err
+		}
+		db, err := servlet.tableDB(table.Name)
+		if err == errTableStoreNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		n, err := servlet.countObjects(db)
+		db.release()
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+	status.setObjectCount(count)
+
+	for _, servlet := range s.servlets {
+		if err := fn(servlet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
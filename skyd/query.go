@@ -77,8 +77,8 @@ func (q *Query) Deserialize(obj map[string]interface{}) error {
 	var err error
 
 	// Deserialize "session idle time".
-	if sessionIdleTime, ok := obj["sessionIdleTime"].(float64); ok || obj["sessionIdleTime"] == nil {
-		q.SessionIdleTime = int(sessionIdleTime)
+	if sessionIdleTime, ok := toInt(obj["sessionIdleTime"]); ok || obj["sessionIdleTime"] == nil {
+		q.SessionIdleTime = sessionIdleTime
 	} else {
 		return fmt.Errorf("Invalid 'sessionIdleTime': %v", obj["sessionIdleTime"])
 	}
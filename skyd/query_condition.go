@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 //------------------------------------------------------------------------------
@@ -124,13 +125,13 @@ func (c *QueryCondition) Deserialize(obj map[string]interface{}) error {
 
 	// Deserialize "within" range.
 	if withinRange, ok := obj["within"].([]interface{}); ok && len(withinRange) == 2 {
-		if withinRangeStart, ok := withinRange[0].(float64); ok {
-			c.WithinRangeStart = int(withinRangeStart)
+		if withinRangeStart, ok := toInt(withinRange[0]); ok {
+			c.WithinRangeStart = withinRangeStart
 		} else {
 			return fmt.Errorf("skyd.QueryCondition: Invalid 'within' range start: %v", withinRange[0])
 		}
-		if withinRangeEnd, ok := withinRange[1].(float64); ok {
-			c.WithinRangeEnd = int(withinRangeEnd)
+		if withinRangeEnd, ok := toInt(withinRange[1]); ok {
+			c.WithinRangeEnd = withinRangeEnd
 		} else {
 			return fmt.Errorf("skyd.QueryCondition: Invalid 'within' range end: %v", withinRange[1])
 		}
@@ -252,7 +253,7 @@ func (c *QueryCondition) CodegenExpression() (string, error) {
 	}
 
 	// Full expressions should be prepended with cursor's event reference.
-	r, _ := regexp.Compile(`^ *(\w+) *(==) *(?:"([^"]*)"|'([^']*)'|(\d+(?:\.\d+)?)|(true|false)) *$`)
+	r, _ := regexp.Compile(`^ *(\w+) *(==|!=|<=|>=|<|>) *(?:"([^"]*)"|'([^']*)'|(\d+(?:\.\d+)?)|(true|false)) *$`)
 	m := r.FindSubmatch([]byte(c.Expression))
 	if m == nil {
 		return "", fmt.Errorf("skyd.QueryCondition: Invalid expression: %v", c.Expression)
@@ -264,10 +265,20 @@ func (c *QueryCondition) CodegenExpression() (string, error) {
 		return "", fmt.Errorf("skyd.QueryCondition: Property not found: %v", string(m[1]))
 	}
 
+	// Only numbers and timestamps can be ordered.
+	op := string(m[2])
+	if op != "==" && op != "!=" {
+		switch property.DataType {
+		case IntegerDataType, FloatDataType, TimestampDataType:
+		default:
+			return "", fmt.Errorf("skyd.QueryCondition: Ordering operators are only supported for integer, float and timestamp properties: %v", c.Expression)
+		}
+	}
+
 	// Validate the expression value.
 	var value string
 	switch property.DataType {
-	case FactorDataType, StringDataType:
+	case FactorDataType, FactorListDataType, StringDataType:
 		// Validate string value.
 		var stringValue string
 		if m[3] != nil {
@@ -279,7 +290,7 @@ func (c *QueryCondition) CodegenExpression() (string, error) {
 		}
 
 		// Convert factors.
-		if property.DataType == FactorDataType {
+		if property.DataType == FactorDataType || property.DataType == FactorListDataType {
 			sequence, err := c.query.factors.Factorize(c.query.table.Name, property.Name, stringValue, false)
 			if err != nil {
 				return "", err
@@ -301,9 +312,35 @@ func (c *QueryCondition) CodegenExpression() (string, error) {
 			return "", fmt.Errorf("skyd.QueryCondition: Expression value must be a boolean literal for boolean properties: %v", c.Expression)
 		}
 		value = string(m[6])
+
+	case TimestampDataType:
+		// Timestamps are compared in seconds.
+		var stringValue string
+		if m[3] != nil {
+			stringValue = string(m[3])
+		} else if m[4] != nil {
+			stringValue = string(m[4])
+		}
+		t, err := time.Parse(time.RFC3339, stringValue)
+		if err != nil {
+			return "", fmt.Errorf("skyd.QueryCondition: Expression value must be an RFC3339 string literal for timestamp properties: %v", c.Expression)
+		}
+		value = strconv.FormatInt(t.Unix(), 10)
 	}
 
-	return fmt.Sprintf("cursor.event:%s() %s %s", m[1], m[2], value), nil
+	// Factor lists match if any of their factors match.
+	if property.DataType == FactorListDataType {
+		if op == "!=" {
+			return fmt.Sprintf("not sky_contains(cursor.event:%s(), %s)", m[1], value), nil
+		}
+		return fmt.Sprintf("sky_contains(cursor.event:%s(), %s)", m[1], value), nil
+	}
+
+	// Lua spells inequality differently.
+	if op == "!=" {
+		op = "~="
+	}
+	return fmt.Sprintf("cursor.event:%s() %s %s", m[1], op, value), nil
 }
 
 //--------------------------------------
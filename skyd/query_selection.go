@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 )
 
 //------------------------------------------------------------------------------
@@ -166,12 +167,21 @@ func (s *QuerySelection) CodegenAggregateFunction() (string, error) {
 		fmt.Fprintf(buffer, "  data = data[\"%s\"]\n\n", s.Name)
 	}
 
-	// Group by dimension.
+	// Group by dimension. Factor list dimensions group the event under
+	// each of its factors so the rest of the function runs in a loop.
+	indent, loops := "  ", 0
 	for _, dimension := range s.Dimensions {
-		fmt.Fprintf(buffer, "  dimension = cursor.event:%s()\n", dimension)
-		fmt.Fprintf(buffer, "  if data.%s == nil then data.%s = {} end\n", dimension, dimension)
-		fmt.Fprintf(buffer, "  if data.%s[dimension] == nil then data.%s[dimension] = {} end\n", dimension, dimension)
-		fmt.Fprintf(buffer, "  data = data.%s[dimension]\n\n", dimension)
+		if s.multivalued(dimension) {
+			fmt.Fprintf(buffer, "%sfor _, dimension in ipairs(cursor.event:%s()) do\n", indent, dimension)
+			indent += "  "
+			fmt.Fprintf(buffer, "%slocal data = data\n", indent)
+			loops++
+		} else {
+			fmt.Fprintf(buffer, "%sdimension = cursor.event:%s()\n", indent, dimension)
+		}
+		fmt.Fprintf(buffer, "%sif data.%s == nil then data.%s = {} end\n", indent, dimension, dimension)
+		fmt.Fprintf(buffer, "%sif data.%s[dimension] == nil then data.%s[dimension] = {} end\n", indent, dimension, dimension)
+		fmt.Fprintf(buffer, "%sdata = data.%s[dimension]\n\n", indent, dimension)
 	}
 
 	// Select fields.
@@ -180,7 +190,13 @@ func (s *QuerySelection) CodegenAggregateFunction() (string, error) {
 		if err != nil {
 			return "", err
 		}
-		fmt.Fprintln(buffer, "  "+exp)
+		fmt.Fprintln(buffer, indent+exp)
+	}
+
+	// Close dimension loops.
+	for ; loops > 0; loops-- {
+		indent = indent[2:]
+		fmt.Fprintln(buffer, indent+"end")
 	}
 
 	// End function definition.
@@ -189,6 +205,15 @@ func (s *QuerySelection) CodegenAggregateFunction() (string, error) {
 	return buffer.String(), nil
 }
 
+// Determines if a dimension can have more than one value per event.
+func (s *QuerySelection) multivalued(dimension string) bool {
+	if s.query == nil || s.query.table == nil {
+		return false
+	}
+	property := s.query.table.propertyFile.GetPropertyByName(dimension)
+	return property != nil && property.DataType == FactorListDataType
+}
+
 // Generates Lua code for the selection merge.
 func (s *QuerySelection) CodegenMergeFunction() (string, error) {
 	buffer := new(bytes.Buffer)
@@ -298,7 +323,7 @@ func (s *QuerySelection) defactorize(data interface{}, index int) error {
 	if outer, ok := inner[dimension].(map[interface{}]interface{}); ok {
 		copy := map[interface{}]interface{}{}
 		for k, v := range outer {
-			if property.DataType == FactorDataType {
+			if property.DataType == FactorDataType || property.DataType == FactorListDataType {
 				if sequence, ok := normalize(k).(int64); ok {
 					stringValue, err := s.query.factors.Defactorize(s.query.table.Name, dimension, uint64(sequence))
 					if err != nil {
@@ -308,6 +333,12 @@ func (s *QuerySelection) defactorize(data interface{}, index int) error {
 				} else {
 					return fmt.Errorf("Invalid factor sequence: %v", k)
 				}
+			} else if property.DataType == TimestampDataType {
+				if seconds, ok := normalize(k).(int64); ok {
+					copy[time.Unix(seconds, 0).UTC().Format(time.RFC3339)] = v
+				} else {
+					return fmt.Errorf("Invalid timestamp: %v", k)
+				}
 			} else {
 				copy[k] = v
 			}
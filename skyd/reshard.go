@@ -0,0 +1,327 @@
+package skyd
+
+import (
+	"fmt"
+	"github.com/jmhodges/levigo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A reshardWriter writes keys into the table stores of a new servlet layout.
+// Stores are opened as keys for them are found.
+type reshardWriter struct {
+	path    string
+	count   int
+	dbs     map[string]*levigo.DB
+	batches map[string]*levigo.WriteBatch
+	wo      *levigo.WriteOptions
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Rehashes every object in a server's data directory into a new number of
+// servlets. The server must not be running. The new layout is built next to
+// the existing one and key counts are verified before the layouts are
+// swapped. The previous layout is kept in "data.old" until it is removed.
+// Servlets that store every table in a single database are split into one
+// store per table.
+func Reshard(path string, count int) error {
+	if count <= 0 {
+		return fmt.Errorf("skyd: Invalid servlet count: %v", count)
+	}
+
+	dataPath := filepath.Join(path, "data")
+	newPath := filepath.Join(path, "data.reshard")
+	oldPath := filepath.Join(path, "data.old")
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		return fmt.Errorf("skyd: Previous layout still exists: %v", oldPath)
+	}
+	if err := os.RemoveAll(newPath); err != nil {
+		return err
+	}
+
+	// Determine the current layout.
+	oldCount, err := readServletCount(dataPath)
+	if err != nil {
+		return err
+	}
+	if oldCount == 0 {
+		return fmt.Errorf("skyd: No servlets found: %v", dataPath)
+	}
+
+	// Copy keys into the new layout and then count them again.
+	keyCount, err := reshardKeys(dataPath, oldCount, newPath, count)
+	if err != nil {
+		os.RemoveAll(newPath)
+		return err
+	}
+	newKeyCount := 0
+	for i := 0; i < count; i++ {
+		n, err := countServletKeys(filepath.Join(newPath, fmt.Sprintf("%d", i)))
+		if err != nil {
+			os.RemoveAll(newPath)
+			return err
+		}
+		newKeyCount += n
+	}
+	if newKeyCount != keyCount {
+		os.RemoveAll(newPath)
+		return fmt.Errorf("skyd: Key count mismatch after resharding (objects may exist on more than one servlet): %v != %v", newKeyCount, keyCount)
+	}
+
+	// Record the new servlet count.
+	if err := writeServletCount(newPath, count); err != nil {
+		os.RemoveAll(newPath)
+		return err
+	}
+
+	// Swap the layouts. Put the previous layout back if the new one can't be
+	// moved into place so the data directory is never left missing.
+	if err := os.Rename(dataPath, oldPath); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, dataPath); err != nil {
+		if rollbackErr := os.Rename(oldPath, dataPath); rollbackErr != nil {
+			return fmt.Errorf("skyd: Unable to restore %v from %v: %v (%v)", dataPath, oldPath, rollbackErr, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Splits a data directory where each servlet stores every table in a single
+// database into one store per table. The number of servlets is unchanged.
+// The server must not be running and the previous layout is kept in
+// "data.old" until it is removed.
+func Migrate(path string) error {
+	dataPath := filepath.Join(path, "data")
+	count, err := readServletCount(dataPath)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("skyd: No servlets found: %v", dataPath)
+	}
+
+	shared := false
+	for i := 0; i < count; i++ {
+		shared = shared || isSharedServlet(filepath.Join(dataPath, fmt.Sprintf("%d", i)))
+	}
+	if !shared {
+		return fmt.Errorf("skyd: Data directory is already migrated: %v", dataPath)
+	}
+
+	return Reshard(path, count)
+}
+
+// Retrieves the paths of the databases in a servlet directory. Servlets
+// using the shared layout have a single database.
+func servletDatabasePaths(path string) ([]string, error) {
+	if isSharedServlet(path) {
+		return []string{path}, nil
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(path, "tables"))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, info := range infos {
+		if info.IsDir() {
+			paths = append(paths, filepath.Join(path, "tables", info.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// Copies every key from the existing servlets into their new servlets.
+// Returns the number of keys copied.
+func reshardKeys(dataPath string, oldCount int, newPath string, count int) (int, error) {
+	for i := 0; i < count; i++ {
+		if err := os.MkdirAll(filepath.Join(newPath, fmt.Sprintf("%d", i), "tables"), 0700); err != nil {
+			return 0, err
+		}
+	}
+
+	w := &reshardWriter{
+		path:    newPath,
+		count:   count,
+		dbs:     make(map[string]*levigo.DB),
+		batches: make(map[string]*levigo.WriteBatch),
+		wo:      levigo.NewWriteOptions(),
+	}
+	defer w.close()
+
+	// Rehash each key from each existing database.
+	keyCount := 0
+	for i := 0; i < oldCount; i++ {
+		paths, err := servletDatabasePaths(filepath.Join(dataPath, fmt.Sprintf("%d", i)))
+		if err != nil {
+			return keyCount, err
+		}
+		for _, path := range paths {
+			n, err := reshardDatabase(path, w)
+			keyCount += n
+			if err != nil {
+				return keyCount, err
+			}
+		}
+	}
+
+	// Flush remaining writes.
+	if err := w.flush(); err != nil {
+		return keyCount, err
+	}
+
+	return keyCount, nil
+}
+
+// Copies every key from a single database into the new servlets.
+func reshardDatabase(path string, w *reshardWriter) (int, error) {
+	opts := levigo.NewOptions()
+	defer opts.Close()
+	db, err := levigo.Open(path, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	ro := levigo.NewReadOptions()
+	defer ro.Close()
+	ro.SetFillCache(false)
+	iterator := db.NewIterator(ro)
+	defer iterator.Close()
+
+	keyCount := 0
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		if err := w.put(iterator.Key(), iterator.Value()); err != nil {
+			return keyCount, err
+		}
+		keyCount++
+
+		if keyCount%backupBatchSize == 0 {
+			if err := w.flush(); err != nil {
+				return keyCount, err
+			}
+		}
+	}
+	if err := iterator.GetError(); err != nil {
+		return keyCount, err
+	}
+
+	return keyCount, nil
+}
+
+// Counts the number of keys in every database of a servlet directory.
+func countServletKeys(path string) (int, error) {
+	paths, err := servletDatabasePaths(path)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, path := range paths {
+		n, err := countKeys(path)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// Counts the number of keys in a database.
+func countKeys(path string) (int, error) {
+	opts := levigo.NewOptions()
+	defer opts.Close()
+	db, err := levigo.Open(path, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	ro := levigo.NewReadOptions()
+	defer ro.Close()
+	iterator := db.NewIterator(ro)
+	defer iterator.Close()
+
+	count := 0
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		count++
+	}
+	if err := iterator.GetError(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Adds a key to the batch for its table's store on its new servlet. State and
+// chunk keys are hashed on the object key so that an object's chunks stay on
+// the same servlet.
+func (w *reshardWriter) put(key []byte, value []byte) error {
+	sz, err := objectKeyLength(key)
+	if err != nil {
+		return err
+	}
+	tableName, _, err := decodeObjectKey(key)
+	if err != nil {
+		return err
+	}
+	index := servletIndex(key[:sz], w.count)
+	path := filepath.Join(w.path, fmt.Sprintf("%d", index), "tables", tableName)
+
+	if w.dbs[path] == nil {
+		opts := levigo.NewOptions()
+		defer opts.Close()
+		opts.SetCreateIfMissing(true)
+		opts.SetErrorIfExists(true)
+		if err := os.MkdirAll(path, 0700); err != nil {
+			return err
+		}
+		db, err := levigo.Open(path, opts)
+		if err != nil {
+			return err
+		}
+		w.dbs[path], w.batches[path] = db, levigo.NewWriteBatch()
+	}
+	w.batches[path].Put(key, value)
+	return nil
+}
+
+// Writes every pending batch.
+func (w *reshardWriter) flush() error {
+	for path, db := range w.dbs {
+		if err := db.Write(w.wo, w.batches[path]); err != nil {
+			return err
+		}
+		w.batches[path].Clear()
+	}
+	return nil
+}
+
+// Closes every store.
+func (w *reshardWriter) close() {
+	for path, db := range w.dbs {
+		w.batches[path].Close()
+		db.Close()
+	}
+	w.wo.Close()
+}
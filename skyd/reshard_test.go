@@ -0,0 +1,131 @@
+package skyd
+
+import (
+	"fmt"
+	"github.com/jmhodges/levigo"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Ensure that data directories with more than ten servlets are discovered.
+func TestReadServletCount(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+
+	for i := 0; i < 12; i++ {
+		os.MkdirAll(fmt.Sprintf("%v/%v", path, i), 0700)
+	}
+	if count, err := readServletCount(path); count != 12 || err != nil {
+		t.Fatalf("Unexpected servlet count: %v (%v)", count, err)
+	}
+
+	// A recorded count takes precedence.
+	writeServletCount(path, 4)
+	if count, err := readServletCount(path); count != 4 || err != nil {
+		t.Fatalf("Unexpected servlet count: %v (%v)", count, err)
+	}
+}
+
+// Ensure that objects can be moved into a new servlet layout.
+func TestReshard(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+
+	// Write some objects.
+	server := NewServer(8586, path)
+	server.Silence()
+	server.ListenAndServe(nil)
+	setupTestTable("foo")
+	setupTestProperty("foo", "bar", false, "string")
+	for i := 0; i < 20; i++ {
+		setupTestData(t, "foo", [][]string{
+			[]string{fmt.Sprintf("o%d", i), "2012-01-01T00:00:00Z", fmt.Sprintf(`{"data":{"bar":"v%d"}}`, i)},
+		})
+	}
+	server.Shutdown()
+
+	if err := Reshard(path, 13); err != nil {
+		t.Fatalf("Unable to reshard: %v", err)
+	}
+
+	// Restart the server and check that every object is found.
+	server = NewServer(8586, path)
+	server.Silence()
+	server.ListenAndServe(nil)
+	defer server.Shutdown()
+	if len(server.servlets) != 13 {
+		t.Fatalf("Unexpected servlet count: %v", len(server.servlets))
+	}
+	for i := 0; i < 20; i++ {
+		resp, _ := sendTestHttpRequest("GET", fmt.Sprintf("http://localhost:8586/tables/foo/objects/o%d/events", i), "application/json", "")
+		assertResponse(t, resp, 200, fmt.Sprintf(`[{"data":{"bar":"v%d"},"timestamp":"2012-01-01T00:00:00Z"}]`, i)+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	}
+}
+
+// Ensure that servlets sharing a single database between tables can be
+// migrated to one store per table.
+func TestMigrate(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+
+	// Write some objects.
+	server := NewServer(8586, path)
+	server.Silence()
+	server.ListenAndServe(nil)
+	setupTestTable("foo")
+	setupTestProperty("foo", "bar", false, "string")
+	for i := 0; i < 20; i++ {
+		setupTestData(t, "foo", [][]string{
+			[]string{fmt.Sprintf("o%d", i), "2012-01-01T00:00:00Z", fmt.Sprintf(`{"data":{"bar":"v%d"}}`, i)},
+		})
+	}
+	count := len(server.servlets)
+	server.Shutdown()
+
+	// Move each servlet's keys into a single shared database.
+	opts := levigo.NewOptions()
+	defer opts.Close()
+	opts.SetCreateIfMissing(true)
+	ro, wo := levigo.NewReadOptions(), levigo.NewWriteOptions()
+	defer ro.Close()
+	defer wo.Close()
+	for i := 0; i < count; i++ {
+		servletPath := fmt.Sprintf("%v/data/%v", path, i)
+		dst, _ := levigo.Open(servletPath+".shared", opts)
+		src, err := levigo.Open(servletPath+"/tables/foo", opts)
+		if err != nil {
+			t.Fatalf("Unable to open table store: %v", err)
+		}
+		iterator := src.NewIterator(ro)
+		for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+			dst.Put(wo, iterator.Key(), iterator.Value())
+		}
+		iterator.Close()
+		src.Close()
+		dst.Close()
+		os.RemoveAll(servletPath)
+		os.Rename(servletPath+".shared", servletPath)
+	}
+
+	// The server can't open the shared layout until it is migrated.
+	if err := NewServer(8586, path).open(); err == nil {
+		t.Fatalf("Expected shared layout to be rejected.")
+	}
+	if err := Migrate(path); err != nil {
+		t.Fatalf("Unable to migrate: %v", err)
+	}
+	if err := Migrate(path); err == nil {
+		t.Fatalf("Expected migrated layout to be rejected.")
+	}
+
+	// Restart the server and check that every object is found.
+	server = NewServer(8586, path)
+	server.Silence()
+	server.ListenAndServe(nil)
+	defer server.Shutdown()
+	for i := 0; i < 20; i++ {
+		resp, _ := sendTestHttpRequest("GET", fmt.Sprintf("http://localhost:8586/tables/foo/objects/o%d/events", i), "application/json", "")
+		assertResponse(t, resp, 200, fmt.Sprintf(`[{"data":{"bar":"v%d"},"timestamp":"2012-01-01T00:00:00Z"}]`, i)+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	}
+}
@@ -1,12 +1,11 @@
 package skyd
 
 import (
-	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/jmhodges/levigo"
 	"hash/fnv"
 	"io"
 	"io/ioutil"
@@ -17,9 +16,22 @@ import (
 	"os"
 	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// How often each servlet removes events that are older than their table's
+// retention period.
+const retentionInterval = 1 * time.Hour
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -36,7 +48,43 @@ type Server struct {
 	servlets        []*Servlet
 	tables          map[string]*Table
 	factors         *Factors
+	options         *DatabaseOptions
+	engine          StorageEngine
+	backupPath      string
 	shutdownChannel chan bool
+	retentionStop   chan bool
+	retentionGroup  sync.WaitGroup
+	migrationStop   chan bool
+	migrationGroup  sync.WaitGroup
+	migrationMutex  sync.Mutex
+	migrations      map[string]map[int64]*PropertyMigrationStatus
+	purges          map[string]map[int64]*PropertyMigrationStatus
+}
+
+// A responseWriter records whether a handler has started writing its own
+// response so that the return value isn't written after it.
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+// Writes the status header.
+func (w *responseWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Writes to the response body.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flushes buffered data to the client if the underlying writer supports it.
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
 }
 
 //------------------------------------------------------------------------------
@@ -73,6 +121,7 @@ func NewServer(port uint, path string) *Server {
 		logger:     log.New(os.Stdout, "", log.LstdFlags),
 		path:       path,
 		tables:     make(map[string]*Table),
+		options:    NewDatabaseOptions(),
 	}
 
 	s.router.HandleFunc("/debug/pprof", pprof.Index)
@@ -84,6 +133,8 @@ func NewServer(port uint, path string) *Server {
 	s.addTableHandlers()
 	s.addPropertyHandlers()
 	s.addEventHandlers()
+	s.addObjectHandlers()
+	s.addAdminHandlers()
 	s.addQueryHandlers()
 
 	return s
@@ -120,6 +171,43 @@ func (s *Server) FactorsPath() string {
 	return fmt.Sprintf("%v/factors", s.path)
 }
 
+// The LevelDB options used for the servlets and the factors database.
+func (s *Server) DatabaseOptions() *DatabaseOptions {
+	return s.options
+}
+
+// Sets the LevelDB options used for the servlets and the factors database.
+// The options take effect the next time the server is opened.
+func (s *Server) SetDatabaseOptions(options *DatabaseOptions) {
+	s.options = options
+}
+
+// The directory that backups are written to. Backups are disabled if it
+// isn't set.
+func (s *Server) BackupPath() string {
+	return s.backupPath
+}
+
+// Sets the directory that backups are written to.
+func (s *Server) SetBackupPath(path string) {
+	s.backupPath = path
+}
+
+// The storage engine used for the servlets and the factors database. LevelDB
+// is used if no engine has been set.
+func (s *Server) StorageEngine() StorageEngine {
+	if s.engine == nil {
+		return NewLevelDBEngine(s.options)
+	}
+	return s.engine
+}
+
+// Sets the storage engine used for the servlets and the factors database.
+// The engine takes effect the next time the server is opened.
+func (s *Server) SetStorageEngine(engine StorageEngine) {
+	s.engine = engine
+}
+
 //------------------------------------------------------------------------------
 //
 // Methods
@@ -190,32 +278,37 @@ func (s *Server) open() error {
 		return fmt.Errorf("skyd.Server: Unable to create server folders: %v", err)
 	}
 
+	// Allocate the block cache and bloom filter shared by all databases.
+	s.options.open()
+
 	// Open factors database.
+	engine := s.StorageEngine()
 	s.factors = NewFactors(s.FactorsPath())
+	s.factors.engine = engine
 	err = s.factors.Open()
 	if err != nil {
 		s.close()
 		return err
 	}
 
-	// Create servlets from child directories with numeric names.
-	infos, err := ioutil.ReadDir(s.DataPath())
+	// Create servlets based on the recorded servlet count. If there isn't one
+	// then build them based on the number of logical CPUs available.
+	count, err := readServletCount(s.DataPath())
 	if err != nil {
+		s.close()
 		return err
 	}
-	for _, info := range infos {
-		match, _ := regexp.MatchString("^\\d$", info.Name())
-		if info.IsDir() && match {
-			s.servlets = append(s.servlets, NewServlet(fmt.Sprintf("%s/%s", s.DataPath(), info.Name()), s.factors))
-		}
+	if count == 0 {
+		count = runtime.NumCPU()
 	}
-
-	// If none exist then build them based on the number of logical CPUs available.
-	if len(s.servlets) == 0 {
-		cpuCount := runtime.NumCPU()
-		for i := 0; i < cpuCount; i++ {
-			s.servlets = append(s.servlets, NewServlet(fmt.Sprintf("%s/%v", s.DataPath(), i), s.factors))
-		}
+	if err = writeServletCount(s.DataPath(), count); err != nil {
+		s.close()
+		return err
+	}
+	for i := 0; i < count; i++ {
+		servlet := NewServlet(fmt.Sprintf("%s/%v", s.DataPath(), i), s.factors)
+		servlet.engine = engine
+		s.servlets = append(s.servlets, servlet)
 	}
 
 	// Open servlets.
@@ -227,11 +320,38 @@ func (s *Server) open() error {
 		}
 	}
 
+	// Start a retention job for each servlet.
+	s.retentionStop = make(chan bool)
+	for index, servlet := range s.servlets {
+		s.retentionGroup.Add(1)
+		go s.retentionLoop(index, servlet, s.retentionStop)
+	}
+
+	// Resume any property migrations and purges that were interrupted.
+	s.migrationStop = make(chan bool)
+	s.migrations = make(map[string]map[int64]*PropertyMigrationStatus)
+	s.purges = make(map[string]map[int64]*PropertyMigrationStatus)
+	s.resumePropertyJobs()
+
 	return nil
 }
 
 // Closes the data directory and servlets.
 func (s *Server) close() {
+	// Stop property migrations and purges. They're resumed when the server is reopened.
+	if s.migrationStop != nil {
+		close(s.migrationStop)
+		s.migrationGroup.Wait()
+		s.migrationStop = nil
+	}
+
+	// Stop retention jobs before the servlets are closed.
+	if s.retentionStop != nil {
+		close(s.retentionStop)
+		s.retentionGroup.Wait()
+		s.retentionStop = nil
+	}
+
 	// Close servlets.
 	if s.servlets != nil {
 		for _, servlet := range s.servlets {
@@ -245,6 +365,9 @@ func (s *Server) close() {
 		s.factors.Close()
 		s.factors = nil
 	}
+
+	// Release the shared cache once nothing is using it.
+	s.options.close()
 }
 
 // Creates the appropriate directory structure if one does not exist.
@@ -285,16 +408,42 @@ func (s *Server) Silence() {
 
 // Parses incoming JSON objects and converts outgoing responses to JSON.
 func (s *Server) ApiHandleFunc(route string, handlerFunction func(http.ResponseWriter, *http.Request, map[string]interface{}) (interface{}, error)) *mux.Route {
-	wrappedFunction := func(w http.ResponseWriter, req *http.Request) {
+	return s.apiHandleFunc(route, true, handlerFunction)
+}
+
+// Converts outgoing responses to JSON but leaves the request body unread so
+// that the handler can stream it. The handler receives empty parameters.
+func (s *Server) StreamingApiHandleFunc(route string, handlerFunction func(http.ResponseWriter, *http.Request, map[string]interface{}) (interface{}, error)) *mux.Route {
+	return s.apiHandleFunc(route, false, handlerFunction)
+}
+
+// Wraps a handler function with parameter decoding, response encoding and
+// access logging.
+func (s *Server) apiHandleFunc(route string, decode bool, handlerFunction func(http.ResponseWriter, *http.Request, map[string]interface{}) (interface{}, error)) *mux.Route {
+	wrappedFunction := func(rw http.ResponseWriter, req *http.Request) {
 		// warn("%s \"%s %s %s\"", req.RemoteAddr, req.Method, req.RequestURI, req.Proto)
 		t0 := time.Now()
+		w := &responseWriter{ResponseWriter: rw}
 
 		var ret interface{}
-		params, err := s.decodeParams(w, req)
+		var err error
+		params := make(map[string]interface{})
+		if decode {
+			params, err = s.decodeParams(w, req)
+		}
 		if err == nil {
 			ret, err = handlerFunction(w, req, params)
 		}
 
+		// If the handler streamed its own response then only log it.
+		if w.written {
+			s.logger.Printf("%s \"%s %s %s\" %d %0.3f", req.RemoteAddr, req.Method, req.RequestURI, req.Proto, http.StatusOK, time.Since(t0).Seconds())
+			if err != nil {
+				s.logger.Printf("ERROR %v", err)
+			}
+			return
+		}
+
 		// If we're returning plain text then just dump out what's returned.
 		if _, ok := err.(*TextPlainContentTypeError); ok {
 			w.Header().Set("Content-Type", "text/plain")
@@ -345,6 +494,7 @@ func (s *Server) decodeParams(w http.ResponseWriter, req *http.Request) (map[str
 	// Parses body parameters.
 	params := make(map[string]interface{})
 	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
 	err := decoder.Decode(&params)
 	if err != nil && err != io.EOF {
 		return nil, errors.New("Malformed json request.")
@@ -382,14 +532,200 @@ func (s *Server) GetObjectServletIndex(t *Table, objectId string) (uint32, error
 		return 0, err
 	}
 
-	// Calculate the even bits of the FNV1a hash.
+	return servletIndex(encodedObjectId, len(s.servlets)), nil
+}
+
+// Calculates the servlet index for an encoded object identifier using the
+// even bits of its FNV1a hash.
+func servletIndex(encodedObjectId []byte, count int) uint32 {
 	h := fnv.New64a()
 	h.Reset()
 	h.Write(encodedObjectId)
 	hashcode := h.Sum64()
-	index := CondenseUint64Even(hashcode) % uint32(len(s.servlets))
+	return CondenseUint64Even(hashcode) % uint32(count)
+}
+
+// Reads the number of servlets recorded in a data directory. Data
+// directories created before the count was recorded use the number of
+// servlet directories instead. Returns zero for a new data directory.
+func readServletCount(dataPath string) (int, error) {
+	// Use the recorded count if there is one.
+	b, err := ioutil.ReadFile(fmt.Sprintf("%v/servlets", dataPath))
+	if err == nil {
+		count, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("skyd: Invalid servlet count: %s", b)
+		}
+		return count, nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	// Otherwise count the child directories with numeric names.
+	infos, err := ioutil.ReadDir(dataPath)
+	if err != nil {
+		return 0, err
+	}
+	indices := make(map[int]bool)
+	for _, info := range infos {
+		match, _ := regexp.MatchString("^\\d+$", info.Name())
+		if info.IsDir() && match {
+			index, _ := strconv.Atoi(info.Name())
+			indices[index] = true
+		}
+	}
+	for i := 0; i < len(indices); i++ {
+		if !indices[i] {
+			return 0, fmt.Errorf("skyd: Missing servlet directory: %v/%v", dataPath, i)
+		}
+	}
+	return len(indices), nil
+}
 
-	return index, nil
+// Records the number of servlets in a data directory.
+func writeServletCount(dataPath string, count int) error {
+	return ioutil.WriteFile(fmt.Sprintf("%v/servlets", dataPath), []byte(fmt.Sprintf("%d\n", count)), 0600)
+}
+
+//--------------------------------------
+// Object Management
+//--------------------------------------
+
+// Merges the events of a source object into a target object and then deletes
+// the source object. Both servlets are locked for the duration of the merge
+// so the objects can live on different servlets.
+func (s *Server) MergeObjects(tableName string, objectId string, sourceObjectId string) error {
+	if objectId == sourceObjectId {
+		return errors.New("Cannot merge an object into itself.")
+	}
+	table, err := s.OpenTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	// Determine the servlet for each object.
+	targetIndex, err := s.GetObjectServletIndex(table, objectId)
+	if err != nil {
+		return err
+	}
+	sourceIndex, err := s.GetObjectServletIndex(table, sourceObjectId)
+	if err != nil {
+		return err
+	}
+	target, source := s.servlets[targetIndex], s.servlets[sourceIndex]
+
+	// Commit queued writes for both objects so that they're part of the merge
+	// and can't recreate the source afterward.
+	if err = target.FlushWrites(); err != nil {
+		return err
+	}
+	if sourceIndex != targetIndex {
+		if err = source.FlushWrites(); err != nil {
+			return err
+		}
+	}
+
+	// Lock servlets in index order so that concurrent merges can't deadlock.
+	if targetIndex == sourceIndex {
+		target.Lock()
+		defer target.Unlock()
+	} else if targetIndex < sourceIndex {
+		target.Lock()
+		defer target.Unlock()
+		source.Lock()
+		defer source.Unlock()
+	} else {
+		source.Lock()
+		defer source.Unlock()
+		target.Lock()
+		defer target.Unlock()
+	}
+
+	// Interleave both event streams.
+	targetEvents, _, err := target.GetEvents(table, objectId)
+	if err != nil {
+		return err
+	}
+	sourceEvents, _, err := source.GetEvents(table, sourceObjectId)
+	if err != nil {
+		return err
+	}
+	events, state := MergeEventLists(targetEvents, sourceEvents)
+
+	// Write the target before removing the source so that a failure never
+	// loses events.
+	if err = target.SetEvents(table, objectId, events, state); err != nil {
+		return err
+	}
+	_, err = source.deleteEvents(table, sourceObjectId)
+	return err
+}
+
+// Retrieves a page of object summaries for a table. Servlets are scanned in
+// order and the continuation token returned marks where the next page begins.
+// A blank token is returned after the last page.
+func (s *Server) ScanObjects(table *Table, token string, prefix string, limit int) ([]*ObjectInfo, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("Invalid limit: %v", limit)
+	}
+
+	// Determine where to start.
+	index, after := 0, ""
+	if token != "" {
+		var err error
+		if index, after, err = decodeContinuationToken(token); err != nil || index >= len(s.servlets) {
+			return nil, "", errors.New("Invalid continuation token.")
+		}
+	}
+
+	// Retrieve one extra object to determine if there is another page.
+	infos := make([]*ObjectInfo, 0)
+	indices := make([]int, 0)
+	for ; index < len(s.servlets) && len(infos) <= limit; index++ {
+		ret, err := s.servlets[index].ScanObjects(table, after, prefix, limit+1-len(infos))
+		if err != nil {
+			return nil, "", err
+		}
+		for _, info := range ret {
+			infos = append(infos, info)
+			indices = append(indices, index)
+		}
+		after = ""
+	}
+	if len(infos) <= limit {
+		return infos, "", nil
+	}
+
+	// Continue after the last object on this page.
+	last := infos[limit-1]
+	return infos[:limit], encodeContinuationToken(indices[limit-1], last.Id), nil
+}
+
+// Generates an opaque token from a servlet index and object identifier.
+func encodeContinuationToken(index int, objectId string) string {
+	b, _ := json.Marshal([]interface{}{index, objectId})
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// Extracts the servlet index and object identifier from a token.
+func decodeContinuationToken(token string) (int, string, error) {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, "", err
+	}
+	var raw []interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return 0, "", err
+	}
+	if len(raw) != 2 {
+		return 0, "", errors.New("Invalid continuation token.")
+	}
+	index, ok := raw[0].(float64)
+	objectId, ok2 := raw[1].(string)
+	if !ok || !ok2 || index < 0 {
+		return 0, "", errors.New("Invalid continuation token.")
+	}
+	return int(index), objectId, nil
 }
 
 //--------------------------------------
@@ -451,42 +787,76 @@ func (s *Server) DeleteTable(name string) error {
 		return fmt.Errorf("Table does not exist: %s", name)
 	}
 
-	// Determine table prefix.
-	prefix, err := TablePrefix(table.Name)
-	if err != nil {
+	// Remove the table from the lookup along with its schema. The schema is
+	// removed first so that writes still in flight can't recreate a store.
+	delete(s.tables, name)
+	s.migrationMutex.Lock()
+	delete(s.migrations, name)
+	delete(s.purges, name)
+	s.migrationMutex.Unlock()
+	if err := table.Delete(); err != nil {
 		return err
 	}
 
-	// Delete data from each servlet.
+	// Drop the table's store on each servlet. Only one servlet is paused at
+	// a time.
 	for _, servlet := range s.servlets {
-		servlet.Lock()
-		defer servlet.Unlock()
-
-		// Delete the data from disk.
-		ro := levigo.NewReadOptions()
-		defer ro.Close()
-		wo := levigo.NewWriteOptions()
-		defer wo.Close()
-		iterator := servlet.db.NewIterator(ro)
-		defer iterator.Close()
-
-		iterator.Seek(prefix)
-		for iterator = iterator; iterator.Valid(); iterator.Next() {
-			key := iterator.Key()
-			if bytes.HasPrefix(key, prefix) {
-				err := servlet.db.Delete(wo, key)
-				if err != nil {
-					return err
-				}
-			} else {
-				break
-			}
+		if err := servlet.DropTable(name); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Remove the table from the lookup and remove it's schema.
-	delete(s.tables, name)
-	return table.Delete()
+//--------------------------------------
+// Retention
+//--------------------------------------
+
+// Periodically trims expired events from a servlet until stopped.
+func (s *Server) retentionLoop(index int, servlet *Servlet, stop chan bool) {
+	defer s.retentionGroup.Done()
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.trimServlet(index, servlet)
+		}
+	}
+}
+
+// Removes events older than each table's retention period from a servlet.
+// The table settings are read from disk so that the job does not need to
+// open tables.
+func (s *Server) trimServlet(index int, servlet *Servlet) {
+	tables, err := s.GetAllTables()
+	if err != nil {
+		s.logger.Printf("ERROR Retention: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, table := range tables {
+		if err := table.loadSettings(); err != nil {
+			s.logger.Printf("ERROR Retention [%s]: %v", table.Name, err)
+			continue
+		}
+		cutoff := table.RetentionCutoff(now)
+		if cutoff.IsZero() {
+			continue
+		}
+
+		eventCount, objectCount, err := servlet.Trim(table, cutoff)
+		if err != nil {
+			s.logger.Printf("ERROR Retention [%s/%d]: %v", table.Name, index, err)
+		}
+		if eventCount > 0 {
+			s.logger.Printf("Retention [%s/%d]: Purged %d events and %d objects before %s", table.Name, index, eventCount, objectCount, cutoff.UTC().Format(time.RFC3339))
+		}
+	}
 }
 
 //--------------------------------------
@@ -515,29 +885,45 @@ func (s *Server) RunQuery(table *Table, query *Query) (interface{}, error) {
 	defer engine.Destroy()
 	//fmt.Println(engine.FullAnnotatedSource())
 
-	// Initialize one execution engine for each servlet.
+	// Initialize one execution engine for each servlet that has data for
+	// the table. Each store is held until its engine's iterator is closed so
+	// that a dropped table isn't closed while it's being read.
+	stores := make([]*tableStore, 0)
+	defer func() {
+		for _, e := range engines {
+			e.Destroy()
+		}
+		for _, db := range stores {
+			db.release()
+		}
+	}()
 	for _, servlet := range s.servlets {
+		db, err := servlet.tableDB(table.Name)
+		if err == errTableStoreNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		stores = append(stores, db)
+
 		// Create an engine for each servlet.
 		e, err := NewExecutionEngine(table, source)
 		if err != nil {
 			return nil, err
 		}
+		engines = append(engines, e)
 
 		// Initialize iterator.
-		ro := levigo.NewReadOptions()
-		iterator := servlet.db.NewIterator(ro)
-		err = e.SetIterator(iterator)
+		err = e.SetIterator(db.NewIterator())
 		if err != nil {
 			return nil, err
 		}
-
-		engines = append(engines, e)
 	}
 
 	// Execute servlets asynchronously and retrieve responses outside
 	// of the server context.
-	for index, _ := range s.servlets {
-		e := engines[index]
+	for _, e := range engines {
+		e := e
 		go func() {
 			if result, err := e.Aggregate(); err != nil {
 				rchannel <- err
@@ -551,16 +937,17 @@ func (s *Server) RunQuery(table *Table, query *Query) (interface{}, error) {
 	var servletError error
 	var result interface{}
 	result = make(map[interface{}]interface{})
-	for i := 0; i < len(s.servlets); i++ {
+	for i := 0; i < len(engines); i++ {
 		ret := <-rchannel
 		if err, ok := ret.(error); ok {
 			fmt.Printf("skyd.Server: Aggregate error: %v", err)
 			servletError = err
 		} else {
-			// Defactorize aggregate results.
-			err = query.Defactorize(ret)
-			if err != nil {
-				return nil, err
+			// Defactorize aggregate results. Every engine is still waited on
+			// so that none is destroyed while it's running.
+			if err = query.Defactorize(ret); err != nil {
+				servletError = err
+				continue
 			}
 
 			// Merge results.
@@ -575,10 +962,9 @@ func (s *Server) RunQuery(table *Table, query *Query) (interface{}, error) {
 	}
 	err = servletError
 
-	// Clean up engines.
-	// TODO: Defer clean up earlier on in case of failure.
-	for _, e := range engines {
-		e.Destroy()
+	// Don't return results generated against a schema that has since changed.
+	if err == nil && engine.SchemaChanged() {
+		err = errors.New("skyd.Server: Table schema changed while the query was running.")
 	}
 
 	return result, err
@@ -0,0 +1,72 @@
+package skyd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func (s *Server) addAdminHandlers() {
+	s.ApiHandleFunc("/admin/backup", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.backupHandler(w, req, params)
+	}).Methods("POST")
+	s.ApiHandleFunc("/admin/servlets", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.getServletsHandler(w, req, params)
+	}).Methods("GET")
+	s.ApiHandleFunc("/admin/servlets/{index}/compact", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.compactServletHandler(w, req, params)
+	}).Methods("POST")
+}
+
+// POST /admin/backup
+func (s *Server) backupHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	if s.BackupPath() == "" {
+		return nil, errors.New("Backups are not configured.")
+	}
+
+	// Backups are always written to a directory within the backup path.
+	name, ok := params["name"].(string)
+	if !ok || name == "" {
+		return nil, errors.New("Backup name required.")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("Invalid backup name: %v", name)
+	}
+	return s.Backup(filepath.Join(s.BackupPath(), name))
+}
+
+// GET /admin/servlets
+func (s *Server) getServletsHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	tables, err := s.GetAllTables()
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects are only counted on request since it scans every store.
+	count := req.URL.Query().Get("count") == "true"
+
+	ret := make([]*ServletStats, 0)
+	for index, servlet := range s.servlets {
+		stats, err := servlet.Stats(tables, count)
+		if err != nil {
+			return nil, err
+		}
+		stats.Index = index
+		ret = append(ret, stats)
+	}
+	return ret, nil
+}
+
+// POST /admin/servlets/:index/compact
+func (s *Server) compactServletHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil || index < 0 || index >= len(s.servlets) {
+		return nil, fmt.Errorf("Servlet not found: %v", vars["index"])
+	}
+	return nil, s.servlets[index].Compact("")
+}
@@ -0,0 +1,104 @@
+package skyd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Ensure that we can back up a running server and restore it.
+func TestServerBackupRestore(t *testing.T) {
+	backupPath, _ := ioutil.TempDir("", "")
+	os.RemoveAll(backupPath)
+	defer os.RemoveAll(backupPath)
+	restorePath, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(restorePath)
+
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "factor")
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T00:00:00Z", `{"data":{"bar":"myValue"}}`},
+		})
+
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/admin/backup", "application/json", `{"name":"nightly"}`)
+		assertResponse(t, resp, 500, `{"message":"Backups are not configured."}`+"\n", "POST /admin/backup failed.")
+
+		s.SetBackupPath(backupPath)
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/admin/backup", "application/json", `{"name":"nightly"}`)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("POST /admin/backup failed: %v", resp.StatusCode)
+		}
+
+		// Backups can't be written outside of the backup path.
+		for _, name := range []string{"..", "../nightly", "/tmp/nightly"} {
+			resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/admin/backup", "application/json", fmt.Sprintf(`{"name":"%s"}`, name))
+			assertResponse(t, resp, 500, fmt.Sprintf(`{"message":"Invalid backup name: %s"}`, name)+"\n", "POST /admin/backup failed.")
+		}
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/admin/backup", "application/json", fmt.Sprintf(`{"path":"%s"}`, backupPath))
+		assertResponse(t, resp, 500, `{"message":"Backup name required."}`+"\n", "POST /admin/backup failed.")
+	})
+
+	// Validate and restore the backup.
+	if _, err := ValidateBackup(backupPath + "/nightly"); err != nil {
+		t.Fatalf("Invalid backup: %v", err)
+	}
+	if err := Restore(backupPath+"/nightly", restorePath); err != nil {
+		t.Fatalf("Unable to restore: %v", err)
+	}
+	if err := Restore(backupPath+"/nightly", restorePath); err == nil {
+		t.Fatalf("Expected restore into a non-empty directory to fail")
+	}
+
+	// Start a server on the restored data and check the event.
+	server := NewServer(8586, restorePath)
+	server.Silence()
+	server.ListenAndServe(nil)
+	defer server.Shutdown()
+	resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz", "application/json", "")
+	assertResponse(t, resp, 200, `{"eventCount":1,"firstTimestamp":"2012-01-01T00:00:00Z","id":"xyz","lastTimestamp":"2012-01-01T00:00:00Z","state":{"data":{"bar":"myValue"},"timestamp":"2012-01-01T00:00:00Z"}}`+"\n", "GET /tables/:name/objects/:objectId failed.")
+}
+
+// Ensure that we can retrieve servlet statistics and compact data.
+func TestServerServletStats(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestData(t, "foo", [][]string{
+			[]string{"a", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+			[]string{"b", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+			[]string{"c", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+		})
+
+		resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/admin/servlets", "application/json", "")
+		var stats []*ServletStats
+		json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		for _, servlet := range stats {
+			if servlet.Tables["foo"].ObjectCount != 0 {
+				t.Fatalf("Unexpected object count: %v", servlet.Tables["foo"].ObjectCount)
+			}
+		}
+
+		// Objects are counted on request.
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/admin/servlets?count=true", "application/json", "")
+		stats = nil
+		json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		count := 0
+		for _, servlet := range stats {
+			count += servlet.Tables["foo"].ObjectCount
+		}
+		if len(stats) != len(s.servlets) || count != 3 {
+			t.Fatalf("Unexpected stats: %v servlets, %v objects", len(stats), count)
+		}
+
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/admin/servlets/0/compact", "application/json", "")
+		assertResponse(t, resp, 200, "", "POST /admin/servlets/:index/compact failed.")
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/compact", "application/json", "")
+		assertResponse(t, resp, 200, "", "POST /tables/:name/compact failed.")
+	})
+}
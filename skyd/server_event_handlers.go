@@ -1,12 +1,60 @@
 package skyd
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 )
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The number of events buffered for a servlet before they are written.
+const bulkEventBatchSize = 1000
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A bulkEvent is a single deserialized line from a bulk event stream.
+type bulkEvent struct {
+	line     int
+	objectId string
+	event    *Event
+}
+
+// A bulkEventError associates an error with a line in a bulk event stream.
+type bulkEventError struct {
+	line int
+	err  error
+}
+
+// A slice of bulk event errors sortable by line number.
+type bulkEventErrorList []*bulkEventError
+
+func (s bulkEventErrorList) Len() int           { return len(s) }
+func (s bulkEventErrorList) Less(i, j int) bool { return s[i].line < s[j].line }
+func (s bulkEventErrorList) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+//------------------------------------------------------------------------------
+//
+// Handlers
+//
+//------------------------------------------------------------------------------
+
 func (s *Server) addEventHandlers() {
 	s.ApiHandleFunc("/tables/{name}/objects/{objectId}/events", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
 		return s.getEventsHandler(w, req, params)
@@ -27,6 +75,10 @@ func (s *Server) addEventHandlers() {
 	s.ApiHandleFunc("/tables/{name}/objects/{objectId}/events/{timestamp}", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
 		return s.deleteEventHandler(w, req, params)
 	}).Methods("DELETE")
+
+	s.StreamingApiHandleFunc("/tables/{name}/events", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.bulkEventsHandler(w, req, params)
+	}).Methods("POST")
 }
 
 // GET /tables/:name/objects/:objectId/events
@@ -37,20 +89,45 @@ func (s *Server) getEventsHandler(w http.ResponseWriter, req *http.Request, para
 		return nil, err
 	}
 
+	// Parse range and paging options.
+	query := req.URL.Query()
+	start, end, err := parseTimeRange(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := 0
+	if query.Get("limit") != "" {
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil || limit <= 0 {
+			return nil, fmt.Errorf("Invalid limit: %v", query.Get("limit"))
+		}
+	}
+	order := query.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		return nil, fmt.Errorf("Invalid order: %v", order)
+	}
+
 	// Retrieve raw events.
-	events, _, err := servlet.GetEvents(table, vars["objectId"])
+	events, err := servlet.GetEventRange(table, vars["objectId"], start, end)
 	if err != nil {
 		return nil, err
 	}
+	if order == "desc" {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
 
 	// Denormalize events.
 	output := make([]map[string]interface{}, 0)
 	for _, event := range events {
-		e, err := table.SerializeEvent(event)
+		err = table.DefactorizeEvent(event, s.factors)
 		if err != nil {
 			return nil, err
 		}
-		err = table.DefactorizeEvent(event, s.factors)
+		e, err := table.SerializeEvent(event)
 		if err != nil {
 			return nil, err
 		}
@@ -68,7 +145,43 @@ func (s *Server) deleteEventsHandler(w http.ResponseWriter, req *http.Request, p
 		return nil, err
 	}
 
-	return nil, servlet.DeleteEvents(table, vars["objectId"])
+	// Delete everything if no range is specified.
+	start, end, err := parseTimeRange(req)
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	if start.IsZero() && end.IsZero() {
+		count, err = servlet.DeleteEvents(table, vars["objectId"])
+	} else {
+		count, err = servlet.DeleteEventRange(table, vars["objectId"], start, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"count": count}, nil
+}
+
+// Parses the optional "start" and "end" query parameters of a request. The
+// start must come before the end when both are given.
+func parseTimeRange(req *http.Request) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	query := req.URL.Query()
+	if query.Get("start") != "" {
+		if start, err = time.Parse(time.RFC3339, query.Get("start")); err != nil {
+			return start, end, fmt.Errorf("Unable to parse timestamp: %v", query.Get("start"))
+		}
+	}
+	if query.Get("end") != "" {
+		if end, err = time.Parse(time.RFC3339, query.Get("end")); err != nil {
+			return start, end, fmt.Errorf("Unable to parse timestamp: %v", query.Get("end"))
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return start, end, fmt.Errorf("Invalid time range: %v to %v", query.Get("start"), query.Get("end"))
+	}
+	return start, end, nil
 }
 
 // GET /tables/:name/objects/:objectId/events/:timestamp
@@ -96,15 +209,11 @@ func (s *Server) getEventHandler(w http.ResponseWriter, req *http.Request, param
 	}
 
 	// Convert an event to a serializable object.
-	e, err := table.SerializeEvent(event)
-	if err != nil {
-		return nil, err
-	}
 	err = table.DefactorizeEvent(event, s.factors)
 	if err != nil {
 		return nil, err
 	}
-	return e, nil
+	return table.SerializeEvent(event)
 }
 
 // PUT /tables/:name/objects/:objectId/events/:timestamp
@@ -125,7 +234,7 @@ func (s *Server) replaceEventHandler(w http.ResponseWriter, req *http.Request, p
 		return nil, err
 	}
 
-	return nil, servlet.PutEvent(table, vars["objectId"], event, true)
+	return nil, servlet.QueueEvent(table, vars["objectId"], event, true, isSyncRequest(req))
 }
 
 // PATCH /tables/:name/objects/:objectId/events/:timestamp
@@ -145,7 +254,13 @@ func (s *Server) updateEventHandler(w http.ResponseWriter, req *http.Request, pa
 	if err != nil {
 		return nil, err
 	}
-	return nil, servlet.PutEvent(table, vars["objectId"], event, false)
+	return nil, servlet.QueueEvent(table, vars["objectId"], event, false, isSyncRequest(req))
+}
+
+// Determines if a write request should wait for its event to be durably
+// written. Passing "sync=false" returns as soon as the event is queued.
+func isSyncRequest(req *http.Request) bool {
+	return req.URL.Query().Get("sync") != "false"
 }
 
 // DELETE /tables/:name/objects/:objectId/events/:timestamp
@@ -163,3 +278,129 @@ func (s *Server) deleteEventHandler(w http.ResponseWriter, req *http.Request, pa
 
 	return nil, servlet.DeleteEvent(table, vars["objectId"], timestamp)
 }
+
+// POST /tables/:name/events
+func (s *Server) bulkEventsHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine whether events are replaced or merged.
+	var replace bool
+	switch mode := req.URL.Query().Get("mode"); mode {
+	case "", "replace":
+		replace = true
+	case "merge":
+		replace = false
+	default:
+		return nil, fmt.Errorf("Invalid bulk event mode: %v", mode)
+	}
+
+	// Read each line of the stream and buffer it by servlet.
+	count := 0
+	errs := make([]*bulkEventError, 0)
+	flush := func(index uint32, items []*bulkEvent) {
+		e := s.putBulkEvents(table, s.servlets[index], items, replace)
+		count += len(items) - len(e)
+		errs = append(errs, e...)
+	}
+
+	pending := make(map[uint32][]*bulkEvent)
+	reader := bufio.NewReader(req.Body)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if item, index, e := s.deserializeBulkEvent(table, data); e != nil {
+				errs = append(errs, &bulkEventError{line, e})
+			} else {
+				item.line = line
+				pending[index] = append(pending[index], item)
+				if len(pending[index]) >= bulkEventBatchSize {
+					flush(index, pending[index])
+					delete(pending, index)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// Write out any remaining events.
+	for index, items := range pending {
+		flush(index, items)
+	}
+
+	// Report errors in the order they appeared in the stream.
+	sort.Sort(bulkEventErrorList(errs))
+	output := make([]interface{}, 0)
+	for _, e := range errs {
+		output = append(output, map[string]interface{}{"line": e.line, "message": e.err.Error()})
+	}
+
+	return map[string]interface{}{"count": count, "errors": output}, nil
+}
+
+// Deserializes a single line of a bulk event stream and determines which
+// servlet it belongs to.
+func (s *Server) deserializeBulkEvent(table *Table, data []byte) (*bulkEvent, uint32, error) {
+	var m map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil || decoder.More() {
+		return nil, 0, errors.New("Malformed json event.")
+	}
+
+	objectId, ok := m["objectId"].(string)
+	if !ok || objectId == "" {
+		return nil, 0, errors.New("Object identifier required.")
+	}
+	event, err := table.DeserializeEvent(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	index, err := s.GetObjectServletIndex(table, objectId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &bulkEvent{objectId: objectId, event: event}, index, nil
+}
+
+// Factorizes and writes a batch of events to a servlet. Events are grouped by
+// object so that each object is only rewritten once per batch.
+func (s *Server) putBulkEvents(table *Table, servlet *Servlet, items []*bulkEvent, replace bool) []*bulkEventError {
+	errs := make([]*bulkEventError, 0)
+
+	objectIds := make([]string, 0)
+	lookup := make(map[string][]*bulkEvent)
+	for _, item := range items {
+		if err := table.FactorizeEvent(item.event, s.factors, true); err != nil {
+			errs = append(errs, &bulkEventError{item.line, err})
+			continue
+		}
+		if lookup[item.objectId] == nil {
+			objectIds = append(objectIds, item.objectId)
+		}
+		lookup[item.objectId] = append(lookup[item.objectId], item)
+	}
+
+	for _, objectId := range objectIds {
+		events := make([]*Event, 0)
+		for _, item := range lookup[objectId] {
+			events = append(events, item.event)
+		}
+		if err := servlet.PutEvents(table, objectId, events, replace); err != nil {
+			for _, item := range lookup[objectId] {
+				errs = append(errs, &bulkEventError{item.line, err})
+			}
+		}
+	}
+
+	return errs
+}
@@ -35,6 +35,22 @@ func TestServerUpdateEvents(t *testing.T) {
 	})
 }
 
+// Ensure that timestamps, large integers and factor lists round trip.
+func TestServerUpdateEventDataTypes(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "signup", false, "timestamp")
+		setupTestProperty("foo", "tags", false, "factor[]")
+		setupTestProperty("foo", "views", true, "integer")
+
+		resp, _ := sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T02:00:00Z", "application/json", `{"data":{"signup":"2012-01-01T01:30:00Z", "tags":["red","blue"], "views":5000000000}}`)
+		assertResponse(t, resp, 200, "", "PUT /tables/:name/objects/:objectId/events failed.")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"signup":"2012-01-01T01:30:00Z","tags":["red","blue"],"views":5000000000},"timestamp":"2012-01-01T02:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
 // Ensure that we can delete all events for an object.
 func TestServerDeleteEvent(t *testing.T) {
 	runTestServer(func(s *Server) {
@@ -71,10 +87,126 @@ func TestServerDeleteEvents(t *testing.T) {
 
 		// Delete the events.
 		resp, _ = sendTestHttpRequest("DELETE", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
-		assertResponse(t, resp, 200, "", "DELETE /tables/:name/objects/:objectId/events failed.")
+		assertResponse(t, resp, 200, `{"count":2}`+"\n", "DELETE /tables/:name/objects/:objectId/events failed.")
 
 		// Check our work.
 		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
 		assertResponse(t, resp, 200, "[]\n", "GET /tables/:name/objects/:objectId/events failed.")
 	})
 }
+
+// Ensure that integers up to 2^53 are kept exactly and larger ones are
+// rejected.
+func TestServerUpdateEventLargeIntegers(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "views", false, "integer")
+
+		resp, _ := sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T02:00:00Z", "application/json", `{"data":{"views":9007199254740992}}`)
+		assertResponse(t, resp, 200, "", "PUT /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/events", "application/json", `{"objectId":"xyz","timestamp":"2012-01-01T03:00:00Z","data":{"views":-9007199254740992}}`)
+		assertResponse(t, resp, 200, `{"count":1,"errors":[]}`+"\n", "POST /tables/:name/events failed.")
+
+		// Integers that queries couldn't see exactly are rejected.
+		resp, _ = sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T04:00:00Z", "application/json", `{"data":{"views":9007199254740993}}`)
+		assertResponse(t, resp, 500, `{"message":"Invalid integer value for property views: 9007199254740993"}`+"\n", "PUT /tables/:name/objects/:objectId/events failed.")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"views":9007199254740992},"timestamp":"2012-01-01T02:00:00Z"},{"data":{"views":-9007199254740992},"timestamp":"2012-01-01T03:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
+// Ensure that we can stream multiple events onto the server at once.
+func TestServerBulkEvents(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestProperty("foo", "baz", true, "integer")
+
+		// Stream events for two objects with one bad line.
+		body := `{"objectId":"xyz","timestamp":"2012-01-01T03:00:00Z","data":{"bar":"myValue2"}}` + "\n" +
+			`{"objectId":"xyz","timestamp":"2012-01-01T02:00:00Z","data":{"bar":"myValue","baz":12}}` + "\n" +
+			`{"objectId":"xyz","timestamp":"2012-01-01T04:00:00Z","data":{"bat":1}}` + "\n" +
+			"\n" +
+			`{"objectId":"abc","timestamp":"2012-01-01T02:00:00Z","data":{"baz":20}}`
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/events", "application/json", body)
+		assertResponse(t, resp, 200, `{"count":3,"errors":[{"line":3,"message":"Property not found: bat"}]}`+"\n", "POST /tables/:name/events failed.")
+
+		// Merge into an existing event.
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/events?mode=merge", "application/json", `{"objectId":"xyz","timestamp":"2012-01-01T03:00:00Z","data":{"baz":20}}`)
+		assertResponse(t, resp, 200, `{"count":1,"errors":[]}`+"\n", "POST /tables/:name/events?mode=merge failed.")
+
+		// Check our work.
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"bar":"myValue","baz":12},"timestamp":"2012-01-01T02:00:00Z"},{"data":{"bar":"myValue2","baz":20},"timestamp":"2012-01-01T03:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/abc/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"baz":20},"timestamp":"2012-01-01T02:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
+// Ensure that event data is validated against property data types.
+func TestServerEventValidation(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestProperty("foo", "baz", true, "integer")
+
+		// Reject a numeric string in strict mode.
+		resp, _ := sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T02:00:00Z", "application/json", `{"data":{"baz":"42"}}`)
+		resp.Body.Close()
+		if resp.StatusCode != 500 {
+			t.Fatalf("Expected 500, got %v.", resp.StatusCode)
+		}
+
+		// Convert it in lenient mode.
+		resp, _ = sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo", "application/json", `{"validation":"lenient"}`)
+		assertResponse(t, resp, 200, `{"name":"foo","validation":"lenient"}`+"\n", "PATCH /tables/:name failed.")
+		resp, _ = sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T02:00:00Z", "application/json", `{"data":{"baz":"42"}}`)
+		assertResponse(t, resp, 200, "", "PUT /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"baz":42},"timestamp":"2012-01-01T02:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
+// Ensure that unknown event data creates properties when enabled.
+func TestServerEventAutoCreateProperties(t *testing.T) {
+	runTestServer(func(s *Server) {
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables", "application/json", `{"name":"foo","autoCreate":true,"autoFactor":true}`)
+		assertResponse(t, resp, 200, `{"name":"foo","autoCreate":true,"autoFactor":true}`+"\n", "POST /tables failed.")
+
+		resp, _ = sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T02:00:00Z", "application/json", `{"data":{"action":"signup","price":12.5,"count":3,"paid":true,"ratio":1e2,"note":null}}`)
+		assertResponse(t, resp, 200, "", "PUT /tables/:name/objects/:objectId/events failed.")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/properties", "application/json", "")
+		assertResponse(t, resp, 200, `[{"id":-5,"name":"ratio","transient":true,"dataType":"float"},{"id":-4,"name":"price","transient":true,"dataType":"float"},{"id":-3,"name":"paid","transient":true,"dataType":"boolean"},{"id":-2,"name":"count","transient":true,"dataType":"integer"},{"id":-1,"name":"action","transient":true,"dataType":"factor"}]`+"\n", "GET /tables/:name/properties failed.")
+	})
+}
+
+// Ensure that we can retrieve and delete events within a time range.
+func TestServerEventRange(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T00:00:00Z", `{"data":{"bar":"a"}}`},
+			[]string{"xyz", "2012-02-01T00:00:00Z", `{"data":{"bar":"b"}}`},
+			[]string{"xyz", "2012-03-01T00:00:00Z", `{"data":{"bar":"c"}}`},
+			[]string{"xyz", "2012-04-01T00:00:00Z", `{"data":{"bar":"d"}}`},
+		})
+
+		resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events?start=2012-02-01T00:00:00Z&end=2012-04-01T00:00:00Z", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"bar":"b"},"timestamp":"2012-02-01T00:00:00Z"},{"data":{"bar":"c"},"timestamp":"2012-03-01T00:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events?order=desc&limit=2", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"bar":"d"},"timestamp":"2012-04-01T00:00:00Z"},{"data":{"bar":"c"},"timestamp":"2012-03-01T00:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+
+		// Reject an inverted range.
+		resp, _ = sendTestHttpRequest("DELETE", "http://localhost:8586/tables/foo/objects/xyz/events?start=2012-03-01T00:00:00Z&end=2012-03-01T00:00:00Z", "application/json", "")
+		assertResponse(t, resp, 500, `{"message":"Invalid time range: 2012-03-01T00:00:00Z to 2012-03-01T00:00:00Z"}`+"\n", "DELETE /tables/:name/objects/:objectId/events failed.")
+
+		// Remove the last two events and check the state.
+		resp, _ = sendTestHttpRequest("DELETE", "http://localhost:8586/tables/foo/objects/xyz/events?start=2012-03-01T00:00:00Z", "application/json", "")
+		assertResponse(t, resp, 200, `{"count":2}`+"\n", "DELETE /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz", "application/json", "")
+		assertResponse(t, resp, 200, `{"eventCount":2,"firstTimestamp":"2012-01-01T00:00:00Z","id":"xyz","lastTimestamp":"2012-02-01T00:00:00Z","state":{"data":{"bar":"b"},"timestamp":"2012-02-01T00:00:00Z"}}`+"\n", "GET /tables/:name/objects/:objectId failed.")
+	})
+}
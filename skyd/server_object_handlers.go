@@ -0,0 +1,155 @@
+package skyd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The number of objects returned per page if no limit is specified.
+const defaultObjectPageSize = 100
+
+// The largest number of objects returned per page.
+const maxObjectPageSize = 1000
+
+func (s *Server) addObjectHandlers() {
+	s.ApiHandleFunc("/tables/{name}/objects", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.getObjectsHandler(w, req, params)
+	}).Methods("GET")
+	s.ApiHandleFunc("/tables/{name}/objects/{objectId}", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.getObjectHandler(w, req, params)
+	}).Methods("GET")
+	s.ApiHandleFunc("/tables/{name}/objects/{objectId}/state", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.getObjectStateHandler(w, req, params)
+	}).Methods("GET")
+	s.ApiHandleFunc("/tables/{name}/objects/{objectId}/merge", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.mergeObjectHandler(w, req, params)
+	}).Methods("POST")
+}
+
+// GET /tables/:name/objects
+func (s *Server) getObjectsHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse paging options.
+	query := req.URL.Query()
+	limit := defaultObjectPageSize
+	if query.Get("limit") != "" {
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil {
+			return nil, fmt.Errorf("Invalid limit: %v", query.Get("limit"))
+		}
+		if limit > maxObjectPageSize {
+			limit = maxObjectPageSize
+		}
+	}
+
+	infos, token, err := s.ScanObjects(table, query.Get("continuationToken"), query.Get("prefix"), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert summaries to serializable objects.
+	objects := make([]map[string]interface{}, 0)
+	for _, info := range infos {
+		objects = append(objects, serializeObjectInfo(info))
+	}
+	ret := map[string]interface{}{"objects": objects}
+	if token != "" {
+		ret["continuationToken"] = token
+	}
+
+	return ret, nil
+}
+
+// GET /tables/:name/objects/:objectId
+func (s *Server) getObjectHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, servlet, err := s.GetObjectContext(vars["name"], vars["objectId"])
+	if err != nil {
+		return nil, err
+	}
+
+	state, info, err := servlet.GetObject(table, vars["objectId"])
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("Object not found: %v", vars["objectId"])
+	}
+
+	// Denormalize the state.
+	err = table.DefactorizeEvent(state, s.factors)
+	if err != nil {
+		return nil, err
+	}
+	m, err := table.SerializeEvent(state)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := serializeObjectInfo(info)
+	ret["state"] = m
+	return ret, nil
+}
+
+// GET /tables/:name/objects/:objectId/state
+func (s *Server) getObjectStateHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, servlet, err := s.GetObjectContext(vars["name"], vars["objectId"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the point in time. Defaults to the current time.
+	query := req.URL.Query()
+	timestamp := time.Now().UTC()
+	if query.Get("at") != "" {
+		if timestamp, err = time.Parse(time.RFC3339, query.Get("at")); err != nil {
+			return nil, fmt.Errorf("Unable to parse timestamp: %v", query.Get("at"))
+		}
+	}
+	transient := query.Get("transient") == "true"
+
+	state, err := servlet.GetStateAt(table, vars["objectId"], timestamp, transient)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("Object not found: %v", vars["objectId"])
+	}
+
+	// Denormalize the state.
+	err = table.DefactorizeEvent(state, s.factors)
+	if err != nil {
+		return nil, err
+	}
+	return table.SerializeEvent(state)
+}
+
+// Converts an object summary into a map.
+func serializeObjectInfo(info *ObjectInfo) map[string]interface{} {
+	m := map[string]interface{}{"id": info.Id, "eventCount": info.EventCount}
+	if info.EventCount > 0 {
+		m["firstTimestamp"] = info.FirstTimestamp.UTC().Format(time.RFC3339)
+		m["lastTimestamp"] = info.LastTimestamp.UTC().Format(time.RFC3339)
+	}
+	return m
+}
+
+// POST /tables/:name/objects/:objectId/merge
+func (s *Server) mergeObjectHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	sourceObjectId, ok := params["sourceObjectId"].(string)
+	if !ok {
+		return nil, errors.New("Source object identifier required.")
+	}
+
+	return nil, s.MergeObjects(vars["name"], vars["objectId"], sourceObjectId)
+}
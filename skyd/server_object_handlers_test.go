@@ -0,0 +1,140 @@
+package skyd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Ensure that we can merge one object into another.
+func TestServerMergeObjects(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestProperty("foo", "baz", true, "integer")
+		setupTestData(t, "foo", [][]string{
+			[]string{"anon", "2012-01-01T00:00:00Z", `{"data":{"baz":1}}`},
+			[]string{"anon", "2012-01-01T02:00:00Z", `{"data":{"baz":2}}`},
+			[]string{"bob", "2012-01-01T01:00:00Z", `{"data":{"bar":"myValue"}}`},
+			[]string{"bob", "2012-01-01T02:00:00Z", `{"data":{"bar":"myValue2"}}`},
+		})
+
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/objects/bob/merge", "application/json", `{"sourceObjectId":"anon"}`)
+		assertResponse(t, resp, 200, "", "POST /tables/:name/objects/:objectId/merge failed.")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/bob/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"baz":1},"timestamp":"2012-01-01T00:00:00Z"},{"data":{"bar":"myValue"},"timestamp":"2012-01-01T01:00:00Z"},{"data":{"bar":"myValue2","baz":2},"timestamp":"2012-01-01T02:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/anon/events", "application/json", "")
+		assertResponse(t, resp, 200, "[]\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
+// Ensure that queued writes to either object are merged.
+func TestServerMergeObjectsQueuedWrites(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		resp, _ := sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/anon/events/2012-01-01T00:00:00Z?sync=false", "application/json", `{"data":{"bar":"x"}}`)
+		assertResponse(t, resp, 200, "", "PUT /tables/:name/objects/:objectId/events/:timestamp failed.")
+		resp, _ = sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/bob/events/2012-01-02T00:00:00Z?sync=false", "application/json", `{"data":{"bar":"y"}}`)
+		assertResponse(t, resp, 200, "", "PUT /tables/:name/objects/:objectId/events/:timestamp failed.")
+
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/objects/bob/merge", "application/json", `{"sourceObjectId":"anon"}`)
+		assertResponse(t, resp, 200, "", "POST /tables/:name/objects/:objectId/merge failed.")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/bob/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"bar":"x"},"timestamp":"2012-01-01T00:00:00Z"},{"data":{"bar":"y"},"timestamp":"2012-01-02T00:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/anon/events", "application/json", "")
+		assertResponse(t, resp, 200, "[]\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
+// Ensure that we can page through the objects in a table.
+func TestServerGetObjects(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestData(t, "foo", [][]string{
+			[]string{"a0", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+			[]string{"a0", "2012-01-02T00:00:00Z", `{"data":{"bar":"y"}}`},
+			[]string{"a1", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+			[]string{"a2", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+			[]string{"b0", "2012-01-01T00:00:00Z", `{"data":{"bar":"x"}}`},
+		})
+
+		// Page through objects with a prefix.
+		ids := make(map[string]bool)
+		token := ""
+		for i := 0; i < 10; i++ {
+			resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects?limit=2&prefix=a&continuationToken="+token, "application/json", "")
+			var page struct {
+				Objects []struct {
+					Id         string `json:"id"`
+					EventCount int    `json:"eventCount"`
+				} `json:"objects"`
+				ContinuationToken string `json:"continuationToken"`
+			}
+			json.NewDecoder(resp.Body).Decode(&page)
+			resp.Body.Close()
+			if len(page.Objects) > 2 {
+				t.Fatalf("Too many objects returned: %v", page.Objects)
+			}
+			for _, object := range page.Objects {
+				if ids[object.Id] {
+					t.Fatalf("Duplicate object: %v", object.Id)
+				}
+				ids[object.Id] = true
+				if object.Id == "a0" && object.EventCount != 2 {
+					t.Fatalf("Unexpected event count: %v", object.EventCount)
+				}
+			}
+			if token = page.ContinuationToken; token == "" {
+				break
+			}
+		}
+		if len(ids) != 3 || !ids["a0"] || !ids["a1"] || !ids["a2"] {
+			t.Fatalf("Unexpected objects: %v", ids)
+		}
+
+		// Retrieve a single object.
+		resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects?prefix=b", "application/json", "")
+		assertResponse(t, resp, 200, `{"objects":[{"eventCount":1,"firstTimestamp":"2012-01-01T00:00:00Z","id":"b0","lastTimestamp":"2012-01-01T00:00:00Z"}]}`+"\n", "GET /tables/:name/objects failed.")
+	})
+}
+
+// Ensure that we can retrieve the current state of an object.
+func TestServerGetObject(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestProperty("foo", "bat", false, "factor")
+		setupTestProperty("foo", "baz", true, "integer")
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T00:00:00Z", `{"data":{"bar":"myValue","bat":"A","baz":1}}`},
+			[]string{"xyz", "2012-01-02T00:00:00Z", `{"data":{"bar":"myValue2","baz":2}}`},
+		})
+
+		resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz", "application/json", "")
+		assertResponse(t, resp, 200, `{"eventCount":2,"firstTimestamp":"2012-01-01T00:00:00Z","id":"xyz","lastTimestamp":"2012-01-02T00:00:00Z","state":{"data":{"bar":"myValue2","bat":"A"},"timestamp":"2012-01-02T00:00:00Z"}}`+"\n", "GET /tables/:name/objects/:objectId failed.")
+	})
+}
+
+// Ensure that we can reconstruct the state of an object at a point in time.
+func TestServerGetObjectStateAt(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "plan", false, "factor")
+		setupTestProperty("foo", "action", true, "string")
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T00:00:00Z", `{"data":{"plan":"free","action":"signup"}}`},
+			[]string{"xyz", "2012-02-01T00:00:00Z", `{"data":{"plan":"pro","action":"upgrade"}}`},
+			[]string{"xyz", "2012-03-01T00:00:00Z", `{"data":{"action":"churn"}}`},
+		})
+
+		resp, _ := sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/state?at=2012-01-15T00:00:00Z", "application/json", "")
+		assertResponse(t, resp, 200, `{"data":{"plan":"free"},"timestamp":"2012-01-15T00:00:00Z"}`+"\n", "GET /tables/:name/objects/:objectId/state failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/state?at=2012-03-01T00:00:00Z&transient=true", "application/json", "")
+		assertResponse(t, resp, 200, `{"data":{"action":"churn","plan":"pro"},"timestamp":"2012-03-01T00:00:00Z"}`+"\n", "GET /tables/:name/objects/:objectId/state failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/state?at=2011-12-01T00:00:00Z", "application/json", "")
+		assertResponse(t, resp, 200, `{"data":{},"timestamp":"2011-12-01T00:00:00Z"}`+"\n", "GET /tables/:name/objects/:objectId/state failed.")
+	})
+}
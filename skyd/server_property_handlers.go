@@ -2,8 +2,10 @@ package skyd
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
 )
 
 func (s *Server) addPropertyHandlers() {
@@ -23,6 +25,13 @@ func (s *Server) addPropertyHandlers() {
 	s.ApiHandleFunc("/tables/{name}/properties/{propertyName}", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
 		return s.deletePropertyHandler(w, req, params)
 	}).Methods("DELETE")
+
+	s.ApiHandleFunc("/tables/{name}/properties/{propertyName}/migration", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.getPropertyMigrationHandler(w, req, params)
+	}).Methods("GET")
+	s.ApiHandleFunc("/tables/{name}/purges/{id}", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.getPropertyPurgeHandler(w, req, params)
+	}).Methods("GET")
 }
 
 // GET /tables/:name/properties
@@ -48,7 +57,18 @@ func (s *Server) createPropertyHandler(w http.ResponseWriter, req *http.Request,
 	name, _ := params["name"].(string)
 	transient, _ := params["transient"].(bool)
 	dataType, _ := params["dataType"].(string)
-	return table.CreateProperty(name, transient, dataType)
+	property, err := NewProperty(0, name, transient, dataType)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := NewPropertyMetadata(params, PropertyMetadata{})
+	if err != nil {
+		return nil, err
+	}
+	if err = property.SetMetadata(metadata, false); err != nil {
+		return nil, err
+	}
+	return table.AddProperty(property)
 }
 
 // GET /tables/:name/properties/:propertyName
@@ -79,17 +99,59 @@ func (s *Server) updatePropertyHandler(w http.ResponseWriter, req *http.Request,
 		return nil, errors.New("Property does not exist.")
 	}
 
-	// Update property and save property file.
-	name, _ := params["name"].(string)
-	property.Name = name
-	err = table.SavePropertyFile()
+	// Read the whole update before anything is changed.
+	name, dataType, transient := property.Name, property.DataType, property.Transient
+	if value, ok := params["name"].(string); ok {
+		name = value
+	}
+	metadata, err := NewPropertyMetadata(params, property.PropertyMetadata)
 	if err != nil {
 		return nil, err
 	}
+	if value, ok := params["dataType"].(string); ok {
+		dataType = value
+	}
+	if value, ok := params["transient"].(bool); ok {
+		transient = value
+	}
+
+	// Apply the update and migrate existing values if the data type or
+	// transience changed.
+	changed := dataType != property.DataType || transient != property.Transient
+	if property, err = table.UpdateProperty(property, name, metadata, dataType, transient); err != nil {
+		return nil, err
+	}
+	if changed {
+		s.startPropertyMigration(table, property)
+	}
 
 	return property, nil
 }
 
+// GET /tables/:name/properties/:propertyName/migration
+func (s *Server) getPropertyMigrationHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve property.
+	property, err := table.GetPropertyByName(vars["propertyName"])
+	if err != nil {
+		return nil, err
+	}
+	if property == nil {
+		return nil, errors.New("Property does not exist.")
+	}
+
+	status := s.GetPropertyMigrationStatus(table, property)
+	if status == nil {
+		return nil, errors.New("Property has no migration.")
+	}
+	return status, nil
+}
+
 // DELETE /tables/:name/properties/:propertyName
 func (s *Server) deletePropertyHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
 	vars := mux.Vars(req)
@@ -106,12 +168,37 @@ func (s *Server) deletePropertyHandler(w http.ResponseWriter, req *http.Request,
 		return nil, errors.New("Property does not exist.")
 	}
 
-	// Delete property and save property file.
-	table.DeleteProperty(property)
+	// Delete property and save property file. Its values are purged in the
+	// background and the placeholder is returned so that the purge can be
+	// followed by its identifier.
+	placeholder, err := table.DeleteProperty(property)
+	if err != nil {
+		return nil, err
+	}
 	err = table.SavePropertyFile()
 	if err != nil {
 		return nil, err
 	}
+	s.startPropertyPurge(table, placeholder)
+
+	return placeholder, nil
+}
 
-	return nil, nil
+// GET /tables/:name/purges/:id
+func (s *Server) getPropertyPurgeHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid property id: %v", vars["id"])
+	}
+
+	status := s.GetPropertyPurgeStatus(table, id)
+	if status == nil {
+		return nil, errors.New("Property has no purge.")
+	}
+	return status, nil
 }
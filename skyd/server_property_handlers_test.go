@@ -1,7 +1,10 @@
 package skyd
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Ensure that we can create a property through the server.
@@ -35,6 +38,31 @@ func TestServerGetProperty(t *testing.T) {
 	})
 }
 
+// Ensure that property metadata can be set and is enforced on events.
+func TestServerPropertyMetadata(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/properties", "application/json", `{"name":"bar", "transient":false, "dataType":"string", "description":"Plan name", "enum":["free","paid"]}`)
+		assertResponse(t, resp, 200, `{"id":1,"name":"bar","transient":false,"dataType":"string","description":"Plan name","enum":["free","paid"]}`+"\n", "POST /tables/:name/properties failed.")
+		resp, _ = sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo/properties/bar", "application/json", `{"unit":"plan", "nullable":false, "default":"free"}`)
+		assertResponse(t, resp, 200, `{"id":1,"name":"bar","transient":false,"dataType":"string","description":"Plan name","unit":"plan","enum":["free","paid"],"nullable":false,"default":"free"}`+"\n", "PATCH /tables/:name/properties/:propertyName failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/properties/bar", "application/json", "")
+		assertResponse(t, resp, 200, `{"id":1,"name":"bar","transient":false,"dataType":"string","description":"Plan name","unit":"plan","enum":["free","paid"],"nullable":false,"default":"free"}`+"\n", "GET /tables/:name/properties/:propertyName failed.")
+
+		resp, _ = sendTestHttpRequest("PUT", "http://localhost:8586/tables/foo/objects/xyz/events/2012-01-01T02:00:00Z", "application/json", `{"data":{"bar":"gold"}}`)
+		resp.Body.Close()
+		if resp.StatusCode == 200 {
+			t.Fatalf("Expected value outside of enum to be rejected")
+		}
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T03:00:00Z", `{"data":{"bar":"paid"}}`},
+			[]string{"xyz", "2012-01-01T04:00:00Z", `{"data":{"bar":null}}`},
+		})
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"bar":"paid"},"timestamp":"2012-01-01T03:00:00Z"},{"data":{"bar":"free"},"timestamp":"2012-01-01T04:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
+
 // Ensure that we can update a property name through the server.
 func TestServerUpdateProperty(t *testing.T) {
 	runTestServer(func(s *Server) {
@@ -43,9 +71,56 @@ func TestServerUpdateProperty(t *testing.T) {
 		setupTestProperty("foo", "baz", true, "integer")
 		resp, _ := sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo/properties/bar", "application/json", `{"name":"bat"}`)
 		assertResponse(t, resp, 200, `{"id":1,"name":"bat","transient":false,"dataType":"string"}`+"\n", "PATCH /tables/:name/properties/:propertyName failed.")
+
+		// An invalid update doesn't change anything.
+		resp, _ = sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo/properties/bat", "application/json", `{"name":"bar", "description":"Plan name", "dataType":"bogus"}`)
+		assertResponse(t, resp, 500, `{"message":"Invalid property data type: bogus"}`+"\n", "PATCH /tables/:name/properties/:propertyName failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/properties", "application/json", "")
+		assertResponse(t, resp, 200, `[{"id":-1,"name":"baz","transient":true,"dataType":"integer"},{"id":1,"name":"bat","transient":false,"dataType":"string"}]`+"\n", "GET /tables/:name/properties failed.")
+	})
+}
+
+// Ensure that we can change a property's data type and transience through
+// the server and that existing values are migrated.
+func TestServerUpdatePropertyDataType(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestProperty("foo", "baz", true, "integer")
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T02:00:00Z", `{"data":{"bar":"myValue1", "baz":10}}`},
+			[]string{"xyz", "2012-01-01T03:00:00Z", `{"data":{"bar":"myValue2", "baz":20}}`},
+		})
+		resp, _ := sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo/properties/bar", "application/json", `{"dataType":"factor"}`)
+		assertResponse(t, resp, 200, `{"id":2,"name":"bar","transient":false,"dataType":"factor","migration":{"sourceId":1,"sourceName":"bar","sourceDataType":"string"}}`+"\n", "PATCH /tables/:name/properties/:propertyName failed.")
+		waitForPropertyJob(t, "http://localhost:8586/tables/foo/properties/bar/migration")
+		resp, _ = sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo/properties/baz", "application/json", `{"dataType":"string", "transient":false}`)
+		assertResponse(t, resp, 200, `{"id":3,"name":"baz","transient":false,"dataType":"string","migration":{"sourceId":-1,"sourceName":"baz","sourceDataType":"integer"}}`+"\n", "PATCH /tables/:name/properties/:propertyName failed.")
+		waitForPropertyJob(t, "http://localhost:8586/tables/foo/properties/baz/migration")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/properties/baz/migration", "application/json", "")
+		assertResponse(t, resp, 200, `{"state":"complete","objectCount":1,"processedCount":1,"valueCount":2,"droppedCount":0}`+"\n", "GET /tables/:name/properties/:propertyName/migration failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/properties", "application/json", "")
+		assertResponse(t, resp, 200, `[{"id":2,"name":"bar","transient":false,"dataType":"factor"},{"id":3,"name":"baz","transient":false,"dataType":"string"}]`+"\n", "GET /tables/:name/properties failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"bar":"myValue1","baz":"10"},"timestamp":"2012-01-01T02:00:00Z"},{"data":{"bar":"myValue2","baz":"20"},"timestamp":"2012-01-01T03:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
 	})
 }
 
+// Waits for a property migration or purge to complete.
+func waitForPropertyJob(t *testing.T, url string) {
+	for i := 0; i < 100; i++ {
+		resp, _ := sendTestHttpRequest("GET", url, "application/json", "")
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if strings.Contains(string(body), `"state":"complete"`) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Property job did not complete: %v", url)
+}
+
 // Ensure that we can delete a property on the server.
 func TestServerDeleteProperty(t *testing.T) {
 	runTestServer(func(s *Server) {
@@ -53,8 +128,34 @@ func TestServerDeleteProperty(t *testing.T) {
 		setupTestProperty("foo", "bar", false, "string")
 		setupTestProperty("foo", "baz", true, "integer")
 		resp, _ := sendTestHttpRequest("DELETE", "http://localhost:8586/tables/foo/properties/bar", "application/json", "")
-		assertResponse(t, resp, 200, "", "DELETE /tables/:name/properties/:propertyName failed.")
+		assertResponse(t, resp, 200, `{"id":1,"name":"","transient":false,"dataType":"string","deleted":true}`+"\n", "DELETE /tables/:name/properties/:propertyName failed.")
 		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/properties", "application/json", "")
 		assertResponse(t, resp, 200, `[{"id":-1,"name":"baz","transient":true,"dataType":"integer"}]`+"\n", "GET /tables/:name/properties after delete failed.")
 	})
 }
+
+// Ensure that the values of a deleted property are purged and that its
+// identifier is only reused afterward.
+func TestServerDeletePropertyPurge(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "bar", false, "string")
+		setupTestProperty("foo", "baz", true, "integer")
+		setupTestData(t, "foo", [][]string{
+			[]string{"xyz", "2012-01-01T02:00:00Z", `{"data":{"bar":"myValue1", "baz":10}}`},
+			[]string{"xyz", "2012-01-01T03:00:00Z", `{"data":{"bar":"myValue2", "baz":20}}`},
+		})
+		resp, _ := sendTestHttpRequest("DELETE", "http://localhost:8586/tables/foo/properties/bar", "application/json", "")
+		assertResponse(t, resp, 200, `{"id":1,"name":"","transient":false,"dataType":"string","deleted":true}`+"\n", "DELETE /tables/:name/properties/:propertyName failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"baz":10},"timestamp":"2012-01-01T02:00:00Z"},{"data":{"baz":20},"timestamp":"2012-01-01T03:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+		waitForPropertyJob(t, "http://localhost:8586/tables/foo/purges/1")
+
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/purges/1", "application/json", "")
+		assertResponse(t, resp, 200, `{"state":"complete","objectCount":1,"processedCount":1,"valueCount":2,"droppedCount":0}`+"\n", "GET /tables/:name/purges/:id failed.")
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/properties", "application/json", `{"name":"bat", "transient":false, "dataType":"integer"}`)
+		assertResponse(t, resp, 200, `{"id":1,"name":"bat","transient":false,"dataType":"integer"}`+"\n", "POST /tables/:name/properties failed.")
+		resp, _ = sendTestHttpRequest("GET", "http://localhost:8586/tables/foo/objects/xyz/events", "application/json", "")
+		assertResponse(t, resp, 200, `[{"data":{"baz":10},"timestamp":"2012-01-01T02:00:00Z"},{"data":{"baz":20},"timestamp":"2012-01-01T03:00:00Z"}]`+"\n", "GET /tables/:name/objects/:objectId/events failed.")
+	})
+}
@@ -98,6 +98,106 @@ func TestServerMultiDimensionalQuery(t *testing.T) {
 	})
 }
 
+// Ensure that factor lists group an event under each of its factors.
+func TestServerFactorListQuery(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "tags", false, "factor[]")
+		setupTestProperty("foo", "views", true, "integer")
+		setupTestData(t, "foo", [][]string{
+			[]string{"t0", "2012-01-01T00:00:00Z", `{"data":{"tags":["red","blue"], "views":5000000000}}`},
+			[]string{"t1", "2012-01-01T00:00:00Z", `{"data":{"tags":["blue"], "views":1}}`},
+			[]string{"t2", "2012-01-01T00:00:00Z", `{"data":{"views":2}}`},
+		})
+
+		// Group by tag.
+		query := `{
+			"steps":[
+				{"type":"selection","dimensions":["tags"],"fields":[
+					{"name":"count","expression":"count()"},
+					{"name":"views","expression":"sum(views)"}
+				]}
+			]
+		}`
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"tags":{"blue":{"count":2,"views":5000000001},"red":{"count":1,"views":5000000000}}}`+"\n", "POST /tables/:name/query failed.")
+
+		// Match events containing a tag.
+		query = `{
+			"steps":[
+				{"type":"condition","expression":"tags == 'red'","steps":[
+					{"type":"selection","dimensions":[],"fields":[{"name":"count","expression":"count()"}]}
+				]}
+			]
+		}`
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"count":1}`+"\n", "POST /tables/:name/query failed.")
+
+		// Match events without a tag.
+		query = `{
+			"steps":[
+				{"type":"condition","expression":"tags != 'red'","steps":[
+					{"type":"selection","dimensions":[],"fields":[{"name":"count","expression":"count()"}]}
+				]}
+			]
+		}`
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"count":2}`+"\n", "POST /tables/:name/query failed.")
+
+		// Factor lists can't be ordered.
+		query = `{
+			"steps":[
+				{"type":"condition","expression":"tags > 'red'","steps":[
+					{"type":"selection","dimensions":[],"fields":[{"name":"count","expression":"count()"}]}
+				]}
+			]
+		}`
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 500, `{"message":"skyd.QueryCondition: Ordering operators are only supported for integer, float and timestamp properties: tags \u003e 'red'"}`+"\n", "POST /tables/:name/query failed.")
+	})
+}
+
+// Ensure that timestamps can be used as dimensions and in conditions.
+func TestServerTimestampQuery(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "signup", false, "timestamp")
+		setupTestData(t, "foo", [][]string{
+			[]string{"u0", "2012-01-01T00:00:00Z", `{"data":{"signup":"2011-06-01T00:00:00Z"}}`},
+			[]string{"u1", "2012-01-01T00:00:00Z", `{"data":{"signup":"2011-07-01T00:00:00Z"}}`},
+			[]string{"u2", "2012-01-01T00:00:00Z", `{"data":{"signup":"2011-07-01T00:00:00Z"}}`},
+		})
+
+		query := `{
+			"steps":[
+				{"type":"selection","dimensions":["signup"],"fields":[{"name":"count","expression":"count()"}]}
+			]
+		}`
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"signup":{"2011-06-01T00:00:00Z":{"count":1},"2011-07-01T00:00:00Z":{"count":2}}}`+"\n", "POST /tables/:name/query failed.")
+
+		query = `{
+			"steps":[
+				{"type":"condition","expression":"signup == '2011-07-01T00:00:00Z'","steps":[
+					{"type":"selection","dimensions":[],"fields":[{"name":"count","expression":"count()"}]}
+				]}
+			]
+		}`
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"count":2}`+"\n", "POST /tables/:name/query failed.")
+
+		query = `{
+			"steps":[
+				{"type":"condition","expression":"signup < '2011-07-01T00:00:00Z'","steps":[
+					{"type":"selection","dimensions":[],"fields":[{"name":"count","expression":"count()"}]}
+				]}
+			]
+		}`
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"count":1}`+"\n", "POST /tables/:name/query failed.")
+	})
+}
+
 // Ensure that we can perform a non-sessionized funnel analysis.
 func TestServerFunnelAnalysisQuery(t *testing.T) {
 	runTestServer(func(s *Server) {
@@ -2,6 +2,7 @@ package skyd
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
 )
@@ -19,6 +20,18 @@ func (s *Server) addTableHandlers() {
 	s.ApiHandleFunc("/tables/{name}", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
 		return s.deleteTableHandler(w, req, params)
 	}).Methods("DELETE")
+	s.ApiHandleFunc("/tables/{name}", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.updateTableHandler(w, req, params)
+	}).Methods("PATCH")
+	s.ApiHandleFunc("/tables/{name}/compact", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.compactTableHandler(w, req, params)
+	}).Methods("POST")
+	s.ApiHandleFunc("/tables/{name}/export", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.exportTableHandler(w, req, params)
+	}).Methods("GET")
+	s.StreamingApiHandleFunc("/tables/{name}/import", func(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+		return s.importTableHandler(w, req, params)
+	}).Methods("POST")
 }
 
 // GET /tables
@@ -53,9 +66,74 @@ func (s *Server) createTableHandler(w http.ResponseWriter, req *http.Request, pa
 		return nil, err
 	}
 
+	// Apply any settings that were specified.
+	if err = s.updateTableSettings(table, params); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// PATCH /tables/:name
+func (s *Server) updateTableHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Update the table settings.
+	if err = s.updateTableSettings(table, params); err != nil {
+		return nil, err
+	}
+
 	return table, nil
 }
 
+// POST /tables/:name/compact
+func (s *Server) compactTableHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Compact the table's store on each servlet.
+	for _, servlet := range s.servlets {
+		if err := servlet.Compact(table.Name); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Applies the settings in the request parameters to a table.
+func (s *Server) updateTableSettings(table *Table, params map[string]interface{}) error {
+	if retentionDays, ok := toInt(params["retentionDays"]); ok {
+		if err := table.SetRetentionDays(retentionDays); err != nil {
+			return err
+		}
+	}
+	if validation, ok := params["validation"].(string); ok {
+		if err := table.SetValidation(validation); err != nil {
+			return err
+		}
+	}
+	if autoCreate, ok := params["autoCreate"].(bool); ok {
+		autoFactor, _ := params["autoFactor"].(bool)
+		autoPermanent, _ := params["autoPermanent"].(bool)
+		if err := table.SetAutoCreate(autoCreate, autoFactor, autoPermanent); err != nil {
+			return err
+		}
+	}
+	if compression, ok := params["compression"].(bool); ok {
+		if err := table.SetCompression(compression); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DELETE /tables/:name
 func (s *Server) deleteTableHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
 	vars := mux.Vars(req)
@@ -63,3 +141,42 @@ func (s *Server) deleteTableHandler(w http.ResponseWriter, req *http.Request, pa
 
 	return nil, s.DeleteTable(tableName)
 }
+
+// GET /tables/:name/export
+func (s *Server) exportTableHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	table, err := s.OpenTable(vars["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	format := dumpFormat(req)
+	switch format {
+	case JSONDumpFormat:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case MsgPackDumpFormat:
+		w.Header().Set("Content-Type", "application/x-msgpack")
+	default:
+		return nil, fmt.Errorf("Invalid dump format: %v", format)
+	}
+
+	return nil, s.ExportTable(table, w, format)
+}
+
+// POST /tables/:name/import
+func (s *Server) importTableHandler(w http.ResponseWriter, req *http.Request, params map[string]interface{}) (interface{}, error) {
+	vars := mux.Vars(req)
+	return s.ImportTable(vars["name"], req.Body, dumpFormat(req))
+}
+
+// Determines the dump format from the "format" query parameter. MsgPack is
+// also used if the request's content type is MsgPack.
+func dumpFormat(req *http.Request) string {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	if req.Header.Get("Content-Type") == "application/x-msgpack" {
+		return MsgPackDumpFormat
+	}
+	return JSONDumpFormat
+}
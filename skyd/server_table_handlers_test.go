@@ -2,7 +2,10 @@ package skyd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -72,3 +75,57 @@ func TestServerDeleteTable(t *testing.T) {
 		}
 	})
 }
+
+// Ensure that we can update a table's retention period through the server.
+func TestServerUpdateTableRetention(t *testing.T) {
+	runTestServer(func(s *Server) {
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables", "application/json", `{"name":"foo","retentionDays":30}`)
+		assertResponse(t, resp, 200, `{"name":"foo","retentionDays":30}`+"\n", "POST /tables failed.")
+		resp, _ = sendTestHttpRequest("PATCH", "http://localhost:8586/tables/foo", "application/json", `{"retentionDays":180}`)
+		assertResponse(t, resp, 200, `{"name":"foo","retentionDays":180}`+"\n", "PATCH /tables/:name failed.")
+	})
+}
+
+// Ensure that a table can be exported and imported into another table.
+func TestServerExportImportTable(t *testing.T) {
+	runTestServer(func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "fruit", false, "factor")
+		setupTestProperty("foo", "action", true, "string")
+		setupTestData(t, "foo", [][]string{
+			[]string{"a", "2012-01-01T00:00:00Z", `{"data":{"fruit":"apple","action":"eat"}}`},
+			[]string{"a", "2012-01-02T00:00:00Z", `{"data":{"fruit":"grape"}}`},
+			[]string{"b", "2012-01-01T00:00:00Z", `{"data":{"action":"buy"}}`},
+		})
+
+		// Export as JSON and import into a new table.
+		dump := exportTestTable(t, "foo", "json")
+		lines := strings.Split(strings.TrimSpace(dump), "\n")
+		if len(lines) != 3 || !strings.Contains(dump, `{"events":[{"data":{"action":"eat","fruit":"apple"},"timestamp":"2012-01-01T00:00:00Z"},{"data":{"fruit":"grape"},"timestamp":"2012-01-02T00:00:00Z"}],"id":"a"}`) {
+			t.Fatalf("Unexpected export:\n%v", dump)
+		}
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/bar/import", "application/x-ndjson", dump)
+		assertResponse(t, resp, 200, `{"eventCount":3,"objectCount":2}`+"\n", "POST /tables/:name/import failed.")
+		imported := strings.Split(strings.TrimSpace(exportTestTable(t, "bar", "json")), "\n")
+		sort.Strings(lines[1:])
+		sort.Strings(imported[1:])
+		if strings.Join(lines[1:], "\n") != strings.Join(imported[1:], "\n") {
+			t.Fatalf("Imported table doesn't match:\nexp: %v\ngot: %v", lines[1:], imported[1:])
+		}
+
+		// Round trip through MsgPack.
+		dump = exportTestTable(t, "foo", "msgpack")
+		resp, _ = sendTestHttpRequest("POST", "http://localhost:8586/tables/baz/import", "application/x-msgpack", dump)
+		assertResponse(t, resp, 200, `{"eventCount":3,"objectCount":2}`+"\n", "POST /tables/:name/import failed.")
+	})
+}
+
+func exportTestTable(t *testing.T, name string, format string) string {
+	resp, err := sendTestHttpRequest("GET", fmt.Sprintf("http://localhost:8586/tables/%v/export?format=%v", name, format), "application/json", "")
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("GET /tables/:name/export failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
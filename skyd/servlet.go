@@ -1,14 +1,11 @@
 package skyd
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"github.com/jmhodges/levigo"
-	"github.com/ugorji/go-msgpack"
-	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
@@ -20,14 +17,35 @@ import (
 //
 //------------------------------------------------------------------------------
 
-// A Servlet is a small wrapper around a single shard of a LevelDB data file.
+// A Servlet is a small wrapper around a single shard of the data store. Each
+// table has its own store within the shard so that a table can be removed by
+// deleting its directory.
 type Servlet struct {
-	path    string
-	db      *levigo.DB
-	factors *Factors
-	mutex   sync.Mutex
+	path       string
+	dbs        map[string]*tableStore
+	dbMutex    sync.RWMutex
+	factors    *Factors
+	engine     StorageEngine
+	mutex      sync.Mutex
+	writes     chan *servletWrite
+	writesDone chan bool
+	queueMutex sync.RWMutex
 }
 
+// A tableStore is a table's store on a servlet. Callers hold a reference to
+// the store for as long as they use it or any of its iterators so that a
+// dropped table is only closed once nothing is reading from it.
+type tableStore struct {
+	Storage
+	mutex    sync.Mutex
+	refs     int
+	dropped  bool
+	released chan bool
+}
+
+// Returned when a table doesn't have a store on a servlet.
+var errTableStoreNotFound = errors.New("skyd.Servlet: Table store not found.")
+
 //------------------------------------------------------------------------------
 //
 // Constructors
@@ -42,6 +60,49 @@ func NewServlet(path string, factors *Factors) *Servlet {
 	}
 }
 
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Checks if a servlet directory uses the layout where every table shares a
+// single LevelDB database.
+func isSharedServlet(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "CURRENT"))
+	return err == nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Properties
+//
+//------------------------------------------------------------------------------
+
+// The path to the directory holding each table's store.
+func (s *Servlet) TablesPath() string {
+	return filepath.Join(s.path, "tables")
+}
+
+// Generates the path for a table's store.
+func (s *Servlet) TablePath(name string) string {
+	return filepath.Join(s.TablesPath(), name)
+}
+
+// The path that dropped tables are moved to while they are removed.
+func (s *Servlet) droppedPath() string {
+	return filepath.Join(s.path, "dropped")
+}
+
+// The storage engine used for each table's store. LevelDB is used if no
+// engine has been set.
+func (s *Servlet) storageEngine() StorageEngine {
+	if s.engine == nil {
+		return NewLevelDBEngine(nil)
+	}
+	return s.engine
+}
+
 //------------------------------------------------------------------------------
 //
 // Methods
@@ -52,29 +113,256 @@ func NewServlet(path string, factors *Factors) *Servlet {
 // Lifecycle
 //--------------------------------------
 
-// Opens the underlying LevelDB database and starts the message loop.
+// Opens the store for each table and starts the message loop.
 func (s *Servlet) Open() error {
-	err := os.MkdirAll(s.path, 0700)
+	if isSharedServlet(s.path) {
+		return fmt.Errorf("skyd.Servlet: Data directory must be migrated to one store per table with 'skyd migrate': %v", s.path)
+	}
+	err := os.MkdirAll(s.TablesPath(), 0700)
 	if err != nil {
 		return err
 	}
 
-	opts := levigo.NewOptions()
-	opts.SetCreateIfMissing(true)
-	db, err := levigo.Open(s.path, opts)
+	// Finish removing any tables that were dropped before the last shutdown.
+	if err = os.RemoveAll(s.droppedPath()); err != nil {
+		return err
+	}
+
+	// Open the existing table stores.
+	infos, err := ioutil.ReadDir(s.TablesPath())
 	if err != nil {
-		panic(fmt.Sprintf("skyd.Servlet: Unable to open LevelDB database: %v", err))
+		return err
+	}
+	s.dbMutex.Lock()
+	s.dbs = make(map[string]*tableStore)
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		if _, err = s.openTableDB(info.Name()); err != nil {
+			s.dbMutex.Unlock()
+			s.Close()
+			return err
+		}
 	}
-	s.db = db
+	s.dbMutex.Unlock()
+
+	// Start the write queue.
+	s.writes = make(chan *servletWrite, servletWriteQueueSize)
+	s.writesDone = make(chan bool)
+	go s.writeLoop(s.writes, s.writesDone)
 
 	return nil
 }
 
-// Closes the underlying LevelDB database.
+// Closes the underlying data store.
 func (s *Servlet) Close() {
-	if s.db != nil {
-		s.db.Close()
+	// Stop accepting writes and flush anything still queued.
+	s.queueMutex.Lock()
+	if s.writes != nil {
+		close(s.writes)
+		<-s.writesDone
+		s.writes = nil
+	}
+	s.queueMutex.Unlock()
+
+	s.dbMutex.Lock()
+	defer s.dbMutex.Unlock()
+	for _, db := range s.dbs {
+		db.Close()
+	}
+	s.dbs = nil
+}
+
+//--------------------------------------
+// Table Stores
+//--------------------------------------
+
+// Retrieves the store for a table and adds a reference to it. The reference
+// must be released once the store is no longer used. Returns
+// errTableStoreNotFound if the table doesn't have a store on this servlet.
+func (s *Servlet) tableDB(name string) (*tableStore, error) {
+	s.dbMutex.RLock()
+	defer s.dbMutex.RUnlock()
+	if s.dbs == nil {
+		return nil, fmt.Errorf("Servlet is not open: %v", s.path)
+	}
+	if db := s.dbs[name]; db != nil && db.retain() {
+		return db, nil
+	}
+	return nil, errTableStoreNotFound
+}
+
+// Retrieves the store for a table that is being written to and adds a
+// reference to it. The store is created if the table doesn't have one on this
+// servlet yet but only while the table's schema exists so a dropped table is
+// never recreated.
+func (s *Servlet) createTableDB(table *Table) (*tableStore, error) {
+	if db, err := s.tableDB(table.Name); err != errTableStoreNotFound {
+		return db, err
+	}
+
+	s.dbMutex.Lock()
+	defer s.dbMutex.Unlock()
+	if s.dbs == nil {
+		return nil, fmt.Errorf("Servlet is not open: %v", s.path)
+	}
+	if db := s.dbs[table.Name]; db != nil && db.retain() {
+		return db, nil
+	}
+	if !table.Exists() {
+		return nil, fmt.Errorf("Table does not exist: %s", table.Name)
+	}
+	db, err := s.openTableDB(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	db.retain()
+	return db, nil
+}
+
+// Opens the store for a table and adds it to the servlet. The store mutex
+// must be held by the caller.
+func (s *Servlet) openTableDB(name string) (*tableStore, error) {
+	path := s.TablePath(name)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	db, err := s.storageEngine().Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("skyd.Servlet: Unable to open data store: %v", err)
+	}
+	s.dbs[name] = &tableStore{Storage: db, released: make(chan bool)}
+	return s.dbs[name], nil
+}
+
+// Retrieves the names of the tables that have a store on the servlet in
+// sorted order along with their stores. A reference is added to each store
+// and must be released by the caller.
+func (s *Servlet) tableDBs() ([]string, map[string]*tableStore, error) {
+	s.dbMutex.RLock()
+	defer s.dbMutex.RUnlock()
+	if s.dbs == nil {
+		return nil, nil, fmt.Errorf("Servlet is not open: %v", s.path)
+	}
+
+	names, dbs := make([]string, 0), make(map[string]*tableStore)
+	for name, db := range s.dbs {
+		if db.retain() {
+			names = append(names, name)
+			dbs[name] = db
+		}
+	}
+	sort.Strings(names)
+	return names, dbs, nil
+}
+
+// Releases the references to a list of stores.
+func releaseTableDBs(dbs map[string]*tableStore) {
+	for _, db := range dbs {
+		db.release()
+	}
+}
+
+// Closes and removes a table's store. Writes are only paused while the store
+// is closed and its directory is moved aside. The files are removed after
+// the servlet is unlocked.
+func (s *Servlet) DropTable(name string) error {
+	dropped, err := s.dropTableDB(name)
+	if err != nil || dropped == "" {
+		return err
+	}
+	return os.RemoveAll(dropped)
+}
+
+// Closes a table's store and moves its directory aside. The store is removed
+// from the servlet first and is only closed once every reference to it has
+// been released. Returns the path the directory was moved to or a blank path
+// if the table had no store.
+func (s *Servlet) dropTableDB(name string) (string, error) {
+	s.Lock()
+	s.dbMutex.Lock()
+	if s.dbs == nil {
+		s.dbMutex.Unlock()
+		s.Unlock()
+		return "", fmt.Errorf("Servlet is not open: %v", s.path)
+	}
+	db := s.dbs[name]
+	if db != nil {
+		db.drop()
+		delete(s.dbs, name)
+	}
+	s.dbMutex.Unlock()
+	s.Unlock()
+
+	// Wait for readers to finish before closing the store. The store mutex
+	// is held until the directory is moved so the store can't be reopened.
+	if db != nil {
+		<-db.released
+	}
+	s.dbMutex.Lock()
+	defer s.dbMutex.Unlock()
+	if db != nil {
+		db.Close()
+	}
+
+	path := s.TablePath(name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+	if err := os.MkdirAll(s.droppedPath(), 0700); err != nil {
+		return "", err
+	}
+	dropped := filepath.Join(s.droppedPath(), fmt.Sprintf("%s.%d", name, time.Now().UnixNano()))
+	if err := os.Rename(path, dropped); err != nil {
+		return "", err
 	}
+
+	// Let the engine release anything else it holds for the path.
+	if err := s.storageEngine().Remove(path); err != nil {
+		return "", err
+	}
+	return dropped, nil
+}
+
+// Adds a reference to the store. Returns false if the store has been dropped.
+func (t *tableStore) retain() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.dropped {
+		return false
+	}
+	t.refs++
+	return true
+}
+
+// Removes a reference to the store.
+func (t *tableStore) release() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.refs--
+	if t.dropped && t.refs == 0 {
+		close(t.released)
+	}
+}
+
+// Marks the store as dropped. No new references can be added and the
+// released channel is closed once the existing ones are released.
+func (t *tableStore) drop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.dropped = true
+	if t.refs == 0 {
+		close(t.released)
+	}
+}
+
+// Checks if the store has been dropped. Long running readers use this to
+// stop early so that they don't hold up the drop.
+func (t *tableStore) isDropped() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.dropped
 }
 
 //--------------------------------------
@@ -95,38 +383,160 @@ func (s *Servlet) Unlock() {
 // Event Management
 //--------------------------------------
 
-// Adds an event for a given object in a table to a servlet.
+// Adds an event for a given object in a table to a servlet. The event is
+// committed through the write queue and the call blocks until it is durable.
 func (s *Servlet) PutEvent(table *Table, objectId string, event *Event, replace bool) error {
+	return s.QueueEvent(table, objectId, event, replace, true)
+}
+
+// Queues an event for a given object in a table to be committed with other
+// pending writes. If sync is true then the call blocks until the event has
+// been durably written and returns any write error. Otherwise it returns as
+// soon as the event is queued.
+func (s *Servlet) QueueEvent(table *Table, objectId string, event *Event, replace bool, sync bool) error {
+	// Do not allow empty events to be added.
+	if event == nil {
+		return errors.New("skyd.QueueEvent: Cannot add nil event")
+	}
+
+	w := &servletWrite{table: table, objectId: objectId, event: event, replace: replace, sync: sync}
+	if sync {
+		w.done = make(chan error, 1)
+	}
+
+	// Make sure the servlet is open and add to the queue.
+	s.queueMutex.RLock()
+	if s.writes == nil {
+		s.queueMutex.RUnlock()
+		return fmt.Errorf("Servlet is not open: %v", s.path)
+	}
+	s.writes <- w
+	s.queueMutex.RUnlock()
+
+	// Wait for the commit if requested.
+	if sync {
+		return <-w.done
+	}
+	return nil
+}
+
+// Waits until every write queued before the call has been committed.
+func (s *Servlet) FlushWrites() error {
+	// A write without a table only marks its place in the queue.
+	w := &servletWrite{done: make(chan error, 1)}
+	s.queueMutex.RLock()
+	if s.writes == nil {
+		s.queueMutex.RUnlock()
+		return fmt.Errorf("Servlet is not open: %v", s.path)
+	}
+	s.writes <- w
+	s.queueMutex.RUnlock()
+
+	return <-w.done
+}
+
+// Adds multiple events for a given object in a table to a servlet using a
+// single read and write of the object's event stream.
+func (s *Servlet) PutEvents(table *Table, objectId string, events []*Event, replace bool) error {
 	s.Lock()
 	defer s.Unlock()
 
-	// Make sure the servlet is open.
-	if s.db == nil {
-		return fmt.Errorf("Servlet is not open: %v", s.path)
+	// Retrieve the table's store.
+	db, err := s.createTableDB(table)
+	if err != nil {
+		return err
 	}
+	defer db.release()
 
 	// Do not allow empty events to be added.
-	if event == nil {
-		return errors.New("skyd.PutEvent: Cannot add nil event")
+	writes := make([]*servletWrite, 0)
+	for _, event := range events {
+		if event == nil {
+			return errors.New("skyd.PutEvents: Cannot add nil event")
+		}
+		writes = append(writes, &servletWrite{table: table, objectId: objectId, event: event, replace: replace})
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+
+	// Merge the events into the existing stream.
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := s.mergeWrites(db, batch, table, objectId, writes); err != nil {
+		return err
+	}
+
+	return s.write(db, batch)
+}
+
+// Merges a list of writes for a single object into the object's existing
+// event stream and adds the changes to a batch. Writes that share a timestamp
+// are applied in order. The servlet must be locked by the caller.
+func (s *Servlet) mergeWrites(db Storage, batch StorageBatch, table *Table, objectId string, writes []*servletWrite) error {
+	tmp := make([]*servletWrite, len(writes))
+	copy(tmp, writes)
+	sort.Stable(servletWriteList(tmp))
+
+	// Collapse writes with the same timestamp into a single event.
+	events := make([]*Event, 0)
+	replace := make([]bool, 0)
+	for _, w := range tmp {
+		if n := len(events); n > 0 && events[n-1].Timestamp.Equal(w.event.Timestamp) {
+			if w.replace {
+				events[n-1] = w.event
+				replace[n-1] = true
+			} else {
+				events[n-1].Merge(w.event)
+			}
+		} else {
+			events = append(events, w.event)
+			replace = append(replace, w.replace)
+		}
+	}
+
+	// Encode object identifier.
+	objectKey, err := table.EncodeObjectId(objectId)
+	if err != nil {
+		return err
 	}
 
 	// Check the current state and perform an optimized append if possible.
-	state, data, err := s.GetState(table, objectId)
-	if state == nil || state.Timestamp.Before(event.Timestamp) {
-		return s.appendEvent(table, objectId, event, state, data)
+	state, data, err := s.getState(db, objectKey)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 && (state == nil || state.Timestamp.Before(events[0].Timestamp)) {
+		if state == nil {
+			state = &Event{Data: map[int64]interface{}{}}
+		}
+		for _, event := range events {
+			state.Timestamp = event.Timestamp
+			event.Dedupe(state)
+			state.MergePermanent(event)
+		}
+		return s.appendObject(db, batch, objectKey, events, state, table.IsCompressed())
 	}
 
-	// Retrieve the events and state for the object.
-	tmp, state, err := s.GetEvents(table, objectId)
+	// Otherwise apply each event to the full event stream.
+	existing, _, err := s.GetEvents(table, objectId)
 	if err != nil {
 		return err
 	}
+	for j, event := range events {
+		existing, state = applyEvent(existing, event, replace[j])
+	}
 
-	// Remove any event matching the timestamp.
+	return s.writeObject(db, batch, objectKey, existing, state, table.IsCompressed())
+}
+
+// Replaces or merges an event into a sorted list of events. Returns the new
+// list and the recomputed permanent state.
+func applyEvent(events []*Event, event *Event, replace bool) ([]*Event, *Event) {
 	found := false
-	state = &Event{Timestamp: event.Timestamp, Data: map[int64]interface{}{}}
-	events := make([]*Event, 0)
-	for _, v := range tmp {
+	state := &Event{Timestamp: event.Timestamp, Data: map[int64]interface{}{}}
+	ret := make([]*Event, 0, len(events)+1)
+	for _, v := range events {
 		// Replace or merge with existing event.
 		if v.Timestamp.Equal(event.Timestamp) {
 			// Dedupe all permanent state.
@@ -140,45 +550,21 @@ func (s *Servlet) PutEvent(table *Table, objectId string, event *Event, replace
 			}
 			found = true
 		}
-		events = append(events, v)
+		ret = append(ret, v)
 
 		// Keep track of permanent state.
 		state.MergePermanent(v)
 	}
+
 	// Add the event if it wasn't found.
 	if !found {
 		event.Dedupe(state)
-		events = append(events, event)
+		ret = append(ret, event)
 		state.MergePermanent(event)
+		sort.Sort(EventList(ret))
 	}
 
-	// Write events back to the database.
-	err = s.SetEvents(table, objectId, events, state)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// Appends an event for a given object in a table to a servlet. This should not
-// be called directly but only through PutEvent().
-func (s *Servlet) appendEvent(table *Table, objectId string, event *Event, state *Event, data []byte) error {
-	if state == nil {
-		state = &Event{Data: map[int64]interface{}{}}
-	}
-	state.Timestamp = event.Timestamp
-	event.Dedupe(state)
-	state.MergePermanent(event)
-
-	// Append new event.
-	buffer := bytes.NewBuffer(data)
-	if err := event.EncodeRaw(buffer); err != nil {
-		return err
-	}
-
-	// Write everything to the database.
-	return s.SetRawEvents(table, objectId, buffer.Bytes(), state)
+	return ret, state
 }
 
 // Retrieves an event for a given object at a single point in time.
@@ -201,13 +587,22 @@ func (s *Servlet) GetEvent(table *Table, objectId string, timestamp time.Time) (
 
 // Removes an event for a given object in a table to a servlet.
 func (s *Servlet) DeleteEvent(table *Table, objectId string, timestamp time.Time) error {
+	// Commit queued writes first so that they can't land after the delete.
+	if err := s.FlushWrites(); err != nil {
+		return err
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
-	// Make sure the servlet is open.
-	if s.db == nil {
-		return fmt.Errorf("Servlet is not open: %v", s.path)
+	// Nothing to remove if the table has no store.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return nil
+	} else if err != nil {
+		return err
 	}
+	defer db.release()
 
 	// Retrieve the events for the object and append.
 	tmp, _, err := s.GetEvents(table, objectId)
@@ -234,11 +629,16 @@ func (s *Servlet) DeleteEvent(table *Table, objectId string, timestamp time.Time
 }
 
 // Retrieves the state and the remaining serialized event stream for an object.
+// The event stream is read from each of the object's chunks in time order.
 func (s *Servlet) GetState(table *Table, objectId string) (*Event, []byte, error) {
-	// Make sure the servlet is open.
-	if s.db == nil {
-		return nil, nil, fmt.Errorf("Servlet is not open: %v", s.path)
+	// Retrieve the table's store. The object doesn't exist if there is none.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return nil, []byte{}, nil
+	} else if err != nil {
+		return nil, nil, err
 	}
+	defer db.release()
 
 	// Encode object identifier.
 	encodedObjectId, err := table.EncodeObjectId(objectId)
@@ -246,103 +646,127 @@ func (s *Servlet) GetState(table *Table, objectId string) (*Event, []byte, error
 		return nil, nil, err
 	}
 
-	// Retrieve byte array.
-	ro := levigo.NewReadOptions()
-	data, err := s.db.Get(ro, encodedObjectId)
-	ro.Close()
+	return s.getObject(db, encodedObjectId)
+}
+
+// Retrieves a list of events and the current state for a given object in a table.
+func (s *Servlet) GetEvents(table *Table, objectId string) ([]*Event, *Event, error) {
+	state, data, err := s.GetState(table, objectId)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Decode the events into a slice.
-	if data != nil {
-		reader := bytes.NewReader(data)
-
-		// The first item should be the current state wrapped in a raw value.
-		var raw interface{}
-		decoder := msgpack.NewDecoder(reader, nil)
-		if err := decoder.Decode(&raw); err != nil && err != io.EOF {
-			return nil, nil, err
-		}
-		if b, ok := raw.(string); ok {
-			state := &Event{}
-			if err = state.DecodeRaw(bytes.NewReader([]byte(b))); err == nil {
-				eventData, _ := ioutil.ReadAll(reader)
-				return state, eventData, nil
-			} else if err != io.EOF {
-				return nil, nil, err
-			}
-		} else {
-			return nil, nil, fmt.Errorf("skyd.Servlet: Invalid state: %v", raw)
-		}
+	events, err := decodeEvents(data)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil, []byte{}, nil
+	return events, state, nil
 }
 
-// Retrieves a list of events and the current state for a given object in a table.
-func (s *Servlet) GetEvents(table *Table, objectId string) ([]*Event, *Event, error) {
-	state, data, err := s.GetState(table, objectId)
+// Retrieves the events for an object that occurred within a time range. The
+// start is inclusive and the end is exclusive. A zero start or end leaves that
+// side of the range unbounded. Only the chunks overlapping the range are read.
+func (s *Servlet) GetEventRange(table *Table, objectId string, start time.Time, end time.Time) ([]*Event, error) {
+	// Retrieve the table's store. The object doesn't exist if there is none.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return []*Event{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	// Encode object identifier.
+	encodedObjectId, err := table.EncodeObjectId(objectId)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
+	data, err := s.getObjectRange(db, encodedObjectId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := decodeEvents(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove events outside of the range.
 	events := make([]*Event, 0)
-	if data != nil {
-		reader := bytes.NewReader(data)
-		for {
-			// Decode the event and append it to our list.
-			event := &Event{}
-			err = event.DecodeRaw(reader)
-			if err == io.EOF {
-				err = nil
-				break
-			}
-			if err != nil {
-				return nil, nil, err
-			}
+	for _, event := range tmp {
+		if inTimeRange(event.Timestamp, start, end) {
 			events = append(events, event)
 		}
 	}
 
-	return events, state, nil
+	return events, nil
 }
 
-// Writes a list of events for an object in table.
-func (s *Servlet) SetEvents(table *Table, objectId string, events []*Event, state *Event) error {
-	// Sort the events.
-	sort.Sort(EventList(events))
+// Removes the events for an object that occurred within a time range and
+// recomputes its permanent state. The start is inclusive and the end is
+// exclusive. A zero start or end leaves that side of the range unbounded.
+// Returns the number of events removed.
+func (s *Servlet) DeleteEventRange(table *Table, objectId string, start time.Time, end time.Time) (int, error) {
+	// Commit queued writes first so that they can't land after the delete.
+	if err := s.FlushWrites(); err != nil {
+		return 0, err
+	}
 
-	// Ensure state is correct before proceeding.
-	if len(events) > 0 {
-		if state != nil {
-			state.Timestamp = events[len(events)-1].Timestamp
-		} else {
-			return errors.New("skyd.Servlet: Missing state.")
-		}
-	} else {
-		state = nil
+	s.Lock()
+	defer s.Unlock()
+
+	// Nothing to remove if the table has no store.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
 	}
+	defer db.release()
 
-	// Encode the events.
-	buffer := new(bytes.Buffer)
-	for _, event := range events {
-		err := event.EncodeRaw(buffer)
-		if err != nil {
-			return err
+	// Retrieve the events for the object.
+	tmp, _, err := s.GetEvents(table, objectId)
+	if err != nil {
+		return 0, err
+	}
+
+	// Remove any event inside the range.
+	state := &Event{Data: map[int64]interface{}{}}
+	events := make([]*Event, 0)
+	for _, v := range tmp {
+		if !inTimeRange(v.Timestamp, start, end) {
+			events = append(events, v)
+			state.MergePermanent(v)
 		}
 	}
+	if len(events) == len(tmp) {
+		return 0, nil
+	}
+
+	// Write events back to the database.
+	err = s.SetEvents(table, objectId, events, state)
+	if err != nil {
+		return 0, err
+	}
 
-	// Set the raw bytes.
-	return s.SetRawEvents(table, objectId, buffer.Bytes(), state)
+	return len(tmp) - len(events), nil
+}
+
+// Checks if a timestamp is within a time range where a zero start or end is
+// unbounded. The start is inclusive and the end is exclusive.
+func inTimeRange(timestamp time.Time, start time.Time, end time.Time) bool {
+	return (start.IsZero() || !timestamp.Before(start)) && (end.IsZero() || timestamp.Before(end))
 }
 
 // Writes a list of events for an object in table.
-func (s *Servlet) SetRawEvents(table *Table, objectId string, data []byte, state *Event) error {
-	// Make sure the servlet is open.
-	if s.db == nil {
-		return fmt.Errorf("Servlet is not open: %v", s.path)
+func (s *Servlet) SetEvents(table *Table, objectId string, events []*Event, state *Event) error {
+	// Retrieve the table's store.
+	db, err := s.createTableDB(table)
+	if err != nil {
+		return err
 	}
+	defer db.release()
 
 	// Encode object identifier.
 	encodedObjectId, err := table.EncodeObjectId(objectId)
@@ -350,48 +774,89 @@ func (s *Servlet) SetRawEvents(table *Table, objectId string, data []byte, state
 		return err
 	}
 
-	// Encode the state at the beginning.
-	buffer := new(bytes.Buffer)
-	var b []byte
-	if state != nil {
-		if b, err = state.MarshalRaw(); err != nil {
-			return err
-		}
-	} else {
-		b = []byte{}
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := s.writeObject(db, batch, encodedObjectId, events, state, table.IsCompressed()); err != nil {
+		return err
 	}
-	b2, err := msgpack.Marshal(b)
+	return s.write(db, batch)
+}
+
+// Writes a serialized event stream for an object in table.
+func (s *Servlet) SetRawEvents(table *Table, objectId string, data []byte, state *Event) error {
+	events, err := decodeEvents(data)
 	if err != nil {
 		return err
 	}
-	buffer.Write(b2)
+	return s.SetEvents(table, objectId, events, state)
+}
 
-	// Encode the rest of the data.
-	buffer.Write(data)
+// Deletes all events for a given object in a table. Returns the number of
+// events that were deleted.
+func (s *Servlet) DeleteEvents(table *Table, objectId string) (int, error) {
+	// Commit queued writes first so that they can't land after the delete.
+	if err := s.FlushWrites(); err != nil {
+		return 0, err
+	}
 
-	// Write bytes to the database.
-	wo := levigo.NewWriteOptions()
-	defer wo.Close()
-	return s.db.Put(wo, encodedObjectId, buffer.Bytes())
+	s.Lock()
+	defer s.Unlock()
+	return s.deleteEvents(table, objectId)
 }
 
-// Deletes all events for a given object in a table.
-func (s *Servlet) DeleteEvents(table *Table, objectId string) error {
-	// Make sure the servlet is open.
-	if s.db == nil {
-		return fmt.Errorf("Servlet is not open: %v", s.path)
+// Deletes all events for a given object in a table. The servlet must be
+// locked by the caller.
+func (s *Servlet) deleteEvents(table *Table, objectId string) (int, error) {
+	// Retrieve the table's store. Nothing to delete if there is none.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
 	}
+	defer db.release()
 
 	// Encode object identifier.
 	encodedObjectId, err := table.EncodeObjectId(objectId)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	// Count the events being deleted.
+	_, data, err := s.getState(db, encodedObjectId)
+	if err != nil {
+		return 0, err
+	}
+	count, _, _, err := summarizeEvents(data)
+	if err != nil {
+		return 0, err
+	}
+	keys, values, err := s.getChunks(db, encodedObjectId)
+	if err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		n, _, _, err := summarizeEvents(value)
+		if err != nil {
+			return 0, err
+		}
+		count += n
 	}
 
-	// Delete object from the database.
-	wo := levigo.NewWriteOptions()
-	err = s.db.Delete(wo, encodedObjectId)
-	wo.Close()
+	// Delete the state and every chunk from the database.
+	batch := db.NewBatch()
+	defer batch.Close()
+	batch.Delete(encodedObjectId)
+	for _, key := range keys {
+		batch.Delete(key)
+	}
+	if err = s.write(db, batch); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
 
-	return nil
+// Writes a batch to a table's store.
+func (s *Servlet) write(db Storage, batch StorageBatch) error {
+	return db.Write(batch, false)
 }
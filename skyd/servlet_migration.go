@@ -0,0 +1,229 @@
+package skyd
+
+import (
+	"bytes"
+)
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Moves the stored values of a migrating property from its previous
+// identifier to its current one and converts them to its current data type.
+// Objects without values under the previous identifier are left alone so an
+// interrupted migration can safely be run again.
+func (s *Servlet) MigrateProperty(table *Table, property *Property, factors *Factors, status *PropertyMigrationStatus, stop chan bool) error {
+	if property.Migration == nil {
+		return nil
+	}
+	return s.eachObject(table, stop, func(objectKey []byte) error {
+		valueCount, droppedCount, err := s.migrateObject(table, property, factors, objectKey)
+		if err != nil {
+			return err
+		}
+		status.add(1, valueCount, droppedCount)
+		return nil
+	})
+}
+
+// Removes the stored values of a deleted property from every event and
+// recomputes the state of each object that changed.
+func (s *Servlet) PurgeProperty(table *Table, id int64, status *PropertyMigrationStatus, stop chan bool) error {
+	return s.eachObject(table, stop, func(objectKey []byte) error {
+		valueCount, err := s.purgeObject(table, id, objectKey)
+		if err != nil {
+			return err
+		}
+		status.add(1, valueCount, 0)
+		return nil
+	})
+}
+
+// Calls a function with the key of each object in a table. Objects are
+// processed one at a time so writes can continue in between. Returns
+// errPropertyMigrationStopped if stop is closed before all objects are done.
+func (s *Servlet) eachObject(table *Table, stop chan bool, fn func([]byte) error) error {
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer db.release()
+
+	// Determine table prefix.
+	prefix, err := TablePrefix(table.Name)
+	if err != nil {
+		return err
+	}
+
+	iterator := db.NewIterator()
+	defer iterator.Close()
+
+	// Find each object by its state key.
+	for iterator.Seek(prefix); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		sz, err := objectKeyLength(key)
+		if err != nil {
+			return err
+		}
+		if sz < len(key) {
+			continue
+		}
+
+		select {
+		case <-stop:
+			return errPropertyMigrationStopped
+		default:
+		}
+
+		// Stop early if the table is dropped.
+		if db.isDropped() {
+			return nil
+		}
+
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+
+	return iterator.GetError()
+}
+
+// Converts the values of a migrating property for a single object and
+// recomputes its state. Values written under the new identifier since the
+// migration started take precedence over converted ones. When a permanent
+// property becomes transient its values are copied onto each later event
+// that inherited them. When a transient property becomes permanent repeated
+// values are removed since they're now inherited. Returns the number of
+// values converted and dropped.
+func (s *Servlet) migrateObject(table *Table, property *Property, factors *Factors, objectKey []byte) (int, int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// The store may have been dropped since the object was found.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer db.release()
+
+	// Retrieve the events for the object.
+	_, data, err := s.getObject(db, objectKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	events, err := decodeEvents(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Move each value to the new identifier.
+	sourceId, id := property.Migration.SourceId, property.Id
+	found, valueCount, droppedCount := false, 0, 0
+	state := &Event{Data: map[int64]interface{}{}}
+	var inherited interface{}
+	for _, event := range events {
+		_, exists := event.Data[id]
+		if value, ok := event.Data[sourceId]; ok {
+			delete(event.Data, sourceId)
+			found = true
+			value, ok, err := property.Migration.convert(table.Name, property, factors, value)
+			if err != nil {
+				return 0, 0, err
+			}
+			if !ok {
+				droppedCount++
+			} else if !exists {
+				event.Data[id] = value
+				valueCount++
+			}
+			inherited = value
+		} else if sourceId > 0 && id < 0 && inherited != nil && !exists {
+			event.Data[id] = inherited
+		}
+
+		// Permanent values are only stored when they change.
+		if sourceId < 0 && id > 0 {
+			prev, inherits := state.Data[id]
+			if value, ok := event.Data[id]; ok && inherits && equalValues(prev, value) {
+				delete(event.Data, id)
+			}
+		}
+		state.MergePermanent(event)
+	}
+	if !found {
+		return 0, 0, nil
+	}
+
+	// Write events back to the database.
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err = s.writeObject(db, batch, objectKey, events, state, table.IsCompressed()); err != nil {
+		return 0, 0, err
+	}
+	if err = s.write(db, batch); err != nil {
+		return 0, 0, err
+	}
+
+	return valueCount, droppedCount, nil
+}
+
+// Removes the values of a deleted property for a single object and
+// recomputes its state. Returns the number of values removed.
+func (s *Servlet) purgeObject(table *Table, id int64, objectKey []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// The store may have been dropped since the object was found.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer db.release()
+
+	// Retrieve the events for the object.
+	_, data, err := s.getObject(db, objectKey)
+	if err != nil {
+		return 0, err
+	}
+	events, err := decodeEvents(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// Remove the value from each event.
+	valueCount := 0
+	state := &Event{Data: map[int64]interface{}{}}
+	for _, event := range events {
+		if _, ok := event.Data[id]; ok {
+			delete(event.Data, id)
+			valueCount++
+		}
+		state.MergePermanent(event)
+	}
+	if valueCount == 0 {
+		return 0, nil
+	}
+
+	// Write events back to the database.
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err = s.writeObject(db, batch, objectKey, events, state, table.IsCompressed()); err != nil {
+		return 0, err
+	}
+	if err = s.write(db, batch); err != nil {
+		return 0, err
+	}
+
+	return valueCount, nil
+}
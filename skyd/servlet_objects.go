@@ -0,0 +1,193 @@
+package skyd
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// An ObjectInfo summarizes the events stored for a single object.
+type ObjectInfo struct {
+	Id             string
+	EventCount     int
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Retrieves the current state of an object along with a summary of its
+// events. Returns nil if the object doesn't exist.
+func (s *Servlet) GetObject(table *Table, objectId string) (*Event, *ObjectInfo, error) {
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer db.release()
+
+	// Encode object identifier.
+	encodedObjectId, err := table.EncodeObjectId(objectId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, _, err := s.getState(db, encodedObjectId)
+	if err != nil || state == nil {
+		return nil, nil, err
+	}
+	info, err := s.getObjectInfo(db, encodedObjectId)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Id = objectId
+
+	return state, info, nil
+}
+
+// Reconstructs the permanent state of an object at a point in time by
+// replaying its events up to and including the timestamp. If transient is
+// true then the transient data of an event at exactly that time is included.
+// Returns nil if the object doesn't exist.
+func (s *Servlet) GetStateAt(table *Table, objectId string, timestamp time.Time, transient bool) (*Event, error) {
+	events, _, err := s.GetEvents(table, objectId)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+
+	state := &Event{Timestamp: timestamp, Data: map[int64]interface{}{}}
+	for _, event := range events {
+		if event.Timestamp.After(timestamp) {
+			break
+		}
+		state.MergePermanent(event)
+
+		// Add transient data from the event at the exact time.
+		if transient && event.Timestamp.Equal(timestamp) {
+			for k, v := range event.Data {
+				if k < 0 {
+					state.Data[k] = v
+				}
+			}
+		}
+	}
+
+	return state, nil
+}
+
+// Retrieves a summary for each object in a table in key order. Scanning
+// starts after the object identified by after, or at the beginning of the
+// table if after is blank. Only identifiers starting with prefix are returned
+// and at most limit objects are retrieved.
+func (s *Servlet) ScanObjects(table *Table, after string, prefix string, limit int) ([]*ObjectInfo, error) {
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return []*ObjectInfo{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	// Determine table prefix and the key to start from.
+	tablePrefix, err := TablePrefix(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	var start, afterKey []byte
+	if after != "" {
+		if afterKey, err = table.EncodeObjectId(after); err != nil {
+			return nil, err
+		}
+		start = afterKey
+	} else if start, err = table.EncodeObjectId(prefix); err != nil {
+		return nil, err
+	}
+
+	iterator := db.NewIterator()
+	defer iterator.Close()
+
+	infos := make([]*ObjectInfo, 0)
+	iterator.Seek(start)
+	for iterator.Valid() && len(infos) < limit {
+		key := iterator.Key()
+		if !bytes.HasPrefix(key, tablePrefix) {
+			break
+		}
+
+		// Skip the previous page's last object and any chunk keys.
+		if afterKey != nil && bytes.HasPrefix(key, afterKey) {
+			iterator.Next()
+			continue
+		}
+		sz, err := objectKeyLength(key)
+		if err != nil {
+			return nil, err
+		}
+		if sz < len(key) {
+			iterator.Next()
+			continue
+		}
+
+		// Jump ahead to the next key that can match the prefix.
+		objectId, err := decodeObjectId(key)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(objectId, prefix) {
+			next, err := table.EncodeObjectId(nextObjectIdWithPrefix(objectId, prefix))
+			if err != nil {
+				return nil, err
+			}
+			iterator.Seek(next)
+			continue
+		}
+
+		info, err := s.getObjectInfo(db, key)
+		if err != nil {
+			return nil, err
+		}
+		info.Id = objectId
+		infos = append(infos, info)
+		iterator.Next()
+	}
+
+	return infos, iterator.GetError()
+}
+
+// Finds the smallest identifier that starts with a prefix and sorts after an
+// identifier that doesn't. Encoded identifiers are ordered by length first
+// and then by content, so identifiers with the prefix are grouped by length.
+func nextObjectIdWithPrefix(objectId string, prefix string) string {
+	length := len(objectId)
+	if length < len(prefix) {
+		length = len(prefix)
+	} else if objectId[:len(prefix)] > prefix {
+		length++
+	}
+	return prefix + strings.Repeat("\x00", length-len(prefix))
+}
+
+// Summarizes the events for an object without decoding them.
+func (s *Servlet) getObjectInfo(db Storage, objectKey []byte) (*ObjectInfo, error) {
+	_, data, err := s.getObject(db, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ObjectInfo{}
+	if info.EventCount, info.FirstTimestamp, info.LastTimestamp, err = summarizeEvents(data); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
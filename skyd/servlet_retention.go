@@ -0,0 +1,143 @@
+package skyd
+
+import (
+	"bytes"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Removes all events in a table that occurred before a given cutoff and
+// recomputes the permanent state of each object that changed. Objects are
+// locked one at a time so writes can continue while the table is trimmed.
+// Returns the number of events removed and the number of objects that no
+// longer have any events.
+func (s *Servlet) Trim(table *Table, cutoff time.Time) (int, int, error) {
+	// Commit queued writes first so that expired events can't land after
+	// the trim.
+	if err := s.FlushWrites(); err != nil {
+		return 0, 0, err
+	}
+
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer db.release()
+
+	// Determine table prefix.
+	prefix, err := TablePrefix(table.Name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	iterator := db.NewIterator()
+	defer iterator.Close()
+
+	// Walk over each object in the table. Objects whose first chunk starts
+	// after the cutoff's chunk are skipped without being read.
+	eventCount, objectCount := 0, 0
+	trim := func(objectKey []byte) error {
+		n, purged, err := s.trimObject(table, objectKey, cutoff)
+		eventCount += n
+		if purged {
+			objectCount++
+		}
+		return err
+	}
+
+	var objectKey []byte
+	for iterator.Seek(prefix); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if !bytes.HasPrefix(key, prefix) || db.isDropped() {
+			break
+		}
+		sz, err := objectKeyLength(key)
+		if err != nil {
+			return eventCount, objectCount, err
+		}
+
+		// Check the first chunk of the current object.
+		if sz < len(key) {
+			if objectKey != nil && bytes.Compare(key, objectChunkKey(objectKey, cutoff)) <= 0 {
+				if err := trim(objectKey); err != nil {
+					return eventCount, objectCount, err
+				}
+			}
+			objectKey = nil
+			continue
+		}
+
+		// Objects without chunks may still have unchunked events.
+		if objectKey != nil {
+			if err := trim(objectKey); err != nil {
+				return eventCount, objectCount, err
+			}
+		}
+		objectKey = key
+	}
+	if objectKey != nil {
+		if err := trim(objectKey); err != nil {
+			return eventCount, objectCount, err
+		}
+	}
+
+	return eventCount, objectCount, nil
+}
+
+// Removes the events for a single object that occurred before a cutoff.
+// Returns the number of events removed and whether the object was removed.
+func (s *Servlet) trimObject(table *Table, objectKey []byte, cutoff time.Time) (int, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// The store may have been dropped since the object was found.
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	defer db.release()
+
+	// Retrieve the events for the object.
+	_, data, err := s.getObject(db, objectKey)
+	if err != nil {
+		return 0, false, err
+	}
+	tmp, err := decodeEvents(data)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Remove any event before the cutoff.
+	state := &Event{Data: map[int64]interface{}{}}
+	events := make([]*Event, 0)
+	for _, v := range tmp {
+		if !v.Timestamp.Before(cutoff) {
+			events = append(events, v)
+			state.MergePermanent(v)
+		}
+	}
+	if len(events) == len(tmp) {
+		return 0, false, nil
+	}
+
+	// Write events back to the database.
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err = s.writeObject(db, batch, objectKey, events, state, table.IsCompressed()); err != nil {
+		return 0, false, err
+	}
+	if err = s.write(db, batch); err != nil {
+		return 0, false, err
+	}
+
+	return len(tmp) - len(events), len(events) == 0, nil
+}
@@ -0,0 +1,136 @@
+package skyd
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// ServletStats reports the storage used by a servlet.
+type ServletStats struct {
+	Index  int                    `json:"index"`
+	Path   string                 `json:"path"`
+	Tables map[string]*TableStats `json:"tables"`
+}
+
+// TableStats reports the storage used by a table's store within a servlet.
+// The object count is only reported when requested.
+type TableStats struct {
+	Stats           string `json:"stats,omitempty"`
+	ApproximateSize uint64 `json:"approximateSize"`
+	ObjectCount     int    `json:"objectCount,omitempty"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Generates the first key after every key that starts with a prefix. Returns
+// nil if there is no such key.
+func prefixLimit(prefix []byte) []byte {
+	limit := make([]byte, len(prefix))
+	copy(limit, prefix)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Retrieves storage statistics and the approximate size of each of a list of
+// tables. Sizes are only reported by stores that support maintenance. Counting
+// objects reads every key in the store so it is only done when requested.
+func (s *Servlet) Stats(tables []*Table, count bool) (*ServletStats, error) {
+	stats := &ServletStats{
+		Path:   s.path,
+		Tables: make(map[string]*TableStats),
+	}
+	for _, table := range tables {
+		tableStats, err := s.tableStats(table, count)
+		if err != nil {
+			return nil, err
+		}
+		stats.Tables[table.Name] = tableStats
+	}
+
+	return stats, nil
+}
+
+// Retrieves the statistics for a single table's store.
+func (s *Servlet) tableStats(table *Table, count bool) (*TableStats, error) {
+	db, err := s.tableDB(table.Name)
+	if err == errTableStoreNotFound {
+		return &TableStats{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	stats := &TableStats{}
+	if maintainer, ok := db.Storage.(StorageMaintainer); ok {
+		prefix, err := TablePrefix(table.Name)
+		if err != nil {
+			return nil, err
+		}
+		stats.Stats = maintainer.Stats()
+		stats.ApproximateSize = maintainer.ApproximateSize(prefix, prefixLimit(prefix))
+	}
+	if count {
+		if stats.ObjectCount, err = s.countObjects(db); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// Counts the number of objects in a table's store. Counting stops early if
+// the table is dropped.
+func (s *Servlet) countObjects(db *tableStore) (int, error) {
+	iterator := db.NewIterator()
+	defer iterator.Close()
+
+	count := 0
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		if db.isDropped() {
+			break
+		}
+		sz, err := objectKeyLength(iterator.Key())
+		if err != nil {
+			return count, err
+		}
+		if sz == len(iterator.Key()) {
+			count++
+		}
+	}
+
+	return count, iterator.GetError()
+}
+
+// Compacts a table's store. A blank name compacts the store of every table on
+// the servlet. Stores that don't support compaction are left alone.
+func (s *Servlet) Compact(name string) error {
+	names, dbs, err := s.tableDBs()
+	if err != nil {
+		return err
+	}
+	defer releaseTableDBs(dbs)
+	for _, n := range names {
+		if name != "" && n != name {
+			continue
+		}
+		if maintainer, ok := dbs[n].Storage.(StorageMaintainer); ok {
+			maintainer.Compact(nil, nil)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,436 @@
+package skyd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ugorji/go-msgpack"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The span of time covered by a single chunk of an object's events.
+const objectChunkDuration = 7 * 24 * time.Hour
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Keys
+//--------------------------------------
+
+// Each object is stored as a state key followed by one key per chunk of
+// events. The state key is the encoded object identifier and holds the
+// object's permanent state. Objects written before chunking was introduced
+// also have their full event stream after the state. Chunk keys append the
+// big-endian chunk index to the state key so that they sort by time directly
+// after the state.
+
+// Generates the key for the chunk that contains a given timestamp.
+func objectChunkKey(objectKey []byte, timestamp time.Time) []byte {
+	seconds := int64(objectChunkDuration / time.Second)
+	index := timestamp.Unix() / seconds
+	if timestamp.Unix()%seconds < 0 {
+		index -= 1
+	}
+
+	// Flip the sign bit so that negative indices sort before positive ones.
+	key := make([]byte, len(objectKey)+8)
+	copy(key, objectKey)
+	binary.BigEndian.PutUint64(key[len(objectKey):], uint64(index)^(1<<63))
+	return key
+}
+
+// Determines the length of the encoded object identifier at the beginning
+// of a state or chunk key.
+func objectKeyLength(key []byte) (int, error) {
+	_, end, err := objectIdRange(key)
+	return end, err
+}
+
+// Extracts the object identifier from a state or chunk key.
+func decodeObjectId(key []byte) (string, error) {
+	start, end, err := objectIdRange(key)
+	if err != nil {
+		return "", err
+	}
+	return string(key[start:end]), nil
+}
+
+// Extracts the table name and object identifier from a state or chunk key.
+func decodeObjectKey(key []byte) (string, string, error) {
+	ranges, err := objectKeyRanges(key)
+	if err != nil {
+		return "", "", err
+	}
+	return string(key[ranges[0][0]:ranges[0][1]]), string(key[ranges[1][0]:ranges[1][1]]), nil
+}
+
+// Determines the offsets of the object identifier's bytes within a key.
+func objectIdRange(key []byte) (int, int, error) {
+	ranges, err := objectKeyRanges(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ranges[1][0], ranges[1][1], nil
+}
+
+// Determines the offsets of the table name's bytes and the object
+// identifier's bytes within a key.
+func objectKeyRanges(key []byte) ([2][2]int, error) {
+	var ranges [2][2]int
+	if len(key) == 0 || key[0] != 0x92 {
+		return ranges, fmt.Errorf("skyd: Invalid object key: %x", key)
+	}
+
+	// Skip over the table name and then the object identifier.
+	index := 1
+	for i := 0; i < 2; i++ {
+		if index >= len(key) {
+			return ranges, fmt.Errorf("skyd: Invalid object key: %x", key)
+		}
+		var sz, hdr int
+		switch b := key[index]; {
+		case b >= 0xa0 && b <= 0xbf:
+			hdr, sz = 1, int(b&0x1f)
+		case b == 0xd9 && index+2 <= len(key):
+			hdr, sz = 2, int(key[index+1])
+		case b == 0xda && index+3 <= len(key):
+			hdr, sz = 3, int(binary.BigEndian.Uint16(key[index+1:]))
+		case b == 0xdb && index+5 <= len(key):
+			hdr, sz = 5, int(binary.BigEndian.Uint32(key[index+1:]))
+		default:
+			return ranges, fmt.Errorf("skyd: Invalid object key: %x", key)
+		}
+		ranges[i] = [2]int{index + hdr, index + hdr + sz}
+		index += hdr + sz
+	}
+	if index > len(key) {
+		return ranges, fmt.Errorf("skyd: Invalid object key: %x", key)
+	}
+
+	return ranges, nil
+}
+
+//--------------------------------------
+// Encoding
+//--------------------------------------
+
+// Decodes a serialized event stream into a list of events.
+func decodeEvents(data []byte) ([]*Event, error) {
+	events := make([]*Event, 0)
+	if data != nil {
+		reader := bytes.NewReader(data)
+		for {
+			// Decode the event and append it to our list.
+			event := &Event{}
+			err := event.DecodeRaw(reader)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Counts the events in a serialized event stream and finds the timestamps of
+// the first and last events. Event data is skipped over without decoding it.
+func summarizeEvents(data []byte) (int, time.Time, time.Time, error) {
+	var first, last time.Time
+	count := 0
+	for index := 0; index < len(data); count++ {
+		if data[index] != 0x92 {
+			return 0, first, last, fmt.Errorf("skyd: Invalid event at offset %d", index)
+		}
+		timestamp, sz, err := msgpackInt(data[index+1:])
+		if err != nil {
+			return 0, first, last, err
+		}
+		if count == 0 {
+			first = UnshiftTime(timestamp).UTC()
+		}
+		last = UnshiftTime(timestamp).UTC()
+
+		// Skip over the timestamp and the event data.
+		index += 1 + sz
+		if sz, err = msgpackSize(data[index:]); err != nil {
+			return 0, first, last, err
+		}
+		index += sz
+	}
+	return count, first, last, nil
+}
+
+// Encodes the state followed by a serialized event stream.
+func encodeRawEvents(data []byte, state *Event) ([]byte, error) {
+	// Encode the state at the beginning.
+	buffer := new(bytes.Buffer)
+	var b []byte
+	var err error
+	if state != nil {
+		if b, err = state.MarshalRaw(); err != nil {
+			return nil, err
+		}
+	} else {
+		b = []byte{}
+	}
+	b2, err := msgpack.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	buffer.Write(b2)
+
+	// Encode the rest of the data.
+	buffer.Write(data)
+
+	return buffer.Bytes(), nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Object Storage
+//--------------------------------------
+
+// Retrieves the state for an object along with any events that are stored
+// with the state in the unchunked layout.
+func (s *Servlet) getState(db Storage, objectKey []byte) (*Event, []byte, error) {
+	// Retrieve byte array.
+	data, err := db.Get(objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data, err = decodeValue(data); err != nil {
+		return nil, nil, err
+	}
+
+	// Decode the events into a slice.
+	if data != nil {
+		reader := bytes.NewReader(data)
+
+		// The first item should be the current state wrapped in a raw value.
+		var raw interface{}
+		decoder := msgpack.NewDecoder(reader, nil)
+		if err := decoder.Decode(&raw); err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if b, ok := raw.(string); ok {
+			state := &Event{}
+			if err = state.DecodeRaw(bytes.NewReader([]byte(b))); err == nil {
+				eventData, _ := ioutil.ReadAll(reader)
+				return state, eventData, nil
+			} else if err != io.EOF {
+				return nil, nil, err
+			}
+		} else {
+			return nil, nil, fmt.Errorf("skyd.Servlet: Invalid state: %v", raw)
+		}
+	}
+
+	return nil, []byte{}, nil
+}
+
+// Retrieves the state and the full serialized event stream for an object.
+// Any unchunked events are followed by each chunk in time order.
+func (s *Servlet) getObject(db Storage, objectKey []byte) (*Event, []byte, error) {
+	state, data, err := s.getState(db, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, values, err := s.getChunks(db, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, value := range values {
+		data = append(data, value...)
+	}
+	return state, data, nil
+}
+
+// Retrieves the serialized events for an object from the chunks that may
+// contain events in a time range. A zero start or end leaves that side of
+// the range unbounded. Events outside the range may still be returned.
+func (s *Servlet) getObjectRange(db Storage, objectKey []byte, start time.Time, end time.Time) ([]byte, error) {
+	_, data, err := s.getState(db, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator := db.NewIterator()
+	defer iterator.Close()
+
+	// Seek to the first chunk in the range and read until the last.
+	seek := objectKey
+	if !start.IsZero() {
+		seek = objectChunkKey(objectKey, start)
+	}
+	var last []byte
+	if !end.IsZero() {
+		last = objectChunkKey(objectKey, end)
+	}
+	for iterator.Seek(seek); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if !bytes.HasPrefix(key, objectKey) || (last != nil && bytes.Compare(key, last) > 0) {
+			break
+		}
+		if len(key) > len(objectKey) {
+			value, err := decodeValue(iterator.Value())
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, value...)
+		}
+	}
+
+	return data, nil
+}
+
+// Retrieves the keys and decompressed values of every chunk for an object in
+// time order.
+func (s *Servlet) getChunks(db Storage, objectKey []byte) ([][]byte, [][]byte, error) {
+	keys, values := make([][]byte, 0), make([][]byte, 0)
+
+	iterator := db.NewIterator()
+	defer iterator.Close()
+
+	for iterator.Seek(objectKey); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if !bytes.HasPrefix(key, objectKey) {
+			break
+		}
+		if len(key) > len(objectKey) {
+			value, err := decodeValue(iterator.Value())
+			if err != nil {
+				return nil, nil, fmt.Errorf("skyd.Servlet: Unable to decompress chunk %x: %v", key, err)
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+	}
+
+	return keys, values, nil
+}
+
+// Adds the writes needed to store a full list of events for an object to a
+// batch. Only chunks whose contents change are rewritten and chunks that no
+// longer contain events are removed. If compress is set then the values that
+// are written are compressed. The servlet must be locked by the caller.
+func (s *Servlet) writeObject(db Storage, batch StorageBatch, objectKey []byte, events []*Event, state *Event, compress bool) error {
+	// Sort the events.
+	sort.Sort(EventList(events))
+
+	// Ensure state is correct before proceeding.
+	if len(events) > 0 {
+		if state != nil {
+			state.Timestamp = events[len(events)-1].Timestamp
+		} else {
+			return errors.New("skyd.Servlet: Missing state.")
+		}
+	}
+
+	// Remove the object entirely if there are no events.
+	keys, values, err := s.getChunks(db, objectKey)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		batch.Delete(objectKey)
+		for _, key := range keys {
+			batch.Delete(key)
+		}
+		return nil
+	}
+
+	// Encode the events into chunks.
+	chunks := make(map[string]*bytes.Buffer)
+	for _, event := range events {
+		key := string(objectChunkKey(objectKey, event.Timestamp))
+		if chunks[key] == nil {
+			chunks[key] = new(bytes.Buffer)
+		}
+		if err := event.EncodeRaw(chunks[key]); err != nil {
+			return err
+		}
+	}
+
+	// Write chunks that changed and remove chunks that are now empty.
+	for i, key := range keys {
+		if buffer := chunks[string(key)]; buffer == nil {
+			batch.Delete(key)
+		} else if bytes.Equal(buffer.Bytes(), values[i]) {
+			delete(chunks, string(key))
+		}
+	}
+	for key, buffer := range chunks {
+		batch.Put([]byte(key), encodeValue(buffer.Bytes(), compress))
+	}
+
+	// Write the state by itself.
+	value, err := encodeRawEvents(nil, state)
+	if err != nil {
+		return err
+	}
+	batch.Put(objectKey, encodeValue(value, compress))
+
+	return nil
+}
+
+// Adds the writes needed to append events to the end of an object's event
+// stream to a batch. Only the chunks receiving events are rewritten. The
+// servlet must be locked by the caller.
+func (s *Servlet) appendObject(db Storage, batch StorageBatch, objectKey []byte, events []*Event, state *Event, compress bool) error {
+	// Encode the events into the chunks they belong to.
+	keys := make([]string, 0)
+	chunks := make(map[string]*bytes.Buffer)
+	for _, event := range events {
+		key := string(objectChunkKey(objectKey, event.Timestamp))
+		if chunks[key] == nil {
+			keys = append(keys, key)
+			chunks[key] = new(bytes.Buffer)
+		}
+		if err := event.EncodeRaw(chunks[key]); err != nil {
+			return err
+		}
+	}
+
+	// Append to any existing chunk data.
+	for _, key := range keys {
+		data, err := db.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if data, err = decodeValue(data); err != nil {
+			return err
+		}
+		batch.Put([]byte(key), encodeValue(append(data, chunks[key].Bytes()...), compress))
+	}
+
+	// Write the state.
+	value, err := encodeRawEvents(nil, state)
+	if err != nil {
+		return err
+	}
+	batch.Put(objectKey, encodeValue(value, compress))
+
+	return nil
+}
@@ -1,9 +1,14 @@
 package skyd
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Ensure that we can open and close a servlet.
@@ -19,12 +24,39 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+// Ensure that we can open a servlet with tuned database options.
+func TestOpenWithOptions(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+
+	options := NewDatabaseOptions()
+	options.CacheSize = 1024 * 1024
+	options.Compression = false
+	options.ParanoidChecks = true
+	options.open()
+	defer options.close()
+
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	servlet.engine = NewLevelDBEngine(options)
+	defer servlet.Close()
+	if err = servlet.Open(); err != nil {
+		t.Fatalf("Unable to open servlet: %v", err)
+	}
+	if err = servlet.PutEvent(table, "bob", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true); err != nil {
+		t.Fatalf("Unable to add event: %v", err)
+	}
+	if events, _, err := servlet.GetEvents(table, "bob"); err != nil || len(events) != 1 {
+		t.Fatalf("Unexpected events: %v (%v)", events, err)
+	}
+}
+
 // Ensure that we can add events and read them back.
 func TestServletPutEvent(t *testing.T) {
 	// Setup blank database.
 	path, err := ioutil.TempDir("", "")
 	defer os.RemoveAll(path)
-	table := NewTable("test", "/tmp/test")
+	table := createTempNamedTable(t, "test")
 	servlet := NewServlet(path, nil)
 	defer servlet.Close()
 	_ = servlet.Open()
@@ -67,3 +99,666 @@ func TestServletPutEvent(t *testing.T) {
 		}
 	}
 }
+
+// Ensure that we can add a batch of events for an object at once.
+func TestServletPutEvents(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	// Add an existing event and then a batch around it.
+	err = servlet.PutEvent(table, "bob", NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-1: 20, 1: "foo"}), true)
+	if err != nil {
+		t.Fatalf("Unable to add event: %v", err)
+	}
+	input := make([]*Event, 3)
+	input[0] = NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{-1: 30, 1: "foo"})
+	input[1] = NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{2: "bar"})
+	input[2] = NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-2: 10})
+	err = servlet.PutEvents(table, "bob", input, false)
+	if err != nil {
+		t.Fatalf("Unable to add events: %v", err)
+	}
+
+	// Setup expected events.
+	expected := make([]*Event, 3)
+	expected[0] = NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{2: "bar"})
+	expected[1] = NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-1: 20, -2: 10, 1: "foo"})
+	expected[2] = NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{-1: 30})
+	expectedState := NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{1: "foo", 2: "bar"})
+
+	// Read events out.
+	output, state, err := servlet.GetEvents(table, "bob")
+	if err != nil {
+		t.Fatalf("Unable to retrieve events: %v", err)
+	}
+	if !expectedState.Equal(state) {
+		t.Fatalf("Incorrect state.\nexp: %v\ngot: %v", expectedState, state)
+	}
+	if len(output) != len(expected) {
+		t.Fatalf("Expected %v events, received %v", len(expected), len(output))
+	}
+	for i := range output {
+		if !expected[i].Equal(output[i]) {
+			t.Fatalf("Events not equal:\n  IN:  %v\n  OUT: %v", expected[i], output[i])
+		}
+	}
+}
+
+// Ensure that concurrent writes to the same object are committed together.
+func TestServletQueueEvent(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	// Write events concurrently and wait for each to be durable.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := NewEvent(fmt.Sprintf("2012-01-01T00:%02d:%02dZ", i/60, i%60), map[int64]interface{}{-1: i})
+			if err := servlet.QueueEvent(table, "bob", e, true, true); err != nil {
+				t.Errorf("Unable to queue event: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	output, _, err := servlet.GetEvents(table, "bob")
+	if err != nil {
+		t.Fatalf("Unable to retrieve events: %v", err)
+	}
+	if len(output) != 100 {
+		t.Fatalf("Expected %v events, received %v", 100, len(output))
+	}
+	for i := 1; i < len(output); i++ {
+		if !output[i-1].Timestamp.Before(output[i].Timestamp) {
+			t.Fatalf("Events out of order: %v, %v", output[i-1], output[i])
+		}
+	}
+}
+
+// Ensure that fire-and-forget writes are flushed when the servlet closes.
+func TestServletQueueEventAsync(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	_ = servlet.Open()
+
+	servlet.QueueEvent(table, "bob", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true, false)
+	servlet.QueueEvent(table, "bob", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{-1: 10}), false, false)
+	servlet.Close()
+
+	// Reopen and check the merged event.
+	servlet = NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+	output, _, err := servlet.GetEvents(table, "bob")
+	if err != nil {
+		t.Fatalf("Unable to retrieve events: %v", err)
+	}
+	expected := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{-1: 10, 1: "foo"})
+	if len(output) != 1 || !expected.Equal(output[0]) {
+		t.Fatalf("Unexpected events: %v", output)
+	}
+}
+
+// Ensure that flushing waits for fire-and-forget writes to be committed.
+func TestServletFlushWrites(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	for i := 0; i < 100; i++ {
+		servlet.QueueEvent(table, fmt.Sprintf("obj%d", i), NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true, false)
+	}
+	if err := servlet.FlushWrites(); err != nil {
+		t.Fatalf("Unable to flush writes: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if events, _, err := servlet.GetEvents(table, fmt.Sprintf("obj%d", i)); err != nil || len(events) != 1 {
+			t.Fatalf("Unexpected events for obj%d: %v (%v)", i, events, err)
+		}
+	}
+}
+
+// Ensure that queued writes are committed before events are deleted.
+func TestServletDeleteQueuedWrites(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	for i := 0; i < 100; i++ {
+		servlet.QueueEvent(table, fmt.Sprintf("obj%d", i), NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true, false)
+		if count, err := servlet.DeleteEvents(table, fmt.Sprintf("obj%d", i)); err != nil || count != 1 {
+			t.Fatalf("Unable to delete events: %v (%v)", count, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if events, _, err := servlet.GetEvents(table, fmt.Sprintf("obj%d", i)); err != nil || len(events) != 0 {
+			t.Fatalf("Unexpected events for obj%d: %v (%v)", i, events, err)
+		}
+	}
+}
+
+// Ensure that events spanning multiple chunks are stored separately and read
+// back in order.
+func TestServletChunks(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	input := []*Event{
+		NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{-1: 10}),
+		NewEvent("2012-03-01T00:00:00Z", map[int64]interface{}{-1: 30}),
+		NewEvent("2012-02-01T00:00:00Z", map[int64]interface{}{-1: 20}),
+	}
+	for _, e := range input {
+		if err = servlet.PutEvent(table, "bob", e, true); err != nil {
+			t.Fatalf("Unable to add event: %v", err)
+		}
+	}
+
+	// Each event should be in its own chunk.
+	key, _ := table.EncodeObjectId("bob")
+	db, _ := servlet.tableDB(table.Name)
+	defer db.release()
+	keys, _, _ := servlet.getChunks(db, key)
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 chunks, received %v", len(keys))
+	}
+
+	output, _, err := servlet.GetEvents(table, "bob")
+	if err != nil {
+		t.Fatalf("Unable to retrieve events: %v", err)
+	}
+	if len(output) != 3 || !output[0].Equal(input[0]) || !output[1].Equal(input[2]) || !output[2].Equal(input[1]) {
+		t.Fatalf("Unexpected events: %v", output)
+	}
+
+	// Deleting an event should remove its chunk.
+	if err = servlet.DeleteEvent(table, "bob", input[2].Timestamp); err != nil {
+		t.Fatalf("Unable to delete event: %v", err)
+	}
+	if keys, _, _ = servlet.getChunks(db, key); len(keys) != 2 {
+		t.Fatalf("Expected 2 chunks, received %v", len(keys))
+	}
+}
+
+// Ensure that objects stored before chunking are readable and are migrated
+// on their next write.
+func TestServletLegacyObject(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	// Write the object using the single value layout.
+	event := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"})
+	state := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"})
+	buffer := new(bytes.Buffer)
+	event.EncodeRaw(buffer)
+	value, _ := encodeRawEvents(buffer.Bytes(), state)
+	key, _ := table.EncodeObjectId("bob")
+	db, _ := servlet.tableDB(table.Name)
+	defer db.release()
+	db.Put(key, value)
+
+	output, _, err := servlet.GetEvents(table, "bob")
+	if err != nil || len(output) != 1 || !output[0].Equal(event) {
+		t.Fatalf("Unexpected events: %v (%v)", output, err)
+	}
+
+	// Add another event and check that the object was migrated.
+	if err = servlet.PutEvent(table, "bob", NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: "bar"}), true); err != nil {
+		t.Fatalf("Unable to add event: %v", err)
+	}
+	_, data, _ := servlet.getState(db, key)
+	if len(data) != 0 {
+		t.Fatalf("Expected object to be migrated, found %v bytes of events", len(data))
+	}
+	output, state, err = servlet.GetEvents(table, "bob")
+	if err != nil || len(output) != 2 {
+		t.Fatalf("Unexpected events: %v (%v)", output, err)
+	}
+	if expected := NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: "bar"}); !state.Equal(expected) {
+		t.Fatalf("Incorrect state.\nexp: %v\ngot: %v", expected, state)
+	}
+}
+
+// Ensure that events before a cutoff are removed and state is recomputed.
+func TestServletTrim(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true)
+	servlet.PutEvent(table, "bob", NewEvent("2012-03-01T00:00:00Z", map[int64]interface{}{2: "bar"}), true)
+	servlet.PutEvent(table, "susy", NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: "baz"}), true)
+	servlet.PutEvent(table, "tim", NewEvent("2012-04-01T00:00:00Z", map[int64]interface{}{1: "bat"}), true)
+
+	eventCount, objectCount, err := servlet.Trim(table, NewEvent("2012-02-01T00:00:00Z", nil).Timestamp)
+	if err != nil {
+		t.Fatalf("Unable to trim: %v", err)
+	}
+	if eventCount != 2 || objectCount != 1 {
+		t.Fatalf("Unexpected trim counts: %v events, %v objects", eventCount, objectCount)
+	}
+
+	output, state, _ := servlet.GetEvents(table, "bob")
+	expectedState := NewEvent("2012-03-01T00:00:00Z", map[int64]interface{}{2: "bar"})
+	if len(output) != 1 || !expectedState.Equal(state) {
+		t.Fatalf("Unexpected events: %v (state: %v)", output, state)
+	}
+	if output, _, _ = servlet.GetEvents(table, "susy"); len(output) != 0 {
+		t.Fatalf("Expected object to be removed: %v", output)
+	}
+	if output, _, _ = servlet.GetEvents(table, "tim"); len(output) != 1 {
+		t.Fatalf("Expected object to be untouched: %v", output)
+	}
+}
+
+// Ensure that objects can be scanned in pages.
+func TestServletScanObjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	servlet.PutEvent(table, "a1", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true)
+	servlet.PutEvent(table, "a1", NewEvent("2012-03-01T00:00:00Z", map[int64]interface{}{1: "bar"}), true)
+	servlet.PutEvent(table, "a2", NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: "baz"}), true)
+	servlet.PutEvent(table, "b1", NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{1: "bat"}), true)
+	servlet.PutEvent(createTempNamedTable(t, "other"), "a3", NewEvent("2012-01-03T00:00:00Z", nil), true)
+
+	infos, err := servlet.ScanObjects(table, "", "", 2)
+	if err != nil || len(infos) != 2 || infos[0].Id != "a1" || infos[1].Id != "a2" {
+		t.Fatalf("Unexpected objects: %v (%v)", infos, err)
+	}
+	if infos[0].EventCount != 2 || !infos[0].FirstTimestamp.Equal(NewEvent("2012-01-01T00:00:00Z", nil).Timestamp) || !infos[0].LastTimestamp.Equal(NewEvent("2012-03-01T00:00:00Z", nil).Timestamp) {
+		t.Fatalf("Unexpected summary: %v", infos[0])
+	}
+	if infos, _ = servlet.ScanObjects(table, "a2", "", 2); len(infos) != 1 || infos[0].Id != "b1" {
+		t.Fatalf("Unexpected objects: %v", infos)
+	}
+	if infos, _ = servlet.ScanObjects(table, "", "a", 10); len(infos) != 2 {
+		t.Fatalf("Unexpected objects: %v", infos)
+	}
+}
+
+// Ensure that prefix scans find identifiers of every length.
+func TestServletScanObjectsPrefix(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	for _, objectId := range []string{"a", "b", "ab", "ba", "bb", "abc", "bab", "ca", "cab", "cabbage", "zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz"} {
+		servlet.PutEvent(table, objectId, NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "foo"}), true)
+	}
+
+	ids := func(infos []*ObjectInfo) []string {
+		ret := make([]string, 0)
+		for _, info := range infos {
+			ret = append(ret, info.Id)
+		}
+		return ret
+	}
+	if infos, err := servlet.ScanObjects(table, "", "ab", 10); err != nil || fmt.Sprint(ids(infos)) != "[ab abc]" {
+		t.Fatalf("Unexpected objects: %v (%v)", ids(infos), err)
+	}
+	if infos, err := servlet.ScanObjects(table, "", "ca", 10); err != nil || fmt.Sprint(ids(infos)) != "[ca cab cabbage]" {
+		t.Fatalf("Unexpected objects: %v (%v)", ids(infos), err)
+	}
+	if infos, err := servlet.ScanObjects(table, "ca", "ca", 1); err != nil || fmt.Sprint(ids(infos)) != "[cab]" {
+		t.Fatalf("Unexpected objects: %v (%v)", ids(infos), err)
+	}
+	if infos, err := servlet.ScanObjects(table, "", "d", 10); err != nil || len(infos) != 0 {
+		t.Fatalf("Unexpected objects: %v (%v)", ids(infos), err)
+	}
+}
+
+// Ensure that servlet statistics count the objects in each table.
+func TestServletStats(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	foo, bar := createTempNamedTable(t, "foo"), createTempNamedTable(t, "bar")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	servlet.PutEvent(foo, "a", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x"}), true)
+	servlet.PutEvent(foo, "a", NewEvent("2012-03-01T00:00:00Z", map[int64]interface{}{1: "y"}), true)
+	servlet.PutEvent(foo, "b", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x"}), true)
+	servlet.PutEvent(bar, "a", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x"}), true)
+
+	stats, err := servlet.Stats([]*Table{foo, bar}, true)
+	if err != nil {
+		t.Fatalf("Unable to retrieve stats: %v", err)
+	}
+	if stats.Tables["foo"].ObjectCount != 2 || stats.Tables["bar"].ObjectCount != 1 {
+		t.Fatalf("Unexpected object counts: %v, %v", stats.Tables["foo"], stats.Tables["bar"])
+	}
+	if stats, err = servlet.Stats([]*Table{foo}, false); err != nil || stats.Tables["foo"].ObjectCount != 0 {
+		t.Fatalf("Unexpected uncounted stats: %v (%v)", stats, err)
+	}
+	if err = servlet.Compact(""); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+}
+
+// Ensure that compressed and uncompressed objects can be read together.
+func TestServletCompression(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "test")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	// Write one object before compression is enabled and one after.
+	value := strings.Repeat("foo", 100)
+	input := []*Event{
+		NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: value}),
+		NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: value + "bar"}),
+	}
+	for _, e := range input {
+		if err = servlet.PutEvent(table, "bob", e, true); err != nil {
+			t.Fatalf("Unable to add event: %v", err)
+		}
+	}
+	table.Compression = true
+	for _, e := range input {
+		if err = servlet.PutEvent(table, "susy", e, true); err != nil {
+			t.Fatalf("Unable to add event: %v", err)
+		}
+	}
+
+	db, _ := servlet.tableDB(table.Name)
+	defer db.release()
+	bobKey, _ := table.EncodeObjectId("bob")
+	if data, _ := db.Get(bobKey); data[0] == compressionMarker {
+		t.Fatalf("Expected uncompressed state.")
+	}
+	susyKey, _ := table.EncodeObjectId("susy")
+	if data, _ := db.Get(susyKey); data[0] != compressionMarker {
+		t.Fatalf("Expected compressed state.")
+	}
+
+	for _, objectId := range []string{"bob", "susy"} {
+		output, state, err := servlet.GetEvents(table, objectId)
+		if err != nil || len(output) != 2 || !output[0].Equal(input[0]) || !output[1].Equal(input[1]) {
+			t.Fatalf("Unexpected events for %v: %v (%v)", objectId, output, err)
+		}
+		if expected := NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: value + "bar"}); !state.Equal(expected) {
+			t.Fatalf("Unexpected state for %v: %v", objectId, state)
+		}
+	}
+}
+
+// Ensure that dropping a table removes its store without affecting others.
+func TestServletDropTable(t *testing.T) {
+	path, err := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	foo, bar := createTempNamedTable(t, "foo"), createTempNamedTable(t, "bar")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+
+	event := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x"})
+	servlet.PutEvent(foo, "a", event, true)
+	servlet.PutEvent(bar, "a", event, true)
+
+	if err = servlet.DropTable("foo"); err != nil {
+		t.Fatalf("Unable to drop table: %v", err)
+	}
+	if _, err = os.Stat(servlet.TablePath("foo")); !os.IsNotExist(err) {
+		t.Fatalf("Expected table store to be removed: %v", err)
+	}
+	if events, _, err := servlet.GetEvents(foo, "a"); err != nil || len(events) != 0 {
+		t.Fatalf("Unexpected events after drop: %v (%v)", events, err)
+	}
+	if events, _, err := servlet.GetEvents(bar, "a"); err != nil || len(events) != 1 {
+		t.Fatalf("Unexpected events for other table: %v (%v)", events, err)
+	}
+
+	// Reads and writes after the schema is gone must not recreate the store.
+	foo.Delete()
+	if stats, err := servlet.Stats([]*Table{foo}, true); err != nil || stats.Tables["foo"].ObjectCount != 0 {
+		t.Fatalf("Unexpected stats after drop: %v (%v)", stats, err)
+	}
+	if infos, err := servlet.ScanObjects(foo, "", "", 10); err != nil || len(infos) != 0 {
+		t.Fatalf("Unexpected objects after drop: %v (%v)", infos, err)
+	}
+	if err = servlet.PutEvent(foo, "a", event, true); err == nil || err.Error() != "Table does not exist: foo" {
+		t.Fatalf("Expected write to dropped table to fail: %v", err)
+	}
+	if _, err = os.Stat(servlet.TablePath("foo")); !os.IsNotExist(err) {
+		t.Fatalf("Expected table store to stay removed: %v", err)
+	}
+}
+
+// Ensure that a dropped table's store isn't closed while it's being read.
+func TestServletDropTableWhileReading(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	table := createTempNamedTable(t, "foo")
+	servlet := NewServlet(path, nil)
+	defer servlet.Close()
+	_ = servlet.Open()
+	servlet.PutEvent(table, "a", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "x"}), true)
+
+	db, _ := servlet.tableDB("foo")
+	iterator := db.NewIterator()
+	dropped := make(chan error)
+	go func() {
+		dropped <- servlet.DropTable("foo")
+	}()
+
+	// The store is hidden from new readers but stays open for the iterator.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-dropped:
+		t.Fatalf("Expected drop to wait for the reader: %v", err)
+	default:
+	}
+	if _, err := servlet.tableDB("foo"); err != errTableStoreNotFound {
+		t.Fatalf("Expected dropped store to be hidden: %v", err)
+	}
+	if iterator.SeekToFirst(); !iterator.Valid() {
+		t.Fatalf("Expected iterator to still read the store.")
+	}
+	iterator.Close()
+	db.release()
+
+	if err := <-dropped; err != nil {
+		t.Fatalf("Unable to drop table: %v", err)
+	}
+	if _, err := os.Stat(servlet.TablePath("foo")); !os.IsNotExist(err) {
+		t.Fatalf("Expected table store to be removed: %v", err)
+	}
+}
+
+// Ensure that a property's values are moved to its new identifier and
+// converted to its new data type.
+func TestServletMigrateProperty(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	factors := NewFactors(path + "/factors")
+	factors.Open()
+	defer factors.Close()
+	servlet := NewServlet(path+"/0", factors)
+	servlet.Open()
+	defer servlet.Close()
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	property, _ := table.CreateProperty("bar", true, "string")
+	table.CreateProperty("baz", false, "integer")
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{-1: "foo", 1: 10}), true)
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-1: "bar"}), true)
+	servlet.PutEvent(table, "susy", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: 20}), true)
+
+	// Change the transient string property into a permanent factor.
+	property, err := table.ChangeProperty(property, "factor", false)
+	if err != nil {
+		t.Fatalf("Unable to change property: %v", err)
+	}
+	assertProperty(t, property, 2, "bar", false, "factor")
+	status := &PropertyMigrationStatus{}
+	for i := 0; i < 2; i++ {
+		if err = servlet.MigrateProperty(table, property, factors, status, nil); err != nil {
+			t.Fatalf("Unable to migrate property: %v", err)
+		}
+	}
+	if status.ProcessedCount != 4 || status.ValueCount != 2 || status.DroppedCount != 0 {
+		t.Fatalf("Unexpected status: %v", status.copy())
+	}
+
+	events, state, _ := servlet.GetEvents(table, "bob")
+	if len(events) != 2 || !events[0].Equal(NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{2: uint64(1), 1: 10})) || !events[1].Equal(NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{2: uint64(2)})) {
+		t.Fatalf("Unexpected events: %v", events)
+	}
+	if !state.Equal(NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{1: 10, 2: uint64(2)})) {
+		t.Fatalf("Unexpected state: %v", state)
+	}
+	if value, _ := factors.Defactorize("test", "bar", 2); value != "bar" {
+		t.Fatalf("Unexpected factor: %v", value)
+	}
+}
+
+// Ensure that switching a property between permanent and transient keeps the
+// value that each event had.
+func TestServletMigratePropertyTransience(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	factors := NewFactors(path + "/factors")
+	factors.Open()
+	defer factors.Close()
+	servlet := NewServlet(path+"/0", factors)
+	servlet.Open()
+	defer servlet.Close()
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	property, _ := table.CreateProperty("bar", false, "string")
+	table.CreateProperty("baz", true, "string")
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "a"}), true)
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-1: "x"}), true)
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{1: "b"}), true)
+	servlet.PutEvent(table, "bob", NewEvent("2012-01-04T00:00:00Z", map[int64]interface{}{1: "b", -1: "y"}), true)
+
+	// Permanent values are copied onto the events that inherited them.
+	property, _ = table.ChangeProperty(property, "string", true)
+	assertProperty(t, property, -2, "bar", true, "string")
+	if err := servlet.MigrateProperty(table, property, factors, &PropertyMigrationStatus{}, nil); err != nil {
+		t.Fatalf("Unable to migrate property: %v", err)
+	}
+	property, _ = table.CompletePropertyMigration(property)
+	events, state, _ := servlet.GetEvents(table, "bob")
+	expected := []*Event{
+		NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{-2: "a"}),
+		NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-1: "x", -2: "a"}),
+		NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{-2: "b"}),
+		NewEvent("2012-01-04T00:00:00Z", map[int64]interface{}{-1: "y", -2: "b"}),
+	}
+	assertEvents(t, events, expected)
+	if len(state.Data) != 0 {
+		t.Fatalf("Unexpected state: %v", state)
+	}
+
+	// Transient values are only kept where they change. The previous
+	// permanent identifier was released so it is reused.
+	property, _ = table.ChangeProperty(property, "string", false)
+	assertProperty(t, property, 1, "bar", false, "string")
+	if err := servlet.MigrateProperty(table, property, factors, &PropertyMigrationStatus{}, nil); err != nil {
+		t.Fatalf("Unable to migrate property: %v", err)
+	}
+	events, state, _ = servlet.GetEvents(table, "bob")
+	expected = []*Event{
+		NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "a"}),
+		NewEvent("2012-01-02T00:00:00Z", map[int64]interface{}{-1: "x"}),
+		NewEvent("2012-01-03T00:00:00Z", map[int64]interface{}{1: "b"}),
+		NewEvent("2012-01-04T00:00:00Z", map[int64]interface{}{-1: "y"}),
+	}
+	assertEvents(t, events, expected)
+	if len(state.Data) != 1 || !equalValues(state.Data[1], "b") {
+		t.Fatalf("Unexpected state: %v", state)
+	}
+}
+
+// Ensure that values written under a property's new identifier while its
+// values are migrating aren't overwritten by converted values.
+func TestServletMigratePropertyConcurrentWrites(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	factors := NewFactors(path + "/factors")
+	factors.Open()
+	defer factors.Close()
+	servlet := NewServlet(path+"/0", factors)
+	servlet.Open()
+	defer servlet.Close()
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	property, _ := table.CreateProperty("bar", false, "string")
+	for i := 0; i < 100; i++ {
+		servlet.PutEvent(table, fmt.Sprintf("obj%d", i), NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "old"}), true)
+	}
+	property, _ = table.ChangeProperty(property, "factor", false)
+	value, _ := factors.Factorize(table.Name, "bar", "new", true)
+
+	// Write the first object before the migration and the rest during it.
+	event := func() *Event { return NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{2: value}) }
+	servlet.PutEvent(table, "obj0", event(), false)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < 100; i++ {
+			if err := servlet.PutEvent(table, fmt.Sprintf("obj%d", i), event(), false); err != nil {
+				t.Errorf("Unable to add event: %v", err)
+			}
+		}
+	}()
+	if err := servlet.MigrateProperty(table, property, factors, &PropertyMigrationStatus{}, nil); err != nil {
+		t.Fatalf("Unable to migrate property: %v", err)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		events, state, _ := servlet.GetEvents(table, fmt.Sprintf("obj%d", i))
+		if len(events) != 1 || !events[0].Equal(event()) || !equalValues(state.Data[2], value) || state.Data[1] != nil {
+			t.Fatalf("Unexpected events for obj%d: %v (%v)", i, events, state)
+		}
+	}
+}
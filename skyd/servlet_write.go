@@ -0,0 +1,169 @@
+package skyd
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	// The number of writes that can be waiting on a servlet before callers block.
+	servletWriteQueueSize = 4096
+
+	// The maximum number of writes committed in a single batch.
+	servletWriteBatchSize = 1000
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A servletWrite is a single event waiting in a servlet's write queue.
+type servletWrite struct {
+	table    *Table
+	objectId string
+	event    *Event
+	replace  bool
+	sync     bool
+	err      error
+	done     chan error
+}
+
+// A slice of writes sortable by event timestamp.
+type servletWriteList []*servletWrite
+
+// Determines the length of a write slice.
+func (s servletWriteList) Len() int {
+	return len(s)
+}
+
+// Compares the event timestamps of two writes.
+func (s servletWriteList) Less(i, j int) bool {
+	return s[i].event.Timestamp.Before(s[j].event.Timestamp)
+}
+
+// Swaps two writes in a write slice.
+func (s servletWriteList) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Reads writes off the queue and commits them in batches until the queue is
+// closed. Any writes already waiting when a batch starts are coalesced into it.
+func (s *Servlet) writeLoop(writes chan *servletWrite, done chan bool) {
+	for w := range writes {
+		batch := []*servletWrite{w}
+
+	drain:
+		for len(batch) < servletWriteBatchSize {
+			select {
+			case w, ok := <-writes:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+
+		s.commit(batch)
+	}
+	close(done)
+}
+
+// Commits a batch of writes with a single write to each table's store. Writes
+// to the same object are merged so that each object is only rewritten once.
+func (s *Servlet) commit(writes []*servletWrite) {
+	// Group writes by object.
+	keys := make([]string, 0)
+	groups := make(map[string][]*servletWrite)
+	for _, w := range writes {
+		if w.table == nil {
+			continue
+		}
+		key, err := w.table.EncodeObjectId(w.objectId)
+		if err != nil {
+			w.err = err
+			continue
+		}
+		if groups[string(key)] == nil {
+			keys = append(keys, string(key))
+		}
+		groups[string(key)] = append(groups[string(key)], w)
+	}
+
+	s.commitGroups(keys, groups)
+
+	// Notify waiting callers and report errors for everyone else.
+	for _, w := range writes {
+		if w.done != nil {
+			w.done <- w.err
+		} else if w.err != nil {
+			warn("skyd.Servlet: Unable to write event: %v", w.err)
+		}
+	}
+}
+
+// Merges each group of writes into its object and writes the objects for
+// each table in a single batch. Errors are set on the writes they affect.
+func (s *Servlet) commitGroups(keys []string, groups map[string][]*servletWrite) {
+	s.Lock()
+	defer s.Unlock()
+
+	names := make([]string, 0)
+	dbs := make(map[string]*tableStore)
+	batches := make(map[string]StorageBatch)
+	committed := make(map[string][]*servletWrite)
+	defer func() {
+		for _, batch := range batches {
+			batch.Close()
+		}
+	}()
+
+	for _, key := range keys {
+		group := groups[key]
+		name := group[0].table.Name
+		if dbs[name] == nil {
+			db, err := s.createTableDB(group[0].table)
+			if err != nil {
+				setWriteErrors(group, err)
+				continue
+			}
+			defer db.release()
+			names = append(names, name)
+			dbs[name], batches[name] = db, db.NewBatch()
+		}
+
+		if err := s.mergeWrites(dbs[name], batches[name], group[0].table, group[0].objectId, group); err != nil {
+			setWriteErrors(group, err)
+			continue
+		}
+		committed[name] = append(committed[name], group...)
+	}
+
+	for _, name := range names {
+		// Only fsync if someone is waiting on durability.
+		sync := false
+		for _, w := range committed[name] {
+			sync = sync || w.sync
+		}
+		if err := dbs[name].Write(batches[name], sync); err != nil {
+			setWriteErrors(committed[name], err)
+		}
+	}
+}
+
+// Sets an error on each write in a list.
+func setWriteErrors(writes []*servletWrite, err error) {
+	for _, w := range writes {
+		w.err = err
+	}
+}
@@ -0,0 +1,72 @@
+package skyd
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A StorageEngine opens the key/value stores used for servlet shards and the
+// factors database.
+type StorageEngine interface {
+	// Opens the store at a given path, creating it if it doesn't exist.
+	Open(path string) (Storage, error)
+
+	// Removes the store at a given path. The store must be closed.
+	Remove(path string) error
+}
+
+// A Storage is an ordered key/value store. Keys are sorted bytewise.
+type Storage interface {
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	NewBatch() StorageBatch
+	Write(batch StorageBatch, sync bool) error
+	NewIterator() StorageIterator
+	NewSnapshot() StorageSnapshot
+	Close()
+}
+
+// A StorageBatch collects writes that are applied to a store atomically. A
+// batch can only be written to the store that created it.
+type StorageBatch interface {
+	Put(key []byte, value []byte)
+	Delete(key []byte)
+	Clear()
+	Close()
+}
+
+// A StorageIterator walks over the keys of a store in order. Iterators see a
+// consistent view of the store as of the time they were created.
+type StorageIterator interface {
+	Seek(key []byte)
+	SeekToFirst()
+	Valid() bool
+	Next()
+	Key() []byte
+	Value() []byte
+	GetError() error
+	Close()
+}
+
+// A StorageSnapshot is a read-only view of a store at a point in time.
+type StorageSnapshot interface {
+	Get(key []byte) ([]byte, error)
+	NewIterator() StorageIterator
+	Release()
+}
+
+// A StorageMaintainer is a store that can report statistics about itself and
+// be compacted. Stores are not required to support maintenance.
+type StorageMaintainer interface {
+	// Retrieves a human readable description of the store's internals.
+	Stats() string
+
+	// Estimates the number of bytes used by the keys in a range. A nil limit
+	// leaves the end of the range unbounded.
+	ApproximateSize(start []byte, limit []byte) uint64
+
+	// Compacts the keys in a range. A nil start and limit compact everything.
+	Compact(start []byte, limit []byte)
+}
@@ -0,0 +1,208 @@
+package skyd
+
+import (
+	"errors"
+	"github.com/jmhodges/levigo"
+	"os"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A levelDBEngine opens LevelDB databases with a shared set of options.
+type levelDBEngine struct {
+	options *DatabaseOptions
+}
+
+// A levelDBStorage is a store backed by a LevelDB database on disk.
+type levelDBStorage struct {
+	db     *levigo.DB
+	ro     *levigo.ReadOptions
+	wo     *levigo.WriteOptions
+	syncWo *levigo.WriteOptions
+}
+
+// A levelDBBatch wraps a LevelDB write batch.
+type levelDBBatch struct {
+	batch *levigo.WriteBatch
+}
+
+// A levelDBSnapshot wraps a LevelDB snapshot along with the read options
+// used to read from it.
+type levelDBSnapshot struct {
+	db       *levigo.DB
+	snapshot *levigo.Snapshot
+	ro       *levigo.ReadOptions
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructors
+//
+//------------------------------------------------------------------------------
+
+// NewLevelDBEngine returns a storage engine that stores data in LevelDB. Nil
+// options use the LevelDB defaults.
+func NewLevelDBEngine(options *DatabaseOptions) StorageEngine {
+	return &levelDBEngine{options: options}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Engine
+//--------------------------------------
+
+// Opens a LevelDB database.
+func (e *levelDBEngine) Open(path string) (Storage, error) {
+	opts := e.options.levigoOptions()
+	defer opts.Close()
+	db, err := levigo.Open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	syncWo := levigo.NewWriteOptions()
+	syncWo.SetSync(true)
+	return &levelDBStorage{
+		db:     db,
+		ro:     levigo.NewReadOptions(),
+		wo:     levigo.NewWriteOptions(),
+		syncWo: syncWo,
+	}, nil
+}
+
+// Removes a LevelDB database's directory.
+func (e *levelDBEngine) Remove(path string) error {
+	return os.RemoveAll(path)
+}
+
+//--------------------------------------
+// Storage
+//--------------------------------------
+
+// Retrieves the value for a key. Returns nil if the key doesn't exist.
+func (s *levelDBStorage) Get(key []byte) ([]byte, error) {
+	return s.db.Get(s.ro, key)
+}
+
+// Sets the value for a key.
+func (s *levelDBStorage) Put(key []byte, value []byte) error {
+	return s.db.Put(s.wo, key, value)
+}
+
+// Removes a key.
+func (s *levelDBStorage) Delete(key []byte) error {
+	return s.db.Delete(s.wo, key)
+}
+
+// Creates a new write batch.
+func (s *levelDBStorage) NewBatch() StorageBatch {
+	return &levelDBBatch{batch: levigo.NewWriteBatch()}
+}
+
+// Applies a batch of writes. If sync is set then the write is flushed to disk
+// before returning.
+func (s *levelDBStorage) Write(batch StorageBatch, sync bool) error {
+	b, ok := batch.(*levelDBBatch)
+	if !ok {
+		return errors.New("skyd: Batch does not belong to a LevelDB database.")
+	}
+	if sync {
+		return s.db.Write(s.syncWo, b.batch)
+	}
+	return s.db.Write(s.wo, b.batch)
+}
+
+// Creates an iterator over the database.
+func (s *levelDBStorage) NewIterator() StorageIterator {
+	return s.db.NewIterator(s.ro)
+}
+
+// Creates a snapshot of the database.
+func (s *levelDBStorage) NewSnapshot() StorageSnapshot {
+	snapshot := s.db.NewSnapshot()
+	ro := levigo.NewReadOptions()
+	ro.SetSnapshot(snapshot)
+	ro.SetFillCache(false)
+	return &levelDBSnapshot{db: s.db, snapshot: snapshot, ro: ro}
+}
+
+// Closes the database.
+func (s *levelDBStorage) Close() {
+	s.ro.Close()
+	s.wo.Close()
+	s.syncWo.Close()
+	s.db.Close()
+}
+
+//--------------------------------------
+// Maintenance
+//--------------------------------------
+
+// Retrieves LevelDB's internal statistics.
+func (s *levelDBStorage) Stats() string {
+	return s.db.PropertyValue("leveldb.stats")
+}
+
+// Estimates the size on disk of a range of keys.
+func (s *levelDBStorage) ApproximateSize(start []byte, limit []byte) uint64 {
+	sizes := s.db.GetApproximateSizes([]levigo.Range{{Start: start, Limit: limit}})
+	return sizes[0]
+}
+
+// Compacts a range of keys.
+func (s *levelDBStorage) Compact(start []byte, limit []byte) {
+	s.db.CompactRange(levigo.Range{Start: start, Limit: limit})
+}
+
+//--------------------------------------
+// Batch
+//--------------------------------------
+
+// Adds a put to the batch.
+func (b *levelDBBatch) Put(key []byte, value []byte) {
+	b.batch.Put(key, value)
+}
+
+// Adds a delete to the batch.
+func (b *levelDBBatch) Delete(key []byte) {
+	b.batch.Delete(key)
+}
+
+// Removes all writes from the batch.
+func (b *levelDBBatch) Clear() {
+	b.batch.Clear()
+}
+
+// Releases the batch.
+func (b *levelDBBatch) Close() {
+	b.batch.Close()
+}
+
+//--------------------------------------
+// Snapshot
+//--------------------------------------
+
+// Retrieves the value for a key as of the snapshot.
+func (s *levelDBSnapshot) Get(key []byte) ([]byte, error) {
+	return s.db.Get(s.ro, key)
+}
+
+// Creates an iterator over the snapshot.
+func (s *levelDBSnapshot) NewIterator() StorageIterator {
+	return s.db.NewIterator(s.ro)
+}
+
+// Releases the snapshot.
+func (s *levelDBSnapshot) Release() {
+	s.ro.Close()
+	s.db.ReleaseSnapshot(s.snapshot)
+}
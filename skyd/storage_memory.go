@@ -0,0 +1,406 @@
+package skyd
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A memoryEngine keeps stores in memory. Stores live as long as the engine so
+// reopening a path returns the data that was previously written to it.
+type memoryEngine struct {
+	stores map[string]*memoryStorage
+	mutex  sync.Mutex
+}
+
+// A memoryStorage is an in-memory store. Keys are held in an immutable treap
+// so that iterators and snapshots can share the tree without copying it.
+type memoryStorage struct {
+	root  *memoryNode
+	mutex sync.RWMutex
+}
+
+// A memoryNode is a single key in a treap. Nodes are never modified once they
+// are part of a tree.
+type memoryNode struct {
+	key      []byte
+	value    []byte
+	priority int64
+	left     *memoryNode
+	right    *memoryNode
+}
+
+// A memoryBatch is a list of writes waiting to be applied.
+type memoryBatch struct {
+	writes []*memoryWrite
+}
+
+// A memoryWrite is a single put or delete within a batch. A nil value is a
+// delete.
+type memoryWrite struct {
+	key   []byte
+	value []byte
+}
+
+// A memorySnapshot is a read-only view of a treap.
+type memorySnapshot struct {
+	root *memoryNode
+}
+
+// A memoryIterator walks over the keys of a treap in order.
+type memoryIterator struct {
+	root *memoryNode
+	node *memoryNode
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructors
+//
+//------------------------------------------------------------------------------
+
+// NewMemoryEngine returns a storage engine that keeps all data in memory.
+func NewMemoryEngine() StorageEngine {
+	return &memoryEngine{stores: make(map[string]*memoryStorage)}
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Copies a byte slice so that callers can't modify stored data.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// Finds the node for a key.
+func memoryGet(n *memoryNode, key []byte) *memoryNode {
+	for n != nil {
+		switch c := bytes.Compare(key, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+// Finds the node with the smallest key that is greater than or equal to a key.
+// If inclusive is false then the key must be strictly greater.
+func memoryCeiling(n *memoryNode, key []byte, inclusive bool) *memoryNode {
+	var match *memoryNode
+	for n != nil {
+		c := bytes.Compare(n.key, key)
+		if c > 0 || (inclusive && c == 0) {
+			match, n = n, n.left
+		} else {
+			n = n.right
+		}
+	}
+	return match
+}
+
+// Returns a new tree with a key set to a value.
+func memoryInsert(n *memoryNode, key []byte, value []byte, priority int64) *memoryNode {
+	if n == nil {
+		return &memoryNode{key: key, value: value, priority: priority}
+	}
+
+	clone := *n
+	switch c := bytes.Compare(key, n.key); {
+	case c < 0:
+		clone.left = memoryInsert(n.left, key, value, priority)
+		if clone.left.priority > clone.priority {
+			return memoryRotateRight(&clone)
+		}
+	case c > 0:
+		clone.right = memoryInsert(n.right, key, value, priority)
+		if clone.right.priority > clone.priority {
+			return memoryRotateLeft(&clone)
+		}
+	default:
+		clone.value = value
+	}
+	return &clone
+}
+
+// Returns a new tree without a key.
+func memoryRemove(n *memoryNode, key []byte) *memoryNode {
+	if n == nil {
+		return nil
+	}
+
+	switch c := bytes.Compare(key, n.key); {
+	case c < 0:
+		left := memoryRemove(n.left, key)
+		if left == n.left {
+			return n
+		}
+		clone := *n
+		clone.left = left
+		return &clone
+	case c > 0:
+		right := memoryRemove(n.right, key)
+		if right == n.right {
+			return n
+		}
+		clone := *n
+		clone.right = right
+		return &clone
+	}
+	return memoryJoin(n.left, n.right)
+}
+
+// Joins two trees where every key in the left tree is less than every key in
+// the right tree.
+func memoryJoin(left *memoryNode, right *memoryNode) *memoryNode {
+	if left == nil {
+		return right
+	} else if right == nil {
+		return left
+	}
+
+	if left.priority > right.priority {
+		clone := *left
+		clone.right = memoryJoin(left.right, right)
+		return &clone
+	}
+	clone := *right
+	clone.left = memoryJoin(left, right.left)
+	return &clone
+}
+
+// Rotates a newly copied node to the right.
+func memoryRotateRight(n *memoryNode) *memoryNode {
+	left := *n.left
+	n.left, left.right = left.right, n
+	return &left
+}
+
+// Rotates a newly copied node to the left.
+func memoryRotateLeft(n *memoryNode) *memoryNode {
+	right := *n.right
+	n.right, right.left = right.left, n
+	return &right
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Engine
+//--------------------------------------
+
+// Opens the store for a path, creating it if it doesn't exist.
+func (e *memoryEngine) Open(path string) (Storage, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	store := e.stores[path]
+	if store == nil {
+		store = &memoryStorage{}
+		e.stores[path] = store
+	}
+	return store, nil
+}
+
+// Discards the store for a path.
+func (e *memoryEngine) Remove(path string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.stores, path)
+	return nil
+}
+
+//--------------------------------------
+// Storage
+//--------------------------------------
+
+// Retrieves the value for a key. Returns nil if the key doesn't exist.
+func (s *memoryStorage) Get(key []byte) ([]byte, error) {
+	s.mutex.RLock()
+	root := s.root
+	s.mutex.RUnlock()
+
+	if n := memoryGet(root, key); n != nil {
+		return copyBytes(n.value), nil
+	}
+	return nil, nil
+}
+
+// Sets the value for a key.
+func (s *memoryStorage) Put(key []byte, value []byte) error {
+	batch := s.NewBatch()
+	batch.Put(key, value)
+	return s.Write(batch, false)
+}
+
+// Removes a key.
+func (s *memoryStorage) Delete(key []byte) error {
+	batch := s.NewBatch()
+	batch.Delete(key)
+	return s.Write(batch, false)
+}
+
+// Creates a new write batch.
+func (s *memoryStorage) NewBatch() StorageBatch {
+	return &memoryBatch{}
+}
+
+// Applies a batch of writes atomically.
+func (s *memoryStorage) Write(batch StorageBatch, sync bool) error {
+	b, ok := batch.(*memoryBatch)
+	if !ok {
+		return errors.New("skyd: Batch does not belong to an in-memory store.")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	root := s.root
+	for _, w := range b.writes {
+		if w.value != nil {
+			root = memoryInsert(root, w.key, w.value, rand.Int63())
+		} else {
+			root = memoryRemove(root, w.key)
+		}
+	}
+	s.root = root
+
+	return nil
+}
+
+// Creates an iterator over the current contents of the store.
+func (s *memoryStorage) NewIterator() StorageIterator {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return &memoryIterator{root: s.root}
+}
+
+// Creates a snapshot of the store.
+func (s *memoryStorage) NewSnapshot() StorageSnapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return &memorySnapshot{root: s.root}
+}
+
+// Closing an in-memory store has no effect. Its data stays in the engine.
+func (s *memoryStorage) Close() {
+}
+
+//--------------------------------------
+// Batch
+//--------------------------------------
+
+// Adds a put to the batch.
+func (b *memoryBatch) Put(key []byte, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
+	b.writes = append(b.writes, &memoryWrite{key: copyBytes(key), value: copyBytes(value)})
+}
+
+// Adds a delete to the batch.
+func (b *memoryBatch) Delete(key []byte) {
+	b.writes = append(b.writes, &memoryWrite{key: copyBytes(key)})
+}
+
+// Removes all writes from the batch.
+func (b *memoryBatch) Clear() {
+	b.writes = nil
+}
+
+// Releases the batch.
+func (b *memoryBatch) Close() {
+	b.writes = nil
+}
+
+//--------------------------------------
+// Snapshot
+//--------------------------------------
+
+// Retrieves the value for a key as of the snapshot.
+func (s *memorySnapshot) Get(key []byte) ([]byte, error) {
+	if n := memoryGet(s.root, key); n != nil {
+		return copyBytes(n.value), nil
+	}
+	return nil, nil
+}
+
+// Creates an iterator over the snapshot.
+func (s *memorySnapshot) NewIterator() StorageIterator {
+	return &memoryIterator{root: s.root}
+}
+
+// Releasing an in-memory snapshot has no effect.
+func (s *memorySnapshot) Release() {
+}
+
+//--------------------------------------
+// Iterator
+//--------------------------------------
+
+// Moves to the first key that is greater than or equal to a key.
+func (i *memoryIterator) Seek(key []byte) {
+	i.node = memoryCeiling(i.root, key, true)
+}
+
+// Moves to the first key.
+func (i *memoryIterator) SeekToFirst() {
+	i.node = i.root
+	for i.node != nil && i.node.left != nil {
+		i.node = i.node.left
+	}
+}
+
+// Checks if the iterator is positioned at a key.
+func (i *memoryIterator) Valid() bool {
+	return i.node != nil
+}
+
+// Moves to the next key.
+func (i *memoryIterator) Next() {
+	if i.node != nil {
+		i.node = memoryCeiling(i.root, i.node.key, false)
+	}
+}
+
+// Retrieves the current key.
+func (i *memoryIterator) Key() []byte {
+	return copyBytes(i.node.key)
+}
+
+// Retrieves the current value.
+func (i *memoryIterator) Value() []byte {
+	return copyBytes(i.node.value)
+}
+
+// In-memory iterators never fail.
+func (i *memoryIterator) GetError() error {
+	return nil
+}
+
+// Releases the iterator.
+func (i *memoryIterator) Close() {
+	i.root, i.node = nil, nil
+}
@@ -0,0 +1,108 @@
+package skyd
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Ensure that the in-memory store can read and write keys.
+func TestMemoryStorage(t *testing.T) {
+	db, _ := NewMemoryEngine().Open("/tmp/memory")
+	defer db.Close()
+
+	db.Put([]byte("b"), []byte("2"))
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("c"), []byte("3"))
+	db.Delete([]byte("b"))
+	if value, err := db.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Fatalf("Unexpected value: %v (%v)", value, err)
+	}
+	if value, err := db.Get([]byte("b")); err != nil || value != nil {
+		t.Fatalf("Expected deleted key: %v (%v)", value, err)
+	}
+
+	// Snapshots shouldn't see later writes.
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	batch := db.NewBatch()
+	batch.Put([]byte("a"), []byte("4"))
+	batch.Put([]byte("d"), []byte("5"))
+	batch.Delete([]byte("c"))
+	if err := db.Write(batch, true); err != nil {
+		t.Fatalf("Unable to write batch: %v", err)
+	}
+	if value, _ := snapshot.Get([]byte("a")); string(value) != "1" {
+		t.Fatalf("Unexpected snapshot value: %s", value)
+	}
+	if keys := memoryTestKeys(snapshot.NewIterator(), nil); keys != "a=1,c=3," {
+		t.Fatalf("Unexpected snapshot keys: %v", keys)
+	}
+	if keys := memoryTestKeys(db.NewIterator(), nil); keys != "a=4,d=5," {
+		t.Fatalf("Unexpected keys: %v", keys)
+	}
+	if keys := memoryTestKeys(db.NewIterator(), []byte("b")); keys != "d=5," {
+		t.Fatalf("Unexpected keys after seek: %v", keys)
+	}
+}
+
+// Ensure that the in-memory store keeps keys sorted under many writes.
+func TestMemoryStorageOrdering(t *testing.T) {
+	db, _ := NewMemoryEngine().Open("/tmp/memory")
+	for i := 0; i < 1000; i++ {
+		db.Put([]byte(fmt.Sprintf("%04d", (i*7919)%1000)), []byte{})
+	}
+	for i := 0; i < 1000; i += 2 {
+		db.Delete([]byte(fmt.Sprintf("%04d", i)))
+	}
+
+	iterator := db.NewIterator()
+	defer iterator.Close()
+	count := 0
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		if expected := fmt.Sprintf("%04d", count*2+1); string(iterator.Key()) != expected {
+			t.Fatalf("Unexpected key: exp: %v, got: %s", expected, iterator.Key())
+		}
+		count++
+	}
+	if count != 500 {
+		t.Fatalf("Unexpected key count: %v", count)
+	}
+}
+
+// Ensure that a server can run entirely in memory.
+func TestServerMemoryEngine(t *testing.T) {
+	runTestServerWithEngine(NewMemoryEngine(), func(s *Server) {
+		setupTestTable("foo")
+		setupTestProperty("foo", "fruit", false, "factor")
+		setupTestData(t, "foo", [][]string{
+			[]string{"a0", "2012-01-01T00:00:00Z", `{"data":{"fruit":"apple"}}`},
+			[]string{"a1", "2012-01-01T00:00:00Z", `{"data":{"fruit":"grape"}}`},
+			[]string{"a1", "2012-01-01T00:00:01Z", `{}`},
+		})
+
+		query := `{
+			"steps":[
+				{"type":"selection","dimensions":[],"fields":[{"name":"count","expression":"count()"}]}
+			]
+		}`
+		resp, _ := sendTestHttpRequest("POST", "http://localhost:8586/tables/foo/query", "application/json", query)
+		assertResponse(t, resp, 200, `{"count":3}`+"\n", "POST /tables/:name/query failed.")
+		resp, _ = sendTestHttpRequest("DELETE", "http://localhost:8586/tables/foo", "application/json", "")
+		assertResponse(t, resp, 200, "", "DELETE /tables/:name failed.")
+	})
+}
+
+// Collects the keys and values of an iterator starting from a seek key.
+func memoryTestKeys(iterator StorageIterator, seek []byte) string {
+	defer iterator.Close()
+	str := ""
+	if seek == nil {
+		iterator.SeekToFirst()
+	} else {
+		iterator.Seek(seek)
+	}
+	for ; iterator.Valid(); iterator.Next() {
+		str += fmt.Sprintf("%s=%s,", iterator.Key(), iterator.Value())
+	}
+	return str
+}
@@ -1,14 +1,35 @@
 package skyd
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ugorji/go-msgpack"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// Validation modes control how event data is checked against property types.
+// Strict tables reject any value that doesn't match its property's data type.
+// Lenient tables also convert values that can be parsed safely, such as
+// numeric strings for integer properties.
+const (
+	StrictValidation  = "strict"
+	LenientValidation = "lenient"
+)
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -17,9 +38,26 @@ import (
 
 // A Table is a collection of objects.
 type Table struct {
-	Name         string `json:"name"`
-	path         string
-	propertyFile *PropertyFile
+	Name          string `json:"name"`
+	RetentionDays int    `json:"retentionDays,omitempty"`
+	Validation    string `json:"validation,omitempty"`
+	AutoCreate    bool   `json:"autoCreate,omitempty"`
+	AutoFactor    bool   `json:"autoFactor,omitempty"`
+	AutoPermanent bool   `json:"autoPermanent,omitempty"`
+	Compression   bool   `json:"compression,omitempty"`
+	path          string
+	propertyFile  *PropertyFile
+	mutex         sync.RWMutex
+}
+
+// The table settings that are persisted alongside the property file.
+type tableSettings struct {
+	RetentionDays int    `json:"retentionDays"`
+	Validation    string `json:"validation,omitempty"`
+	AutoCreate    bool   `json:"autoCreate,omitempty"`
+	AutoFactor    bool   `json:"autoFactor,omitempty"`
+	AutoPermanent bool   `json:"autoPermanent,omitempty"`
+	Compression   bool   `json:"compression,omitempty"`
 }
 
 //------------------------------------------------------------------------------
@@ -52,6 +90,11 @@ func (t *Table) Path() string {
 	return t.path
 }
 
+// Retrieves the path to the table's settings file.
+func (t *Table) SettingsPath() string {
+	return fmt.Sprintf("%v/%v", t.path, "settings")
+}
+
 //------------------------------------------------------------------------------
 //
 // Methods
@@ -108,6 +151,13 @@ func (t *Table) Open() error {
 		return err
 	}
 
+	// Load settings.
+	err = t.loadSettings()
+	if err != nil {
+		t.Close()
+		return err
+	}
+
 	return nil
 }
 
@@ -142,18 +192,196 @@ func TablePrefix(tableName string) ([]byte, error) {
 	return prefix[0 : len(prefix)-1], nil
 }
 
+//--------------------------------------
+// Settings
+//--------------------------------------
+
+// Sets the number of days of events to keep and saves the table's settings.
+// A value of zero keeps events forever.
+func (t *Table) SetRetentionDays(days int) error {
+	if days < 0 {
+		return fmt.Errorf("Invalid retention: %v", days)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.RetentionDays = days
+	return t.saveSettings()
+}
+
+// Sets how event data is validated against property data types and saves
+// the table's settings. An empty mode uses strict validation.
+func (t *Table) SetValidation(mode string) error {
+	switch mode {
+	case "", StrictValidation, LenientValidation:
+	default:
+		return fmt.Errorf("Invalid validation mode: %v", mode)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Validation = mode
+	return t.saveSettings()
+}
+
+// Checks if event data is converted when it doesn't match its data type.
+func (t *Table) IsLenient() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.isLenient()
+}
+
+func (t *Table) isLenient() bool {
+	return t.Validation == LenientValidation
+}
+
+// Checks if unknown keys in event data automatically create properties.
+func (t *Table) IsAutoCreate() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.AutoCreate
+}
+
+// Sets whether unknown keys in event data automatically create properties
+// and saves the table's settings. Strings create factor properties if
+// factor is true and string properties otherwise. Properties are transient
+// unless permanent is true.
+func (t *Table) SetAutoCreate(enabled bool, factor bool, permanent bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.AutoCreate = enabled
+	t.AutoFactor = factor
+	t.AutoPermanent = permanent
+	return t.saveSettings()
+}
+
+// Sets whether object values written to the table are compressed and saves
+// the table's settings. Existing values are left as they are and are
+// compressed or decompressed as objects are rewritten.
+func (t *Table) SetCompression(enabled bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Compression = enabled
+	return t.saveSettings()
+}
+
+// Checks if object values written to the table are compressed.
+func (t *Table) IsCompressed() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.Compression
+}
+
+// Calculates the time before which events should be removed. Returns a zero
+// time if the table keeps events forever.
+func (t *Table) RetentionCutoff(now time.Time) time.Time {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.RetentionDays <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(t.RetentionDays) * 24 * time.Hour)
+}
+
+// Reads the settings file from disk if one exists.
+func (t *Table) loadSettings() error {
+	file, err := os.Open(t.SettingsPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	settings := &tableSettings{}
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(settings); err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.RetentionDays = settings.RetentionDays
+	t.Validation = settings.Validation
+	t.AutoCreate = settings.AutoCreate
+	t.AutoFactor = settings.AutoFactor
+	t.AutoPermanent = settings.AutoPermanent
+	t.Compression = settings.Compression
+
+	return nil
+}
+
+// Retrieves a copy of the table's settings.
+func (t *Table) getSettings() *tableSettings {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.settings()
+}
+
+func (t *Table) settings() *tableSettings {
+	return &tableSettings{
+		RetentionDays: t.RetentionDays,
+		Validation:    t.Validation,
+		AutoCreate:    t.AutoCreate,
+		AutoFactor:    t.AutoFactor,
+		AutoPermanent: t.AutoPermanent,
+		Compression:   t.Compression,
+	}
+}
+
+// Writes the settings file to disk. The table must be locked for writing so
+// that only one save runs at a time.
+func (t *Table) saveSettings() error {
+	settings := t.settings()
+	return saveFile(t.SettingsPath(), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(settings)
+	})
+}
+
+// Encodes the table along with its settings.
+func (t *Table) MarshalJSON() ([]byte, error) {
+	settings := t.getSettings()
+	return json.Marshal(&struct {
+		Name          string `json:"name"`
+		RetentionDays int    `json:"retentionDays,omitempty"`
+		Validation    string `json:"validation,omitempty"`
+		AutoCreate    bool   `json:"autoCreate,omitempty"`
+		AutoFactor    bool   `json:"autoFactor,omitempty"`
+		AutoPermanent bool   `json:"autoPermanent,omitempty"`
+		Compression   bool   `json:"compression,omitempty"`
+	}{
+		Name:          t.Name,
+		RetentionDays: settings.RetentionDays,
+		Validation:    settings.Validation,
+		AutoCreate:    settings.AutoCreate,
+		AutoFactor:    settings.AutoFactor,
+		AutoPermanent: settings.AutoPermanent,
+		Compression:   settings.Compression,
+	})
+}
+
 //--------------------------------------
 // Property Management
 //--------------------------------------
 
 // Adds a property to the table.
 func (t *Table) CreateProperty(name string, transient bool, dataType string) (*Property, error) {
+	property, err := NewProperty(0, name, transient, dataType)
+	if err != nil {
+		return nil, err
+	}
+	return t.AddProperty(property)
+}
+
+// Adds a property that hasn't been added to a table yet. An identifier is
+// generated for it and the property file is saved.
+func (t *Table) AddProperty(property *Property) (*Property, error) {
 	if !t.IsOpen() {
 		return nil, errors.New("Table is not open")
 	}
 
-	// Create property on property file.
-	property, err := t.propertyFile.CreateProperty(name, transient, dataType)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Add property to property file.
+	property, err := t.propertyFile.AddProperty(property)
 	if err != nil {
 		return nil, err
 	}
@@ -172,6 +400,8 @@ func (t *Table) GetProperties() ([]*Property, error) {
 	if !t.IsOpen() {
 		return nil, errors.New("Table is not open")
 	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 	return t.propertyFile.GetProperties(), nil
 }
 
@@ -180,6 +410,8 @@ func (t *Table) GetProperty(id int64) (*Property, error) {
 	if !t.IsOpen() {
 		return nil, errors.New("Table is not open")
 	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 	return t.propertyFile.GetProperty(id), nil
 }
 
@@ -188,16 +420,133 @@ func (t *Table) GetPropertyByName(name string) (*Property, error) {
 	if !t.IsOpen() {
 		return nil, errors.New("Table is not open")
 	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 	return t.propertyFile.GetPropertyByName(name), nil
 }
 
-// Deletes a single property on the table.
-func (t *Table) DeleteProperty(property *Property) error {
+// Renames a property on the table and saves the property file. The renamed
+// property is returned.
+func (t *Table) RenameProperty(property *Property, name string) (*Property, error) {
+	if !t.IsOpen() {
+		return nil, errors.New("Table is not open")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	property, err := t.propertyFile.RenameProperty(property, name)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.propertyFile.Save(); err != nil {
+		return nil, err
+	}
+	return property, nil
+}
+
+// Replaces the metadata of a property on the table and saves the property
+// file. The updated property is returned.
+func (t *Table) SetPropertyMetadata(property *Property, metadata PropertyMetadata) (*Property, error) {
+	if !t.IsOpen() {
+		return nil, errors.New("Table is not open")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	property, err := t.propertyFile.SetPropertyMetadata(property, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.propertyFile.Save(); err != nil {
+		return nil, err
+	}
+	return property, nil
+}
+
+// Changes the data type or transience of a property on the table and saves
+// the property file. The changed property is returned with a migration that
+// must be run to convert its stored values.
+func (t *Table) ChangeProperty(property *Property, dataType string, transient bool) (*Property, error) {
+	if !t.IsOpen() {
+		return nil, errors.New("Table is not open")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	property, err := t.propertyFile.ChangeProperty(property, dataType, transient)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.propertyFile.Save(); err != nil {
+		return nil, err
+	}
+	return property, nil
+}
+
+// Renames a property, replaces its metadata and changes its data type or
+// transience on the table and saves the property file once. Nothing is
+// changed if any part of the update is invalid. The updated property is
+// returned with a migration if its data type or transience changed.
+func (t *Table) UpdateProperty(property *Property, name string, metadata PropertyMetadata, dataType string, transient bool) (*Property, error) {
+	if !t.IsOpen() {
+		return nil, errors.New("Table is not open")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	property, err := t.propertyFile.UpdateProperty(property, name, metadata, dataType, transient)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.propertyFile.Save(); err != nil {
+		return nil, err
+	}
+	return property, nil
+}
+
+// Marks the migration of a property on the table as complete and saves the
+// property file.
+func (t *Table) CompletePropertyMigration(property *Property) (*Property, error) {
+	if !t.IsOpen() {
+		return nil, errors.New("Table is not open")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	property, err := t.propertyFile.CompleteMigration(property)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.propertyFile.Save(); err != nil {
+		return nil, err
+	}
+	return property, nil
+}
+
+// Deletes a single property on the table. The unnamed placeholder that
+// replaces it is returned so that its values can be purged.
+func (t *Table) DeleteProperty(property *Property) (*Property, error) {
+	if !t.IsOpen() {
+		return nil, errors.New("Table is not open")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.propertyFile.DeleteProperty(property)
+}
+
+// Removes the placeholder of a deleted property once its values have been
+// purged and saves the property file.
+func (t *Table) CompletePropertyPurge(id int64) error {
 	if !t.IsOpen() {
 		return errors.New("Table is not open")
 	}
-	t.propertyFile.DeleteProperty(property)
-	return nil
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := t.propertyFile.CompletePurge(id); err != nil {
+		return err
+	}
+	return t.propertyFile.Save()
 }
 
 // Saves the property file on the table.
@@ -205,16 +554,22 @@ func (t *Table) SavePropertyFile() error {
 	if !t.IsOpen() {
 		return errors.New("Table is not open")
 	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 	return t.propertyFile.Save()
 }
 
 // Converts a map with string keys to use property identifier keys.
 func (t *Table) NormalizeMap(m map[string]interface{}) (map[int64]interface{}, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 	return t.propertyFile.NormalizeMap(m)
 }
 
 // Converts a map with property identifier keys to use string keys.
 func (t *Table) DenormalizeMap(m map[int64]interface{}) (map[string]interface{}, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 	return t.propertyFile.DenormalizeMap(m)
 }
 
@@ -244,7 +599,13 @@ func (t *Table) DeserializeEvent(m map[string]interface{}) (*Event, error) {
 
 	// Convert maps to use property identifiers.
 	if data, ok := m["data"].(map[string]interface{}); ok {
-		normalizedData, err := t.NormalizeMap(data)
+		if t.IsAutoCreate() {
+			data = t.removeUnknownNulls(data)
+			if err := t.createMissingProperties(data); err != nil {
+				return nil, err
+			}
+		}
+		normalizedData, err := t.normalizeEventData(data)
 		if err != nil {
 			return nil, err
 		}
@@ -254,6 +615,129 @@ func (t *Table) DeserializeEvent(m map[string]interface{}) (*Event, error) {
 	return event, nil
 }
 
+// Converts event data to use property identifiers and validates each value
+// against its property's data type.
+func (t *Table) normalizeEventData(data map[string]interface{}) (map[int64]interface{}, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	normalizedData, err := t.propertyFile.NormalizeMap(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.propertyFile.CastMap(normalizedData, t.isLenient()); err != nil {
+		return nil, err
+	}
+	return normalizedData, nil
+}
+
+// Removes null values for keys that don't have a property since a type can't
+// be inferred from them. The data is copied if anything is removed.
+func (t *Table) removeUnknownNulls(data map[string]interface{}) map[string]interface{} {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var clone map[string]interface{}
+	for name, value := range data {
+		if value != nil || t.propertyFile.GetPropertyByName(name) != nil {
+			continue
+		}
+		if clone == nil {
+			clone = make(map[string]interface{})
+			for k, v := range data {
+				clone[k] = v
+			}
+		}
+		delete(clone, name)
+	}
+	if clone == nil {
+		return data
+	}
+	return clone
+}
+
+// Creates a property for each key in event data that doesn't have one. The
+// data type is inferred from the value. The table is locked while properties
+// are created so concurrent writers can't create the same property twice.
+func (t *Table) createMissingProperties(data map[string]interface{}) error {
+	// Check for missing properties without blocking other writers.
+	t.mutex.RLock()
+	missing := false
+	for name, value := range data {
+		if value != nil && t.propertyFile.GetPropertyByName(name) == nil {
+			missing = true
+			break
+		}
+	}
+	t.mutex.RUnlock()
+	if !missing {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Create properties in name order so identifiers are deterministic.
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	created := false
+	for _, name := range names {
+		if data[name] == nil || t.propertyFile.GetPropertyByName(name) != nil {
+			continue
+		}
+		dataType, err := t.inferDataType(data[name])
+		if err != nil {
+			return fmt.Errorf("Unable to create property %v: %v", name, err)
+		}
+		if _, err = t.propertyFile.CreateProperty(name, !t.AutoPermanent, dataType); err != nil {
+			return err
+		}
+		created = true
+	}
+	if created {
+		return t.propertyFile.Save()
+	}
+	return nil
+}
+
+// Determines the data type of a property from a JSON value. JSON numbers
+// written with a fraction or exponent are inferred as floats and all other
+// numbers as integers. Lists of strings are inferred as factor lists.
+func (t *Table) inferDataType(value interface{}) (string, error) {
+	if n, ok := value.(json.Number); ok {
+		if strings.ContainsAny(string(n), ".eE") {
+			return FloatDataType, nil
+		}
+		return IntegerDataType, nil
+	}
+
+	switch v := normalize(value).(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if _, ok := elem.(string); !ok {
+				return "", fmt.Errorf("Unable to infer data type: %v", value)
+			}
+		}
+		return FactorListDataType, nil
+	case string:
+		if t.AutoFactor {
+			return FactorDataType, nil
+		}
+		return StringDataType, nil
+	case int64:
+		return IntegerDataType, nil
+	case float64:
+		return FloatDataType, nil
+	case bool:
+		return BooleanDataType, nil
+	}
+	return "", fmt.Errorf("Unable to infer data type: %v", value)
+}
+
 // Serializes a normalized event into a map.
 func (t *Table) SerializeEvent(event *Event) (map[string]interface{}, error) {
 	m := make(map[string]interface{})
@@ -285,9 +769,16 @@ func (t *Table) FactorizeEvent(event *Event, factors *Factors, createIfMissing b
 		return nil
 	}
 
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	// Values of purged properties are left alone.
 	propertyFile := t.propertyFile
 	for k, v := range event.Data {
 		property := propertyFile.GetProperty(k)
+		if property == nil {
+			continue
+		}
 		if property.DataType == FactorDataType {
 			if stringValue, ok := v.(string); ok {
 				sequence, err := factors.Factorize(t.Name, property.Name, stringValue, createIfMissing)
@@ -296,6 +787,22 @@ func (t *Table) FactorizeEvent(event *Event, factors *Factors, createIfMissing b
 				}
 				event.Data[k] = sequence
 			}
+		} else if property.DataType == FactorListDataType {
+			if list, ok := v.([]interface{}); ok {
+				sequences := make([]interface{}, len(list))
+				for i, elem := range list {
+					if stringValue, ok := elem.(string); ok {
+						sequence, err := factors.Factorize(t.Name, property.Name, stringValue, createIfMissing)
+						if err != nil {
+							return err
+						}
+						sequences[i] = sequence
+					} else {
+						sequences[i] = elem
+					}
+				}
+				event.Data[k] = sequences
+			}
 		}
 	}
 
@@ -308,17 +815,57 @@ func (t *Table) DefactorizeEvent(event *Event, factors *Factors) error {
 		return nil
 	}
 
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	// Values that haven't been migrated yet are read under the property's
+	// new identifier and converted to its new data type. Values written
+	// under the new identifier take precedence.
 	propertyFile := t.propertyFile
 	for k, v := range event.Data {
 		property := propertyFile.GetProperty(k)
-		if property.DataType == FactorDataType {
-			if sequence, ok := v.(uint64); ok {
-				stringValue, err := factors.Defactorize(t.Name, property.Name, sequence)
+		if property == nil || property.Name != "" || property.Deleted {
+			continue
+		}
+		delete(event.Data, k)
+		if migrating := propertyFile.GetMigratingProperty(k); migrating != nil {
+			if _, ok := event.Data[migrating.Id]; !ok {
+				if value, ok := migrating.Migration.cast(t.Name, migrating, factors, v); ok && value != nil {
+					event.Data[migrating.Id] = value
+				}
+			}
+		}
+	}
+
+	// Placeholders of deleted properties are skipped until they're purged.
+	// Values left behind by a purged property are skipped as well.
+	for k, v := range event.Data {
+		property := propertyFile.GetProperty(k)
+		if property == nil {
+			continue
+		}
+		if property.DataType == FactorDataType && property.Name != "" {
+			// Decoded values are normalized to signed integers.
+			if sequence, ok := normalize(v).(int64); ok {
+				stringValue, err := factors.Defactorize(t.Name, property.Name, uint64(sequence))
 				if err != nil {
 					return err
 				}
 				event.Data[k] = stringValue
 			}
+		} else if property.DataType == FactorListDataType && property.Name != "" {
+			if list, ok := normalize(v).([]interface{}); ok {
+				for i, elem := range list {
+					if sequence, ok := elem.(int64); ok {
+						stringValue, err := factors.Defactorize(t.Name, property.Name, uint64(sequence))
+						if err != nil {
+							return err
+						}
+						list[i] = stringValue
+					}
+				}
+				event.Data[k] = list
+			}
 		}
 	}
 
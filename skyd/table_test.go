@@ -1,10 +1,14 @@
 package skyd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Ensure that we can create a new table.
@@ -39,3 +43,204 @@ func TestTableCreateProperty(t *testing.T) {
 		t.Fatalf("Invalid properties file:\n%v", string(content))
 	}
 }
+
+// Ensure that a table's retention period is saved and reloaded.
+func TestTableRetention(t *testing.T) {
+	table := createTempTable(t)
+	table.Open()
+	if err := table.SetRetentionDays(180); err != nil {
+		t.Fatalf("Unable to set retention: %v", err)
+	}
+	table.Close()
+
+	table = NewTable("test", table.Path())
+	table.Open()
+	defer table.Close()
+	if table.RetentionDays != 180 {
+		t.Fatalf("Unexpected retention: %v", table.RetentionDays)
+	}
+	if err := table.SetRetentionDays(-1); err == nil {
+		t.Fatalf("Expected negative retention to fail")
+	}
+	if _, err := os.Stat(table.SettingsPath() + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Temporary settings file left behind: %v", err)
+	}
+}
+
+// Ensure that settings can be changed while they're being read.
+func TestTableSettingsConcurrency(t *testing.T) {
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			table.SetRetentionDays(i)
+			table.SetCompression(i%2 == 0)
+		}(i)
+		go func() {
+			defer wg.Done()
+			table.RetentionCutoff(time.Now())
+			table.IsCompressed()
+			json.Marshal(table)
+		}()
+	}
+	wg.Wait()
+
+	reloaded := NewTable("test", table.Path())
+	if err := reloaded.Open(); err != nil {
+		t.Fatalf("Unable to reload table: %v", err)
+	}
+	defer reloaded.Close()
+	if reloaded.RetentionDays != table.RetentionDays || reloaded.Compression != table.Compression {
+		t.Fatalf("Unexpected settings: %v", reloaded.getSettings())
+	}
+}
+
+// Ensure that values that haven't been migrated are read under the
+// property's new identifier and data type.
+func TestTableDefactorizeEventMigratingProperty(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	factors := NewFactors(path)
+	factors.Open()
+	defer factors.Close()
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	size, _ := table.CreateProperty("size", false, "string")
+	color, _ := table.CreateProperty("color", false, "factor")
+	sequence, _ := factors.Factorize(table.Name, "color", "red", true)
+	size, _ = table.ChangeProperty(size, "integer", false)
+	color, _ = table.ChangeProperty(color, "string", false)
+
+	event := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "10", 2: sequence})
+	if err := table.DefactorizeEvent(event, factors); err != nil {
+		t.Fatalf("Unable to defactorize event: %v", err)
+	}
+	if m, err := table.SerializeEvent(event); err != nil || fmt.Sprint(m["data"]) != "map[color:red size:10]" {
+		t.Fatalf("Unexpected serialized event: %v (%v)", m, err)
+	}
+
+	// Values written since the change take precedence.
+	event = NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "10", size.Id: int64(20)})
+	if err := table.DefactorizeEvent(event, factors); err != nil || len(event.Data) != 1 || event.Data[size.Id] != int64(20) {
+		t.Fatalf("Unexpected defactorized event: %v (%v)", event.Data, err)
+	}
+
+	// Values are read as they're stored once the migration is complete.
+	table.CompletePropertyMigration(color)
+	event = NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{color.Id: "blue"})
+	if err := table.DefactorizeEvent(event, factors); err != nil || event.Data[color.Id] != "blue" {
+		t.Fatalf("Unexpected defactorized event: %v (%v)", event.Data, err)
+	}
+}
+
+// Ensure that the values of a deleted property are skipped while they're
+// purged and that any left behind afterward are reported.
+func TestTableFactorizeEventPurgedProperty(t *testing.T) {
+	path, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(path)
+	factors := NewFactors(path)
+	factors.Open()
+	defer factors.Close()
+	table := createTempTable(t)
+	table.Open()
+	defer table.Close()
+
+	property, _ := table.CreateProperty("color", false, "factor")
+	table.DeleteProperty(property)
+	event := NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: uint64(1)})
+	if err := table.DefactorizeEvent(event, factors); err != nil || event.Data[1] != uint64(1) {
+		t.Fatalf("Unexpected defactorized event: %v (%v)", event.Data, err)
+	}
+	if m, err := table.SerializeEvent(event); err != nil || len(m["data"].(map[string]interface{})) != 0 {
+		t.Fatalf("Unexpected serialized event: %v (%v)", m, err)
+	}
+
+	if err := table.CompletePropertyPurge(property.Id); err != nil {
+		t.Fatalf("Unable to complete purge: %v", err)
+	}
+	event = NewEvent("2012-01-01T00:00:00Z", map[int64]interface{}{1: "red"})
+	if err := table.FactorizeEvent(event, factors, true); err != nil || event.Data[1] != "red" {
+		t.Fatalf("Unexpected factorized event: %v (%v)", event.Data, err)
+	}
+	if _, err := table.SerializeEvent(event); err == nil || err.Error() != "skyd.PropertyFile: Property not found: 1" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// Ensure that property metadata is saved and enforced on events.
+func TestTablePropertyMetadata(t *testing.T) {
+	table := createTempTable(t)
+	table.Open()
+	property, _ := NewProperty(0, "color", false, "factor")
+	nullable := false
+	if err := property.SetMetadata(PropertyMetadata{Description: "Shirt color", Enum: []interface{}{"red", "blue"}, Nullable: &nullable, Default: "red"}, false); err != nil {
+		t.Fatalf("Unable to set metadata: %v", err)
+	}
+	table.AddProperty(property)
+	property, _ = table.CreateProperty("size", false, "integer")
+	if _, err := table.SetPropertyMetadata(property, PropertyMetadata{Unit: "cm", Enum: []interface{}{float64(10), float64(20)}}); err != nil {
+		t.Fatalf("Unable to set metadata: %v", err)
+	}
+	if _, err := table.SetPropertyMetadata(property, PropertyMetadata{Enum: []interface{}{"large"}}); err == nil || err.Error() != "Invalid enum value for property size: large" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	table.Close()
+
+	content, _ := ioutil.ReadFile(fmt.Sprintf("%v/properties", table.Path()))
+	if string(content) != `[{"id":1,"name":"color","transient":false,"dataType":"factor","description":"Shirt color","enum":["red","blue"],"nullable":false,"default":"red"},{"id":2,"name":"size","transient":false,"dataType":"integer","unit":"cm","enum":[10,20]}]`+"\n" {
+		t.Fatalf("Invalid properties file:\n%v", string(content))
+	}
+
+	// Reopen the table and write events against the metadata.
+	table = NewTable("test", table.Path())
+	table.Open()
+	defer table.Close()
+	event, err := table.DeserializeEvent(map[string]interface{}{"timestamp": "2012-01-01T00:00:00Z", "data": map[string]interface{}{"color": nil, "size": float64(20)}})
+	if err != nil {
+		t.Fatalf("Unable to deserialize event: %v", err)
+	}
+	if event.Data[1] != "red" || event.Data[2] != int64(20) {
+		t.Fatalf("Unexpected event data: %v", event.Data)
+	}
+	_, err = table.DeserializeEvent(map[string]interface{}{"timestamp": "2012-01-01T00:00:00Z", "data": map[string]interface{}{"color": "green", "size": float64(15)}})
+	if err == nil || err.Error() != "Value not allowed for property color: green; Value not allowed for property size: 15" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// Ensure that timestamp metadata is still enforced after the table is
+// reopened.
+func TestTableTimestampMetadata(t *testing.T) {
+	table := createTempTable(t)
+	table.Open()
+	property, _ := table.CreateProperty("at", false, "timestamp")
+	if _, err := table.SetPropertyMetadata(property, PropertyMetadata{Enum: []interface{}{"2012-01-01T00:00:00Z", "2012-01-02T00:00:00Z"}, Default: "2012-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Unable to set metadata: %v", err)
+	}
+	table.Close()
+
+	table = NewTable("test", table.Path())
+	table.Open()
+	defer table.Close()
+	event, err := table.DeserializeEvent(map[string]interface{}{"timestamp": "2012-01-01T00:00:00Z", "data": map[string]interface{}{"at": nil}})
+	if err != nil {
+		t.Fatalf("Unable to deserialize event: %v", err)
+	}
+	if event.Data[1] != ShiftTime(time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected event data: %v", event.Data)
+	}
+	if _, err = table.DeserializeEvent(map[string]interface{}{"timestamp": "2012-01-01T00:00:00Z", "data": map[string]interface{}{"at": "2012-01-02T00:00:00Z"}}); err != nil {
+		t.Fatalf("Unable to deserialize event: %v", err)
+	}
+	_, err = table.DeserializeEvent(map[string]interface{}{"timestamp": "2012-01-01T00:00:00Z", "data": map[string]interface{}{"at": "2012-01-03T00:00:00Z"}})
+	if err == nil || !strings.HasPrefix(err.Error(), "Value not allowed for property at: ") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
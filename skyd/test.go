@@ -24,6 +24,17 @@ func assertProperty(t *testing.T, property *Property, id int64, name string, tra
 	}
 }
 
+func assertEvents(t *testing.T, events []*Event, expected []*Event) {
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected event count. Expected %v, got %v", len(expected), len(events))
+	}
+	for i := range events {
+		if !events[i].Equal(expected[i]) {
+			t.Fatalf("Unexpected event %d. Expected %v, got %v", i, expected[i], events[i])
+		}
+	}
+}
+
 func assertResponse(t *testing.T, resp *http.Response, statusCode int, content string, message string) {
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
@@ -40,9 +51,14 @@ func sendTestHttpRequest(method string, url string, contentType string, body str
 }
 
 func runTestServer(f func(s *Server)) {
+	runTestServerWithEngine(nil, f)
+}
+
+func runTestServerWithEngine(engine StorageEngine, f func(s *Server)) {
 	path, _ := ioutil.TempDir("", "")
 	defer os.RemoveAll(path)
 	server := NewServer(8586, path)
+	server.SetStorageEngine(engine)
 	server.Silence()
 	server.ListenAndServe(nil)
 	defer server.Shutdown()
@@ -50,10 +66,14 @@ func runTestServer(f func(s *Server)) {
 }
 
 func createTempTable(t *testing.T) *Table {
+	return createTempNamedTable(t, "test")
+}
+
+func createTempNamedTable(t *testing.T, name string) *Table {
 	path, err := ioutil.TempDir("", "")
 	os.RemoveAll(path)
 
-	table := NewTable("test", path)
+	table := NewTable(name, path)
 	err = table.Create()
 	if err != nil {
 		t.Fatalf("Unable to create table: %v", err)
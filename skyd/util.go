@@ -5,7 +5,8 @@ import (
 	"os"
 )
 
-// Converts untyped map to a map[string]interface{} if passed a map.
+// Converts untyped maps to map[string]interface{}, including maps nested in
+// slices.
 func ConvertToStringKeys(value interface{}) interface{} {
 	if m, ok := value.(map[interface{}]interface{}); ok {
 		ret := make(map[string]interface{})
@@ -14,6 +15,13 @@ func ConvertToStringKeys(value interface{}) interface{} {
 		}
 		return ret
 	}
+	if a, ok := value.([]interface{}); ok {
+		ret := make([]interface{}, len(a))
+		for i, v := range a {
+			ret[i] = ConvertToStringKeys(v)
+		}
+		return ret
+	}
 
 	return value
 }